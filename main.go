@@ -1,16 +1,27 @@
 package main
 
 import (
+	"context"
+	"encoding/json"
+	"flag"
 	"fmt"
 	"io"
+	"io/ioutil"
 	"log"
 	"os"
 	"os/signal"
+	"strconv"
+	"strings"
 	"syscall"
+	"time"
 
 	"github.com/pkg/errors"
 
+	"github.com/fgahr/termchan/tchan"
+	"github.com/fgahr/termchan/tchan/backend"
+	"github.com/fgahr/termchan/tchan/client"
 	"github.com/fgahr/termchan/tchan/config"
+	"github.com/fgahr/termchan/tchan/gopher"
 	"github.com/fgahr/termchan/tchan/http"
 	"github.com/fgahr/termchan/tchan/output"
 )
@@ -21,6 +32,17 @@ var commands map[string]command = map[string]command{
 	"dump-config":      dumpConfig,
 	"create-templates": createTemplates,
 	"serve-http":       serveHTTP,
+	"serve-gopher":     serveGopher,
+	"mod":              moderate,
+	"ban":              ban,
+	"filter-test":      filterTest,
+	"migrate":          migrateSchemas,
+	"fsck":             fsck,
+	"merge-boards":     mergeBoards,
+	"browse":           browse,
+	"read":             readThread,
+	"post":             postThread,
+	"reply":            postReply,
 }
 
 func usage(out io.Writer) {
@@ -36,6 +58,34 @@ commands:
   dump-config         Write the current configuration to stdout; can be used to populate a default config
   create-templates    Place the default templates; will not overwrite existing files
   serve-http          Run as an http service
+  serve-gopher        Run as a Gopher service on the configured gopher.address
+  migrate [-dry-run]  Upgrade all databases to the current schema, which also
+                      happens on startup; -dry-run only lists pending migrations
+  fsck [-dry-run]     Find and repair orphaned threads, posts without a thread and
+                      incorrect reply counts or activity; -dry-run only reports them
+  merge-boards        Copy the per-board databases into a single one, keeping all
+                      post IDs; requires the "single" database layout
+  mod <action> <post> Moderate a post, e.g. 'mod delete /b/42'; actions are
+                      delete, lock, unlock, sticky and unsticky
+  ban add [-board b] [-duration d] [-reason r] <ip|cidr>
+                      Ban an address or range from posting; bans are global
+                      and permanent unless a board or duration (e.g. 72h) is given
+  ban list [-all]     List bans in effect; -all includes lifted and expired bans
+  ban lift <id>       Lift a ban before it expires
+  filter-test [-board b] [-regex] [-reject] [-replacement r] <pattern>
+                      Show which existing posts a new filter would affect,
+                      on one or all boards, without changing anything
+  browse <url>        Browse a server interactively, e.g. 'browse localhost:8088';
+                      use unix:/path/to/socket for domain sockets
+  read [-server url] [-json] <post>
+                      Show the thread with a post in it, e.g. 'read /g/42'
+  post [-server url] [-json] [-topic t] [-name n] <board>
+                      Create a thread with content from stdin, e.g.
+                      'post /g -topic hello < file', and show it
+  reply [-server url] [-json] [-name n] <post>
+                      Reply to a thread with content from stdin and show it
+                      read, post and reply use the local databases unless a
+                      server is given, in the same form as for browse
 
 `)
 }
@@ -58,18 +108,34 @@ func serveHTTP(conf config.Settings, cmd string, args ...string) error {
 		return err
 	}
 
+	defer handleSignals(srv.ReloadConfig, srv.Stop)()
+	return srv.ServeHTTP()
+}
+
+func serveGopher(conf config.Settings, cmd string, args ...string) error {
+	srv, err := gopher.NewServer(&conf)
+	if err != nil {
+		return err
+	}
+
+	defer handleSignals(srv.ReloadConfig, srv.Stop)()
+	return srv.ListenAndServe()
+}
+
+// handleSignals reloads on SIGHUP and stops on SIGINT or SIGTERM until the
+// returned function is called.
+func handleSignals(reload func() error, stop func() error) func() {
 	sigChan := make(chan os.Signal, 1)
 	signal.Notify(sigChan, syscall.SIGHUP, syscall.SIGINT, syscall.SIGTERM)
-	defer close(sigChan)
 	go func() {
 		for sig := range sigChan {
 			log.Printf("caught signal: %v", sig)
 			var err error
 			switch sig {
 			case syscall.SIGHUP:
-				err = srv.ReloadConfig()
+				err = reload()
 			case syscall.SIGINT, syscall.SIGTERM:
-				err = srv.Stop()
+				err = stop()
 			default:
 				err = errors.Errorf("Unexpected signal: %v", sig)
 			}
@@ -80,7 +146,451 @@ func serveHTTP(conf config.Settings, cmd string, args ...string) error {
 		}
 	}()
 
-	return srv.ServeHTTP()
+	return func() {
+		signal.Stop(sigChan)
+		close(sigChan)
+	}
+}
+
+// parsePostPath splits a path of the form /board/id.
+func parsePostPath(path string) (string, int64, error) {
+	parts := strings.Split(strings.Trim(path, "/"), "/")
+	if len(parts) != 2 || parts[0] == "" {
+		return "", 0, errors.Errorf("not a post path: %s", path)
+	}
+	id, err := strconv.ParseInt(parts[1], 10, 64)
+	if err != nil {
+		return "", 0, errors.Errorf("invalid post ID: %s", parts[1])
+	}
+	return parts[0], id, nil
+}
+
+func openBackend(conf *config.Settings) (backend.DB, error) {
+	db, err := backend.New(conf)
+	if err != nil {
+		return nil, err
+	}
+	if err := db.Init(); err != nil {
+		return nil, errors.Wrap(err, "backend setup failed")
+	}
+	return db, nil
+}
+
+func moderate(conf config.Settings, cmd string, args ...string) error {
+	if len(args) != 2 {
+		return errors.Errorf("%s: action and post required, e.g. %s delete /b/42", cmd, cmd)
+	}
+	action := args[0]
+	board, postID, err := parsePostPath(args[1])
+	if err != nil {
+		return errors.Wrap(err, cmd)
+	}
+
+	db, err := openBackend(&conf)
+	if err != nil {
+		return err
+	}
+	defer db.Close()
+
+	ok := false
+	if err := backend.Moderate(context.Background(), db, action, board, postID, &ok); err != nil {
+		return errors.Wrapf(err, "%s: %s failed", cmd, action)
+	}
+	if !ok {
+		return errors.Errorf("%s: no such post: /%s/%d", cmd, board, postID)
+	}
+	log.Printf("%s: /%s/%d", action, board, postID)
+	return nil
+}
+
+func ban(conf config.Settings, cmd string, args ...string) error {
+	if len(args) == 0 {
+		return errors.Errorf("%s: subcommand required, one of add, list, lift", cmd)
+	}
+	sub, args := args[0], args[1:]
+	flags := flag.NewFlagSet(cmd+" "+sub, flag.ContinueOnError)
+
+	switch sub {
+	case "add":
+		board := flags.String("board", "", "board to ban from, all boards if empty")
+		duration := flags.Duration("duration", 0, "duration of the ban, permanent if 0")
+		reason := flags.String("reason", "", "reason given to the banned user")
+		if err := flags.Parse(args); err != nil {
+			return err
+		}
+		if flags.NArg() != 1 || !tchan.ValidBanTarget(flags.Arg(0)) {
+			return errors.Errorf("%s %s: single IP address or CIDR range required", cmd, sub)
+		}
+		if _, ok := conf.BoardConfig(*board); *board != "" && !ok {
+			return errors.Errorf("%s %s: no such board: %s", cmd, sub, *board)
+		}
+		if *duration < 0 {
+			return errors.Errorf("%s %s: negative duration: %v", cmd, sub, *duration)
+		}
+
+		b := tchan.Ban{Target: flags.Arg(0), Board: *board, Reason: *reason}
+		if *duration > 0 {
+			expires := time.Now().Add(*duration)
+			b.Expires = &expires
+		}
+
+		db, err := openBackend(&conf)
+		if err != nil {
+			return err
+		}
+		defer db.Close()
+
+		if err := db.AddBan(context.Background(), &b); err != nil {
+			return errors.Wrapf(err, "%s %s failed", cmd, sub)
+		}
+		log.Printf("ban #%d: %s", b.ID, b.Target)
+	case "list":
+		all := flags.Bool("all", false, "include lifted and expired bans")
+		if err := flags.Parse(args); err != nil {
+			return err
+		}
+
+		db, err := openBackend(&conf)
+		if err != nil {
+			return err
+		}
+		defer db.Close()
+
+		var bans []tchan.Ban
+		if err := db.ListBans(context.Background(), *all, &bans); err != nil {
+			return errors.Wrapf(err, "%s %s failed", cmd, sub)
+		}
+		for _, b := range bans {
+			board, expires := "*", "never"
+			if b.Board != "" {
+				board = "/" + b.Board + "/"
+			}
+			if b.Expires != nil {
+				expires = b.Expires.Format(time.RFC3339)
+			}
+			fmt.Printf("%d\t%s\t%s\t%s\t%s\t%s\n", b.ID, b.Target, board,
+				b.Created.Format(time.RFC3339), expires, b.Reason)
+		}
+	case "lift":
+		if err := flags.Parse(args); err != nil {
+			return err
+		}
+		if flags.NArg() != 1 {
+			return errors.Errorf("%s %s: ban ID required", cmd, sub)
+		}
+		banID, err := strconv.ParseInt(flags.Arg(0), 10, 64)
+		if err != nil {
+			return errors.Errorf("%s %s: invalid ban ID: %s", cmd, sub, flags.Arg(0))
+		}
+
+		db, err := openBackend(&conf)
+		if err != nil {
+			return err
+		}
+		defer db.Close()
+
+		ok := false
+		if err := db.LiftBan(context.Background(), banID, &ok); err != nil {
+			return errors.Wrapf(err, "%s %s failed", cmd, sub)
+		}
+		if !ok {
+			return errors.Errorf("%s %s: no such ban in effect: %d", cmd, sub, banID)
+		}
+		log.Printf("lifted ban #%d", banID)
+	default:
+		return errors.Errorf("%s: no such subcommand: %s", cmd, sub)
+	}
+	return nil
+}
+
+func migrateSchemas(conf config.Settings, cmd string, args ...string) error {
+	flags := flag.NewFlagSet(cmd, flag.ContinueOnError)
+	dryRun := flags.Bool("dry-run", false, "only list pending migrations")
+	if err := flags.Parse(args); err != nil {
+		return err
+	}
+
+	statuses, err := backend.CheckSchemas(&conf)
+	if err != nil {
+		return errors.Wrapf(err, "%s failed", cmd)
+	}
+	pending := 0
+	for _, st := range statuses {
+		if !st.Exists {
+			fmt.Printf("%s: to be created\n", st.Path)
+		} else if len(st.Pending) == 0 {
+			fmt.Printf("%s: version %d, up to date\n", st.Path, st.Version)
+			continue
+		} else {
+			fmt.Printf("%s: version %d\n", st.Path, st.Version)
+		}
+		for _, m := range st.Pending {
+			fmt.Printf("  %s\n", m)
+		}
+		pending += len(st.Pending)
+	}
+
+	if *dryRun || pending == 0 {
+		return nil
+	}
+	// Migrations are applied during initialization
+	db, err := openBackend(&conf)
+	if err != nil {
+		return err
+	}
+	log.Printf("%s: applied %d migrations", cmd, pending)
+	return db.Close()
+}
+
+func fsck(conf config.Settings, cmd string, args ...string) error {
+	flags := flag.NewFlagSet(cmd, flag.ContinueOnError)
+	dryRun := flags.Bool("dry-run", false, "only report inconsistencies")
+	if err := flags.Parse(args); err != nil {
+		return err
+	}
+
+	reports, err := backend.Fsck(&conf, *dryRun)
+	if err != nil {
+		return errors.Wrapf(err, "%s failed", cmd)
+	}
+	repaired := 0
+	for _, r := range reports {
+		if r.Clean() {
+			fmt.Printf("/%s/: ok\n", r.Board)
+			continue
+		}
+		fmt.Printf("/%s/:\n", r.Board)
+		if len(r.OrphanedThreads) > 0 {
+			fmt.Printf("  orphaned threads: %v\n", r.OrphanedThreads)
+		}
+		if len(r.DanglingPosts) > 0 {
+			fmt.Printf("  posts without thread: %v\n", r.DanglingPosts)
+		}
+		if len(r.InconsistentThreads) > 0 {
+			fmt.Printf("  inconsistent threads: %v\n", r.InconsistentThreads)
+		}
+		repaired++
+	}
+
+	if !*dryRun && repaired > 0 {
+		log.Printf("%s: repaired %d boards", cmd, repaired)
+	}
+	return nil
+}
+
+func mergeBoards(conf config.Settings, cmd string, args ...string) error {
+	reports, err := backend.MergeBoards(&conf)
+	for _, r := range reports {
+		fmt.Printf("/%s/: %d threads, %d posts\n", r.Board, r.Threads, r.Posts)
+	}
+	if err != nil {
+		return errors.Wrapf(err, "%s failed", cmd)
+	}
+	log.Printf("%s: merged %d boards into %s", cmd, len(reports), conf.SingleDatabase())
+	return nil
+}
+
+func filterTest(conf config.Settings, cmd string, args ...string) error {
+	flags := flag.NewFlagSet(cmd, flag.ContinueOnError)
+	board := flags.String("board", "", "board to check, all boards if empty")
+	f := tchan.Filter{}
+	flags.BoolVar(&f.Regex, "regex", false, "treat the pattern as a regular expression")
+	flags.BoolVar(&f.Reject, "reject", false, "reject matching posts instead of replacing matches")
+	flags.StringVar(&f.Replacement, "replacement", "", "replacement for matches")
+	if err := flags.Parse(args); err != nil {
+		return err
+	}
+	if flags.NArg() != 1 {
+		return errors.Errorf("%s: single pattern required", cmd)
+	}
+	f.Pattern = flags.Arg(0)
+	if err := f.Compile(); err != nil {
+		return errors.Wrap(err, cmd)
+	}
+
+	boards := conf.Boards
+	if *board != "" {
+		bc, ok := conf.BoardConfig(*board)
+		if !ok {
+			return errors.Errorf("%s: no such board: %s", cmd, *board)
+		}
+		boards = []tchan.Board{bc}
+	}
+
+	db, err := backend.OpenReadOnly(&conf)
+	if err != nil {
+		return errors.Wrap(err, cmd)
+	}
+	defer db.Close()
+
+	hits := 0
+	for _, b := range boards {
+		var posts []tchan.Post
+		ok := false
+		if err := db.PopulatePosts(context.Background(), b.Name, &posts, &ok); err != nil {
+			return errors.Wrapf(err, "%s failed", cmd)
+		}
+		for _, p := range posts {
+			if !f.Matches(p.Content) {
+				continue
+			}
+			hits++
+			if f.Reject {
+				fmt.Printf("/%s/%d: rejected\n%s\n\n", b.Name, p.ID, p.Content)
+			} else {
+				fmt.Printf("/%s/%d: replaced\n%s\n\n", b.Name, p.ID, f.Apply(p.Content))
+			}
+		}
+	}
+	log.Printf("%s: %d matching posts", cmd, hits)
+	return nil
+}
+
+func browse(conf config.Settings, cmd string, args ...string) error {
+	if len(args) != 1 {
+		return errors.Errorf("%s: server address required, e.g. %s localhost:8088", cmd, cmd)
+	}
+	remote, err := client.Dial(args[0])
+	if err != nil {
+		return errors.Wrap(err, cmd)
+	}
+	return client.NewBrowser(remote, os.Stdin, os.Stdout).Run(context.Background())
+}
+
+// parseInterspersed parses flags given before or after positional
+// arguments, returning the latter.
+func parseInterspersed(flags *flag.FlagSet, args []string) ([]string, error) {
+	var positional []string
+	for {
+		if err := flags.Parse(args); err != nil {
+			return nil, err
+		}
+		if flags.NArg() == 0 {
+			return positional, nil
+		}
+		positional = append(positional, flags.Arg(0))
+		args = flags.Args()[1:]
+	}
+}
+
+// connect reaches the given server, or the local databases if none is given.
+// The returned function closes the connection.
+func connect(conf *config.Settings, server string) (client.Conn, func() error, error) {
+	if server != "" {
+		remote, err := client.Dial(server)
+		return remote, func() error { return nil }, err
+	}
+	db, err := openBackend(conf)
+	if err != nil {
+		return nil, nil, err
+	}
+	return client.NewLocal(conf, db), db.Close, nil
+}
+
+func writeThread(thr tchan.Thread, asJSON bool) error {
+	if asJSON {
+		return json.NewEncoder(os.Stdout).Encode(thr)
+	}
+	client.WriteThread(os.Stdout, thr)
+	return nil
+}
+
+func readThread(conf config.Settings, cmd string, args ...string) error {
+	flags := flag.NewFlagSet(cmd, flag.ContinueOnError)
+	server := flags.String("server", "", "server to read from, the local databases if empty")
+	asJSON := flags.Bool("json", false, "write the thread as JSON")
+	args, err := parseInterspersed(flags, args)
+	if err != nil {
+		return err
+	}
+	if len(args) != 1 {
+		return errors.Errorf("%s: single post required, e.g. %s /g/42", cmd, cmd)
+	}
+	board, postID, err := parsePostPath(args[0])
+	if err != nil {
+		return errors.Wrap(err, cmd)
+	}
+
+	conn, closeConn, err := connect(&conf, *server)
+	if err != nil {
+		return errors.Wrap(err, cmd)
+	}
+	defer closeConn()
+
+	thr, err := conn.Thread(context.Background(), board, postID)
+	if err != nil {
+		return errors.Wrap(err, cmd)
+	}
+	return writeThread(thr, *asJSON)
+}
+
+func postThread(conf config.Settings, cmd string, args ...string) error {
+	flags := flag.NewFlagSet(cmd, flag.ContinueOnError)
+	server := flags.String("server", "", "server to post to, the local databases if empty")
+	topic := flags.String("topic", "", "topic of the thread")
+	name := flags.String("name", "", "name to post with, anonymous if empty")
+	asJSON := flags.Bool("json", false, "write the thread as JSON")
+	args, err := parseInterspersed(flags, args)
+	if err != nil {
+		return err
+	}
+	board := ""
+	if len(args) == 1 {
+		board = strings.Trim(args[0], "/")
+	}
+	if board == "" || strings.Contains(board, "/") {
+		return errors.Errorf("%s: single board required, e.g. %s /g", cmd, cmd)
+	}
+	content, err := ioutil.ReadAll(os.Stdin)
+	if err != nil {
+		return errors.Wrapf(err, "%s: failed to read content", cmd)
+	}
+
+	conn, closeConn, err := connect(&conf, *server)
+	if err != nil {
+		return errors.Wrap(err, cmd)
+	}
+	defer closeConn()
+
+	thr, err := conn.CreateThread(context.Background(), board, *topic, *name, string(content))
+	if err != nil {
+		return errors.Wrap(err, cmd)
+	}
+	return writeThread(thr, *asJSON)
+}
+
+func postReply(conf config.Settings, cmd string, args ...string) error {
+	flags := flag.NewFlagSet(cmd, flag.ContinueOnError)
+	server := flags.String("server", "", "server to post to, the local databases if empty")
+	name := flags.String("name", "", "name to post with, anonymous if empty")
+	asJSON := flags.Bool("json", false, "write the thread as JSON")
+	args, err := parseInterspersed(flags, args)
+	if err != nil {
+		return err
+	}
+	if len(args) != 1 {
+		return errors.Errorf("%s: single post required, e.g. %s /g/42", cmd, cmd)
+	}
+	board, postID, err := parsePostPath(args[0])
+	if err != nil {
+		return errors.Wrap(err, cmd)
+	}
+	content, err := ioutil.ReadAll(os.Stdin)
+	if err != nil {
+		return errors.Wrapf(err, "%s: failed to read content", cmd)
+	}
+
+	conn, closeConn, err := connect(&conf, *server)
+	if err != nil {
+		return errors.Wrap(err, cmd)
+	}
+	defer closeConn()
+
+	thr, err := conn.Reply(context.Background(), board, postID, *name, string(content))
+	if err != nil {
+		return errors.Wrap(err, cmd)
+	}
+	return writeThread(thr, *asJSON)
 }
 
 func run() error {
@@ -1,6 +1,11 @@
 package backend
 
 import (
+	"context"
+	"time"
+
+	"github.com/pkg/errors"
+
 	"github.com/fgahr/termchan/tchan"
 	"github.com/fgahr/termchan/tchan/config"
 )
@@ -13,23 +18,116 @@ type DB interface {
 	// Close destroys this database's connections.
 	Close() error
 
-	// Refresh renews this database's connections.
+	// Refresh applies a reloaded configuration. Added boards are set up and
+	// removed boards no longer served, keeping their data where persisted.
+	// Other boards are left alone.
 	Refresh() error
 
-	// PopulateBoard fetches a board by name.
-	PopulateBoard(boardName string, b *tchan.BoardOverview, ok *bool) error
+	// PopulateBoard fetches a page of a board's active threads by board name.
+	// Pages are counted from 1.
+	PopulateBoard(ctx context.Context, boardName string, page int, b *tchan.BoardOverview, ok *bool) error
+
+	// PopulateCatalog fetches a listing of all active threads of a board.
+	PopulateCatalog(ctx context.Context, boardName string, c *tchan.Catalog, ok *bool) error
+
+	// PopulateArchive fetches a listing of all archived threads of a board.
+	PopulateArchive(ctx context.Context, boardName string, c *tchan.Catalog, ok *bool) error
+
+	// PopulateThread fetches the thread with the specified post in it. Only
+	// replies within the given range are fetched, the OP is always included.
+	PopulateThread(ctx context.Context, boardName string, postID int64, pr PostRange, thr *tchan.Thread, ok *bool) error
 
-	// PopulateThread fetches the thread with the specified post in it.
-	PopulateThread(boardName string, postID int64, thr *tchan.Thread, ok *bool) error
+	// PopulatePosts fetches all posts on a board which have not been deleted.
+	PopulatePosts(ctx context.Context, boardName string, posts *[]tchan.Post, ok *bool) error
+
+	// Search finds posts on a board matching a full-text query. Fails with
+	// ErrSearchUnavailable if search is not supported.
+	Search(ctx context.Context, boardName string, query string, limit int, results *[]tchan.SearchResult, ok *bool) error
 
 	// CreateThread adds a new thread to a board, setting the OP's post ID.
-	CreateThread(boardName string, topic string, op *tchan.Post) error
+	CreateThread(ctx context.Context, boardName string, topic string, op *tchan.Post) error
 
 	// AddPostToThread adds a reply to a thread, setting the post's ID in the process.
-	AddReply(boardName string, postID int64, post *tchan.Post, ok *bool) error
+	// Fails with ErrThreadArchived or ErrThreadLocked if the thread is
+	// read-only.
+	AddReply(ctx context.Context, boardName string, postID int64, post *tchan.Post, ok *bool) error
+
+	// DeletePost marks a post as deleted. Its data is retained but no longer
+	// served.
+	DeletePost(ctx context.Context, boardName string, postID int64, ok *bool) error
+
+	// SetLocked locks or unlocks the thread with the specified post in it.
+	SetLocked(ctx context.Context, boardName string, postID int64, locked bool, ok *bool) error
+
+	// SetSticky sets or unsets the sticky flag of the thread with the
+	// specified post in it.
+	SetSticky(ctx context.Context, boardName string, postID int64, sticky bool, ok *bool) error
+
+	// PopulateActivity gathers the recent posting activity of a client on a
+	// board, checking for posts with the given content since the given time.
+	PopulateActivity(ctx context.Context, boardName string, ip string, content string, since time.Time, a *Activity) error
+
+	// AddBan persists a ban, setting its ID in the process.
+	AddBan(ctx context.Context, ban *tchan.Ban) error
+
+	// LiftBan ends a ban before its expiry.
+	LiftBan(ctx context.Context, banID int64, ok *bool) error
+
+	// ListBans fetches all bans in effect or, optionally, all bans ever made.
+	ListBans(ctx context.Context, includeExpired bool, bans *[]tchan.Ban) error
+
+	// FindBan fetches a ban in effect for the given address on a board.
+	FindBan(ctx context.Context, boardName string, ip string, ban *tchan.Ban, ok *bool) error
+}
+
+// Activity summarizes the posting activity of a single client on a board.
+type Activity struct {
+	// Time of the latest post, zero if none
+	LastPost time.Time
+	// Time of the latest thread creation, zero if none
+	LastThread time.Time
+	// Whether the content in question was posted recently
+	Duplicate bool
+}
+
+var (
+	// ErrThreadArchived signals an attempt to reply to an archived thread.
+	ErrThreadArchived = errors.New("thread is archived")
+	// ErrThreadLocked signals an attempt to reply to a locked thread.
+	ErrThreadLocked = errors.New("thread is locked")
+	// ErrSearchUnavailable signals that full-text search is not supported.
+	ErrSearchUnavailable = errors.New("search is not available")
+	// ErrUnknownAction signals a moderation action not in ModerationActions.
+	ErrUnknownAction = errors.New("unknown moderation action")
+)
+
+// PostRange restricts the replies fetched for a thread. Its zero value
+// selects all replies.
+type PostRange struct {
+	// After excludes replies with lower or equal post IDs.
+	After int64
+	// Last selects only the latest replies, if positive.
+	Last int
+	// Page selects a page of PageSize replies, counting from 1, if positive.
+	// Must not be combined with Last.
+	Page     int
+	PageSize int
 }
 
-// New creates a new backend which has yet to be initialized.
-func New(opts *config.Settings) DB {
-	return &sqlite{conf: opts}
+// New creates a new backend which has yet to be initialized. The backend is
+// selected by the configured database driver. Ephemeral boards are always
+// kept in memory.
+func New(opts *config.Settings) (DB, error) {
+	var db DB
+	switch opts.Database.Driver {
+	case config.SQLite:
+		db = &sqlite{conf: opts}
+	case config.Postgres:
+		db = &postgres{conf: opts}
+	case config.Memory:
+		return newMemory(opts, false), nil
+	default:
+		return nil, errors.Errorf("unknown database driver: %s", opts.Database.Driver)
+	}
+	return &ephemeral{DB: db, conf: opts, mem: newMemory(opts, true)}, nil
 }
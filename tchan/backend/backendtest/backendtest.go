@@ -0,0 +1,596 @@
+// Package backendtest provides a conformance suite for implementations of
+// backend.DB.
+package backendtest
+
+import (
+	"context"
+	"fmt"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/pkg/errors"
+
+	"github.com/fgahr/termchan/tchan"
+	"github.com/fgahr/termchan/tchan/backend"
+)
+
+// NewDB creates an initialized backend without any data for the given boards,
+// which are sorted by name. It is responsible for cleaning up after the test.
+type NewDB func(t *testing.T, boards ...tchan.Board) backend.DB
+
+// Run runs the conformance suite against a backend implementation.
+func Run(t *testing.T, newDB NewDB) {
+	tests := []struct {
+		name string
+		run  func(*testing.T, NewDB)
+	}{
+		{"UnknownBoard", testUnknownBoard},
+		{"UnknownPost", testUnknownPost},
+		{"IDAssignment", testIDAssignment},
+		{"BoardOrdering", testBoardOrdering},
+		{"ThreadRanges", testThreadRanges},
+		{"ThreadLimits", testThreadLimits},
+		{"Bumping", testBumping},
+		{"Moderation", testModeration},
+		{"Activity", testActivity},
+		{"Bans", testBans},
+		{"Search", testSearch},
+		{"ConcurrentWriters", testConcurrentWriters},
+	}
+	for _, test := range tests {
+		test := test
+		t.Run(test.name, func(t *testing.T) { test.run(t, newDB) })
+	}
+}
+
+func createThread(t *testing.T, db backend.DB, board string, topic string) int64 {
+	ctx := context.Background()
+	t.Helper()
+	op := tchan.Post{Author: "Anonymous", Content: "op of " + topic}
+	if err := db.CreateThread(ctx, board, topic, &op); err != nil {
+		t.Fatalf("failed to create thread on /%s/: %v", board, err)
+	}
+	return op.ID
+}
+
+func reply(t *testing.T, db backend.DB, board string, postID int64, content string) tchan.Post {
+	ctx := context.Background()
+	t.Helper()
+	post := tchan.Post{Author: "Anonymous", Content: content}
+	ok := false
+	if err := db.AddReply(ctx, board, postID, &post, &ok); err != nil || !ok {
+		t.Fatalf("failed to reply to /%s/%d: %v", board, postID, err)
+	}
+	return post
+}
+
+func postIDs(posts []tchan.Post) string {
+	ids := make([]int64, 0, len(posts))
+	for _, p := range posts {
+		ids = append(ids, p.ID)
+	}
+	return fmt.Sprint(ids)
+}
+
+func summaryIDs(threads []tchan.ThreadSummary) string {
+	ids := make([]int64, 0, len(threads))
+	for _, thr := range threads {
+		ids = append(ids, thr.ID())
+	}
+	return fmt.Sprint(ids)
+}
+
+func catalogIDs(c tchan.Catalog) string {
+	ids := make([]int64, 0, len(c.Threads))
+	for _, e := range c.Threads {
+		ids = append(ids, e.ID)
+	}
+	return fmt.Sprint(ids)
+}
+
+func testUnknownBoard(t *testing.T, newDB NewDB) {
+	ctx := context.Background()
+	db := newDB(t, tchan.Board{Name: "b"})
+
+	ok := true
+	if err := db.PopulateBoard(ctx, "x", 1, &tchan.BoardOverview{}, &ok); err != nil || ok {
+		t.Errorf("PopulateBoard: expected unknown board, got ok=%v, err=%v", ok, err)
+	}
+	ok = true
+	if err := db.PopulateCatalog(ctx, "x", &tchan.Catalog{}, &ok); err != nil || ok {
+		t.Errorf("PopulateCatalog: expected unknown board, got ok=%v, err=%v", ok, err)
+	}
+	ok = true
+	if err := db.PopulateArchive(ctx, "x", &tchan.Catalog{}, &ok); err != nil || ok {
+		t.Errorf("PopulateArchive: expected unknown board, got ok=%v, err=%v", ok, err)
+	}
+	ok = true
+	if err := db.PopulateThread(ctx, "x", 1, backend.PostRange{}, &tchan.Thread{}, &ok); err != nil || ok {
+		t.Errorf("PopulateThread: expected unknown board, got ok=%v, err=%v", ok, err)
+	}
+	if err := db.CreateThread(ctx, "x", "topic", &tchan.Post{Content: "content"}); err == nil {
+		t.Error("CreateThread: expected error on unknown board")
+	}
+	if err := db.AddReply(ctx, "x", 1, &tchan.Post{Content: "content"}, &ok); err == nil {
+		t.Error("AddReply: expected error on unknown board")
+	}
+}
+
+func testUnknownPost(t *testing.T, newDB NewDB) {
+	ctx := context.Background()
+	db := newDB(t, tchan.Board{Name: "b"})
+	createThread(t, db, "b", "topic")
+
+	ok := true
+	if err := db.PopulateThread(ctx, "b", 42, backend.PostRange{}, &tchan.Thread{}, &ok); err != nil || ok {
+		t.Errorf("PopulateThread: expected unknown post, got ok=%v, err=%v", ok, err)
+	}
+	ok = true
+	post := tchan.Post{Author: "Anonymous", Content: "content"}
+	if err := db.AddReply(ctx, "b", 42, &post, &ok); err != nil || ok {
+		t.Errorf("AddReply: expected unknown thread, got ok=%v, err=%v", ok, err)
+	}
+	ok = true
+	if err := db.DeletePost(ctx, "b", 42, &ok); err != nil || ok {
+		t.Errorf("DeletePost: expected unknown post, got ok=%v, err=%v", ok, err)
+	}
+	ok = true
+	if err := db.SetLocked(ctx, "b", 42, true, &ok); err != nil || ok {
+		t.Errorf("SetLocked: expected unknown post, got ok=%v, err=%v", ok, err)
+	}
+
+	// The failed reply must not have been stored
+	thr := tchan.Thread{}
+	if err := db.PopulateThread(ctx, "b", 1, backend.PostRange{}, &thr, &ok); err != nil || !ok {
+		t.Fatalf("failed to fetch thread: %v", err)
+	}
+	if len(thr.Posts) != 1 {
+		t.Errorf("expected only the OP, got %s", postIDs(thr.Posts))
+	}
+}
+
+func testIDAssignment(t *testing.T, newDB NewDB) {
+	ctx := context.Background()
+	db := newDB(t, tchan.Board{Name: "b"}, tchan.Board{Name: "g"})
+
+	first := createThread(t, db, "b", "first")
+	r1 := reply(t, db, "b", first, "reply")
+	second := createThread(t, db, "b", "second")
+	// Replying to a reply adds to its thread
+	r2 := reply(t, db, "b", r1.ID, "another reply")
+	other := createThread(t, db, "g", "other")
+
+	if ids := fmt.Sprint([]int64{first, r1.ID, second, r2.ID}); ids != "[1 2 3 4]" {
+		t.Errorf("expected sequential post IDs [1 2 3 4], got %s", ids)
+	}
+	if other != 1 {
+		t.Errorf("expected post IDs to be counted per board, got %d", other)
+	}
+
+	thr := tchan.Thread{}
+	ok := false
+	if err := db.PopulateThread(ctx, "b", r2.ID, backend.PostRange{}, &thr, &ok); err != nil || !ok {
+		t.Fatalf("failed to fetch thread: %v", err)
+	}
+	if ids := postIDs(thr.Posts); ids != "[1 2 4]" || thr.Topic != "first" {
+		t.Errorf("expected thread 'first' with posts [1 2 4], got %q with %s", thr.Topic, ids)
+	}
+	for _, p := range thr.Posts {
+		if p.Timestamp.IsZero() || p.Author != "Anonymous" || p.Content == "" {
+			t.Errorf("expected post data to be set, got %+v", p)
+		}
+	}
+}
+
+func testBoardOrdering(t *testing.T, newDB NewDB) {
+	ctx := context.Background()
+	db := newDB(t, tchan.Board{Name: "b", ThreadsMax: 2})
+	for i := 1; i <= 5; i++ {
+		createThread(t, db, "b", fmt.Sprintf("thread %d", i))
+	}
+	ok := false
+	// Post 2 is the OP of thread 2
+	if err := db.SetSticky(ctx, "b", 2, true, &ok); err != nil || !ok {
+		t.Fatalf("failed to sticky thread: %v", err)
+	}
+
+	// Sticky threads first, then by activity, latest thread first on ties
+	cases := []struct {
+		page int
+		ids  string
+	}{
+		{0, "[2 5]"},
+		{1, "[2 5]"},
+		{2, "[4 3]"},
+		{3, "[1]"},
+		{4, "[]"},
+	}
+	for _, c := range cases {
+		bo := tchan.BoardOverview{}
+		if err := db.PopulateBoard(ctx, "b", c.page, &bo, &ok); err != nil || !ok {
+			t.Fatalf("failed to fetch page %d: %v", c.page, err)
+		}
+		if ids := summaryIDs(bo.Threads); ids != c.ids {
+			t.Errorf("page %d: expected threads %s, got %s", c.page, c.ids, ids)
+		}
+		if bo.NumPages != 3 {
+			t.Errorf("page %d: expected 3 pages, got %d", c.page, bo.NumPages)
+		}
+		for _, thr := range bo.Threads {
+			if thr.OP.QuotedBy == nil || thr.Active.IsZero() || thr.Sticky != (thr.ID() == 2) {
+				t.Errorf("page %d: unexpected summary %+v", c.page, thr)
+			}
+		}
+	}
+
+	c := tchan.Catalog{}
+	if err := db.PopulateCatalog(ctx, "b", &c, &ok); err != nil || !ok {
+		t.Fatalf("failed to fetch catalog: %v", err)
+	}
+	if len(c.Threads) != 5 || c.Threads[0].ID != 2 {
+		t.Errorf("expected all 5 threads with the sticky one first, got %s", catalogIDs(c))
+	}
+}
+
+func testThreadRanges(t *testing.T, newDB NewDB) {
+	ctx := context.Background()
+	db := newDB(t, tchan.Board{Name: "b"})
+	// OP is post 1, replies are posts 2 to 11
+	opID := createThread(t, db, "b", "topic")
+	for i := 1; i <= 10; i++ {
+		reply(t, db, "b", opID, fmt.Sprintf("reply %d", i))
+	}
+
+	cases := []struct {
+		pr            backend.PostRange
+		ids           string
+		before, after int
+	}{
+		{backend.PostRange{}, "[1 2 3 4 5 6 7 8 9 10 11]", 0, 0},
+		{backend.PostRange{Last: 3}, "[1 9 10 11]", 7, 0},
+		{backend.PostRange{After: 5}, "[1 6 7 8 9 10 11]", 4, 0},
+		{backend.PostRange{After: 5, Last: 2}, "[1 10 11]", 8, 0},
+		{backend.PostRange{Page: 2, PageSize: 3}, "[1 5 6 7]", 3, 4},
+		{backend.PostRange{Page: 5, PageSize: 3}, "[1]", 10, 0},
+		{backend.PostRange{After: 11}, "[1]", 10, 0},
+	}
+	for _, c := range cases {
+		thr := tchan.Thread{}
+		ok := false
+		if err := db.PopulateThread(ctx, "b", opID, c.pr, &thr, &ok); err != nil || !ok {
+			t.Fatalf("%+v: failed to fetch thread: %v", c.pr, err)
+		}
+		if ids := postIDs(thr.Posts); ids != c.ids {
+			t.Errorf("%+v: expected posts %s, got %s", c.pr, c.ids, ids)
+		}
+		if thr.OmittedBefore != c.before || thr.OmittedAfter != c.after {
+			t.Errorf("%+v: expected %d/%d omitted, got %d/%d",
+				c.pr, c.before, c.after, thr.OmittedBefore, thr.OmittedAfter)
+		}
+	}
+}
+
+func testThreadLimits(t *testing.T, newDB NewDB) {
+	ctx := context.Background()
+	db := newDB(t, tchan.Board{Name: "b", ThreadsMax: 1, PagesMax: 2, ThreadLengthMax: 2})
+
+	long := createThread(t, db, "b", "long")
+	reply(t, db, "b", long, "one")
+	reply(t, db, "b", long, "two")
+	// Exceeding the thread length archives the thread
+	reply(t, db, "b", long, "three")
+	post := tchan.Post{Author: "Anonymous", Content: "four"}
+	ok := false
+	if err := db.AddReply(ctx, "b", long, &post, &ok); errors.Cause(err) != backend.ErrThreadArchived {
+		t.Errorf("expected reply to long thread to fail with %v, got %v", backend.ErrThreadArchived, err)
+	}
+
+	// Only two threads fit on the board
+	first := createThread(t, db, "b", "first")
+	second := createThread(t, db, "b", "second")
+	third := createThread(t, db, "b", "third")
+
+	c := tchan.Catalog{}
+	if err := db.PopulateCatalog(ctx, "b", &c, &ok); err != nil || !ok {
+		t.Fatalf("failed to fetch catalog: %v", err)
+	}
+	if ids, expected := catalogIDs(c), fmt.Sprint([]int64{third, second}); ids != expected {
+		t.Errorf("expected active threads %s, got %s", expected, ids)
+	}
+
+	a := tchan.Catalog{}
+	if err := db.PopulateArchive(ctx, "b", &a, &ok); err != nil || !ok {
+		t.Fatalf("failed to fetch archive: %v", err)
+	}
+	archived := make(map[int64]bool)
+	for _, e := range a.Threads {
+		archived[e.ID] = true
+	}
+	if !a.Archived || len(a.Threads) != 2 || !archived[long] || !archived[first] {
+		t.Errorf("expected threads %d and %d to be archived, got %s", long, first, catalogIDs(a))
+	}
+
+	thr := tchan.Thread{}
+	if err := db.PopulateThread(ctx, "b", first, backend.PostRange{}, &thr, &ok); err != nil || !ok || !thr.Archived {
+		t.Errorf("expected archived thread to remain viewable, got %+v (%v)", thr, err)
+	}
+}
+
+func testBumping(t *testing.T, newDB NewDB) {
+	ctx := context.Background()
+	db := newDB(t, tchan.Board{Name: "b"})
+	first := createThread(t, db, "b", "first")
+	second := createThread(t, db, "b", "second")
+
+	// Timestamps may only be stored with a precision of seconds
+	time.Sleep(time.Until(time.Now().Truncate(time.Second).Add(time.Second)))
+	r := reply(t, db, "b", first, "bump")
+
+	bo := tchan.BoardOverview{}
+	ok := false
+	if err := db.PopulateBoard(ctx, "b", 1, &bo, &ok); err != nil || !ok {
+		t.Fatalf("failed to fetch board: %v", err)
+	}
+	if ids, expected := summaryIDs(bo.Threads), fmt.Sprint([]int64{first, second}); ids != expected {
+		t.Fatalf("expected bumped thread first (%s), got %s", expected, ids)
+	}
+	thr := tchan.Thread{}
+	if err := db.PopulateThread(ctx, "b", r.ID, backend.PostRange{}, &thr, &ok); err != nil || !ok {
+		t.Fatalf("failed to fetch thread: %v", err)
+	}
+	if !bo.Threads[0].Active.Equal(thr.Posts[1].Timestamp) {
+		t.Errorf("expected thread activity %v to match the reply %v", bo.Threads[0].Active, thr.Posts[1].Timestamp)
+	}
+	if !bo.Threads[1].Active.Equal(bo.Threads[1].OP.Timestamp) {
+		t.Errorf("expected thread activity %v to match the OP %v", bo.Threads[1].Active, bo.Threads[1].OP.Timestamp)
+	}
+	if !bo.Threads[0].OP.Timestamp.Before(bo.Threads[0].Active) {
+		t.Errorf("expected thread activity %v to be after its creation %v", bo.Threads[0].Active, bo.Threads[0].OP.Timestamp)
+	}
+}
+
+func testModeration(t *testing.T, newDB NewDB) {
+	ctx := context.Background()
+	db := newDB(t, tchan.Board{Name: "b"})
+	opID := createThread(t, db, "b", "topic")
+	r := reply(t, db, "b", opID, "regrettable")
+
+	ok := false
+	if err := db.DeletePost(ctx, "b", r.ID, &ok); err != nil || !ok {
+		t.Fatalf("failed to delete post: %v", err)
+	}
+	// Deleting twice is not an error
+	if err := db.DeletePost(ctx, "b", r.ID, &ok); err != nil || !ok {
+		t.Errorf("expected repeated deletion to succeed, got ok=%v, err=%v", ok, err)
+	}
+	thr := tchan.Thread{}
+	if err := db.PopulateThread(ctx, "b", opID, backend.PostRange{}, &thr, &ok); err != nil || !ok {
+		t.Fatalf("failed to fetch thread: %v", err)
+	}
+	if p := thr.Posts[1]; !p.Deleted || p.Content != "" || p.Author != "" {
+		t.Errorf("expected deleted post to be tombstoned, got %+v", p)
+	}
+	var posts []tchan.Post
+	if err := db.PopulatePosts(ctx, "b", &posts, &ok); err != nil || !ok || postIDs(posts) != "[1]" {
+		t.Errorf("expected only the OP to remain, got %s (%v)", postIDs(posts), err)
+	}
+
+	if err := db.SetLocked(ctx, "b", r.ID, true, &ok); err != nil || !ok {
+		t.Fatalf("failed to lock thread: %v", err)
+	}
+	post := tchan.Post{Author: "Anonymous", Content: "too late"}
+	if err := db.AddReply(ctx, "b", opID, &post, &ok); errors.Cause(err) != backend.ErrThreadLocked {
+		t.Errorf("expected reply to locked thread to fail with %v, got %v", backend.ErrThreadLocked, err)
+	}
+	if err := db.SetLocked(ctx, "b", opID, false, &ok); err != nil || !ok {
+		t.Fatalf("failed to unlock thread: %v", err)
+	}
+	reply(t, db, "b", opID, "in time")
+}
+
+func testActivity(t *testing.T, newDB NewDB) {
+	ctx := context.Background()
+	db := newDB(t, tchan.Board{Name: "b"})
+	ip := "192.0.2.7"
+	start := time.Now().Add(-time.Second)
+
+	a := backend.Activity{}
+	if err := db.PopulateActivity(ctx, "b", ip, "hello", start, &a); err != nil {
+		t.Fatal(err)
+	}
+	if !a.LastPost.IsZero() || !a.LastThread.IsZero() || a.Duplicate {
+		t.Errorf("expected no activity, got %+v", a)
+	}
+
+	op := tchan.Post{Author: "Anonymous", Content: "first", AuthorIP: "198.51.100.1"}
+	if err := db.CreateThread(ctx, "b", "topic", &op); err != nil {
+		t.Fatal(err)
+	}
+	post := tchan.Post{Author: "Anonymous", Content: "hello", AuthorIP: ip}
+	ok := false
+	if err := db.AddReply(ctx, "b", op.ID, &post, &ok); err != nil || !ok {
+		t.Fatal(err)
+	}
+
+	if err := db.PopulateActivity(ctx, "b", ip, "hello", start, &a); err != nil {
+		t.Fatal(err)
+	}
+	if a.LastPost.IsZero() || !a.LastThread.IsZero() || !a.Duplicate {
+		t.Errorf("expected a recent duplicate reply, got %+v", a)
+	}
+	if err := db.PopulateActivity(ctx, "b", ip, "other", start, &a); err != nil || a.Duplicate {
+		t.Errorf("expected other content not to be a duplicate, got %+v (%v)", a, err)
+	}
+	if err := db.PopulateActivity(ctx, "b", "198.51.100.1", "x", start, &a); err != nil || a.LastThread.IsZero() {
+		t.Errorf("expected a recent thread, got %+v (%v)", a, err)
+	}
+}
+
+func testBans(t *testing.T, newDB NewDB) {
+	ctx := context.Background()
+	db := newDB(t, tchan.Board{Name: "b"}, tchan.Board{Name: "g"})
+	past := time.Now().Add(-time.Hour)
+	future := time.Now().Add(time.Hour)
+	bans := []tchan.Ban{
+		{Target: "203.0.113.0/24", Board: "b", Reason: "range on /b/"},
+		{Target: "198.51.100.1", Reason: "global", Expires: &future},
+		{Target: "198.51.100.1", Reason: "permanent"},
+		{Target: "192.0.2.1", Reason: "expired", Expires: &past},
+		{Target: "192.0.2.2", Reason: "lifted"},
+	}
+	for i := range bans {
+		if err := db.AddBan(ctx, &bans[i]); err != nil {
+			t.Fatal(err)
+		}
+		if bans[i].ID == 0 {
+			t.Errorf("expected ban ID to be set for %s", bans[i].Target)
+		}
+	}
+	ok := false
+	if err := db.LiftBan(ctx, bans[4].ID, &ok); err != nil || !ok {
+		t.Fatalf("failed to lift ban: %v", err)
+	}
+	if err := db.LiftBan(ctx, bans[4].ID, &ok); err != nil || ok {
+		t.Errorf("expected lifting twice to fail, got ok=%v, err=%v", ok, err)
+	}
+
+	cases := []struct {
+		board, ip string
+		reason    string
+	}{
+		{"b", "203.0.113.5", "range on /b/"},
+		{"g", "203.0.113.5", ""},
+		// The longest lasting ban takes precedence
+		{"g", "198.51.100.1", "permanent"},
+		{"b", "192.0.2.1", ""},
+		{"b", "192.0.2.2", ""},
+	}
+	for _, c := range cases {
+		ban := tchan.Ban{}
+		if err := db.FindBan(ctx, c.board, c.ip, &ban, &ok); err != nil {
+			t.Fatal(err)
+		}
+		if ok != (c.reason != "") || ban.Reason != c.reason {
+			t.Errorf("%s on /%s/: expected ban %q, got %q", c.ip, c.board, c.reason, ban.Reason)
+		}
+	}
+
+	var active, all []tchan.Ban
+	if err := db.ListBans(ctx, false, &active); err != nil || len(active) != 3 {
+		t.Errorf("expected 3 bans in effect, got %d (%v)", len(active), err)
+	}
+	if err := db.ListBans(ctx, true, &all); err != nil || len(all) != 5 {
+		t.Errorf("expected 5 bans in total, got %d (%v)", len(all), err)
+	}
+}
+
+func testSearch(t *testing.T, newDB NewDB) {
+	ctx := context.Background()
+	db := newDB(t, tchan.Board{Name: "b"})
+	opID := createThread(t, db, "b", "gardening")
+	r := reply(t, db, "b", opID, "tomatoes need sun")
+	reply(t, db, "b", opID, "so do cucumbers")
+
+	search := func(query string) string {
+		var results []tchan.SearchResult
+		ok := false
+		err := db.Search(ctx, "b", query, 10, &results, &ok)
+		if errors.Cause(err) == backend.ErrSearchUnavailable {
+			t.Skip("search not supported")
+		}
+		if err != nil || !ok {
+			t.Fatalf("failed to search for %q: %v", query, err)
+		}
+		ids := make([]int64, 0, len(results))
+		for _, res := range results {
+			ids = append(ids, res.PostID)
+			if res.ThreadID != opID || res.Topic != "gardening" {
+				t.Errorf("unexpected result %+v", res)
+			}
+		}
+		return fmt.Sprint(ids)
+	}
+
+	cases := []struct{ query, ids string }{
+		{"tomatoes", "[2]"},
+		{"TOMATOES sun", "[2]"},
+		{"gardening", "[1]"},
+		{"tomatoes cucumbers", "[]"},
+		{`"unbalanced`, "[]"},
+	}
+	for _, c := range cases {
+		if ids := search(c.query); ids != c.ids {
+			t.Errorf("searching %q: expected posts %s, got %s", c.query, c.ids, ids)
+		}
+	}
+
+	ok := false
+	if err := db.DeletePost(ctx, "b", r.ID, &ok); err != nil || !ok {
+		t.Fatal(err)
+	}
+	if ids := search("tomatoes"); ids != "[]" {
+		t.Errorf("expected deleted post not to be found, got %s", ids)
+	}
+}
+
+func testConcurrentWriters(t *testing.T, newDB NewDB) {
+	ctx := context.Background()
+	db := newDB(t, tchan.Board{Name: "b"}, tchan.Board{Name: "g"})
+	opID := createThread(t, db, "b", "busy")
+
+	const writers = 8
+	const repliesEach = 10
+	ids := make(chan int64, writers*repliesEach+writers)
+	errs := make(chan error, writers*2)
+	wg := sync.WaitGroup{}
+	for w := 0; w < writers; w++ {
+		wg.Add(2)
+		go func(w int) {
+			defer wg.Done()
+			for i := 0; i < repliesEach; i++ {
+				post := tchan.Post{Author: "Anonymous", Content: fmt.Sprintf("writer %d, reply %d", w, i)}
+				ok := false
+				if err := db.AddReply(ctx, "b", opID, &post, &ok); err != nil || !ok {
+					errs <- errors.Errorf("reply failed: ok=%v, err=%v", ok, err)
+					return
+				}
+				ids <- post.ID
+			}
+		}(w)
+		go func(w int) {
+			defer wg.Done()
+			op := tchan.Post{Author: "Anonymous", Content: fmt.Sprintf("writer %d", w)}
+			if err := db.CreateThread(ctx, "g", "thread", &op); err != nil {
+				errs <- err
+			}
+		}(w)
+	}
+	wg.Wait()
+	close(ids)
+	close(errs)
+
+	for err := range errs {
+		t.Error(err)
+	}
+	seen := map[int64]bool{opID: true}
+	for id := range ids {
+		if seen[id] {
+			t.Errorf("post ID %d assigned twice", id)
+		}
+		seen[id] = true
+	}
+
+	thr := tchan.Thread{}
+	ok := false
+	if err := db.PopulateThread(ctx, "b", opID, backend.PostRange{}, &thr, &ok); err != nil || !ok {
+		t.Fatalf("failed to fetch thread: %v", err)
+	}
+	if n := thr.NumReplies(); n != writers*repliesEach || len(thr.Posts) != len(seen) {
+		t.Errorf("expected %d replies, got %d in %d posts", writers*repliesEach, n, len(thr.Posts))
+	}
+	c := tchan.Catalog{}
+	if err := db.PopulateCatalog(ctx, "g", &c, &ok); err != nil || len(c.Threads) != writers {
+		t.Errorf("expected %d threads, got %s (%v)", writers, catalogIDs(c), err)
+	}
+}
@@ -0,0 +1,119 @@
+package backend
+
+import (
+	"context"
+	"database/sql"
+	"time"
+
+	"github.com/pkg/errors"
+
+	"github.com/fgahr/termchan/tchan"
+)
+
+func (s *sqlite) AddBan(ctx context.Context, ban *tchan.Ban) error {
+	var expires interface{}
+	if ban.Expires != nil {
+		expires = ban.Expires.UTC().Format(time.RFC3339)
+	}
+	var board interface{}
+	if ban.Board != "" {
+		board = ban.Board
+	}
+
+	result, err := s.serverDB.ExecContext(ctx, `
+INSERT INTO ban (target, board, reason, expires_at) VALUES (?, ?, ?, ?);
+`, ban.Target, board, ban.Reason, expires)
+	if err != nil {
+		return errors.Wrap(err, "failed to persist ban")
+	}
+
+	ban.ID, err = result.LastInsertId()
+	return err
+}
+
+func (s *sqlite) LiftBan(ctx context.Context, banID int64, ok *bool) error {
+	result, err := s.serverDB.ExecContext(ctx, `
+UPDATE ban SET lifted_at = strftime('%Y-%m-%dT%H:%M:%SZ', 'now')
+WHERE id = ? AND lifted_at IS NULL;
+`, banID)
+	if err != nil {
+		return errors.Wrap(err, "failed to lift ban")
+	}
+
+	n, err := result.RowsAffected()
+	*ok = n > 0
+	return err
+}
+
+func (s *sqlite) ListBans(ctx context.Context, includeExpired bool, bans *[]tchan.Ban) error {
+	rows, err := s.serverDB.QueryContext(ctx, `
+SELECT id, target, coalesce(board, ''), coalesce(reason, ''), created_at, expires_at
+FROM ban
+WHERE ? OR (lifted_at IS NULL AND (expires_at IS NULL OR expires_at > strftime('%Y-%m-%dT%H:%M:%SZ', 'now')))
+ORDER BY id ASC;
+`, includeExpired)
+	if err != nil {
+		return errors.Wrap(err, "failed to gather bans")
+	}
+	defer rows.Close()
+
+	*bans, err = scanBans(rows)
+	return err
+}
+
+func (s *sqlite) FindBan(ctx context.Context, boardName string, ip string, ban *tchan.Ban, ok *bool) error {
+	*ok = false
+	rows, err := s.serverDB.QueryContext(ctx, `
+SELECT id, target, coalesce(board, ''), coalesce(reason, ''), created_at, expires_at
+FROM ban
+WHERE lifted_at IS NULL AND (board IS NULL OR board = ?)
+AND (expires_at IS NULL OR expires_at > strftime('%Y-%m-%dT%H:%M:%SZ', 'now'))
+ORDER BY expires_at IS NULL DESC, expires_at DESC;
+`, boardName)
+	if err != nil {
+		return errors.Wrap(err, "failed to gather bans")
+	}
+	defer rows.Close()
+
+	bans, err := scanBans(rows)
+	if err != nil {
+		return err
+	}
+
+	// Address ranges cannot be matched in SQL. Ordered such that the longest
+	// lasting ban is found first.
+	for _, b := range bans {
+		if b.Matches(ip) {
+			*ban = b
+			*ok = true
+			return nil
+		}
+	}
+	return nil
+}
+
+func scanBans(rows *sql.Rows) ([]tchan.Ban, error) {
+	bans := make([]tchan.Ban, 0)
+	for rows.Next() {
+		b := tchan.Ban{}
+		var createdTS string
+		var expiresTS sql.NullString
+		if err := rows.Scan(&b.ID, &b.Target, &b.Board, &b.Reason, &createdTS, &expiresTS); err != nil {
+			return bans, errors.Wrap(err, "failed to extract ban")
+		}
+
+		var err error
+		if b.Created, err = time.Parse(time.RFC3339, createdTS); err != nil {
+			return bans, errors.Wrap(err, "malformed date string in ban table (created_at)")
+		}
+		if expiresTS.Valid {
+			expires, err := time.Parse(time.RFC3339, expiresTS.String)
+			if err != nil {
+				return bans, errors.Wrap(err, "malformed date string in ban table (expires_at)")
+			}
+			b.Expires = &expires
+		}
+		bans = append(bans, b)
+	}
+	return bans, rows.Err()
+}
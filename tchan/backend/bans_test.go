@@ -0,0 +1,62 @@
+package backend
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/fgahr/termchan/tchan"
+)
+
+func TestFindBan(t *testing.T) {
+	ctx := context.Background()
+	db := newTestBackend(t, tchan.Board{Name: "b"}, tchan.Board{Name: "g"})
+	past := time.Now().Add(-time.Hour)
+	future := time.Now().Add(time.Hour)
+	bans := []tchan.Ban{
+		{Target: "203.0.113.0/24", Board: "b", Reason: "range on /b/"},
+		{Target: "198.51.100.1", Reason: "global", Expires: &future},
+		{Target: "192.0.2.1", Reason: "expired", Expires: &past},
+		{Target: "192.0.2.2", Reason: "lifted"},
+	}
+	for i := range bans {
+		if err := db.AddBan(ctx, &bans[i]); err != nil {
+			t.Fatal(err)
+		}
+	}
+	ok := false
+	if err := db.LiftBan(ctx, bans[3].ID, &ok); err != nil || !ok {
+		t.Fatalf("failed to lift ban: %v", err)
+	}
+
+	cases := []struct {
+		board, ip string
+		reason    string
+	}{
+		{"b", "203.0.113.5", "range on /b/"},
+		{"g", "203.0.113.5", ""},
+		{"b", "198.51.100.1", "global"},
+		{"g", "198.51.100.1", "global"},
+		{"b", "192.0.2.1", ""},
+		{"b", "192.0.2.2", ""},
+	}
+	for _, c := range cases {
+		ban := tchan.Ban{}
+		ok := false
+		if err := db.FindBan(ctx, c.board, c.ip, &ban, &ok); err != nil {
+			t.Fatal(err)
+		}
+		if ok != (c.reason != "") || ban.Reason != c.reason {
+			t.Errorf("%s on /%s/: expected ban %q, got %q (found: %v)", c.ip, c.board, c.reason, ban.Reason, ok)
+		}
+	}
+
+	var active []tchan.Ban
+	if err := db.ListBans(ctx, false, &active); err != nil || len(active) != 2 {
+		t.Errorf("expected 2 bans in effect, got %d (%v)", len(active), err)
+	}
+	var all []tchan.Ban
+	if err := db.ListBans(ctx, true, &all); err != nil || len(all) != 4 {
+		t.Errorf("expected 4 bans in total, got %d (%v)", len(all), err)
+	}
+}
@@ -0,0 +1,100 @@
+package backend
+
+import (
+	"context"
+	"io"
+	"log"
+	"os"
+	"testing"
+
+	"github.com/fgahr/termchan/tchan"
+)
+
+// The benchmarks compare cached to uncached statements with concurrent
+// clients, e.g.
+//
+//	go test -run '^$' -bench . -cpu 1,4 ./tchan/backend/
+func benchmarkCaching(b *testing.B, f func(b *testing.B)) {
+	// Keeps the results readable
+	log.SetOutput(io.Discard)
+	defer log.SetOutput(os.Stderr)
+	for _, cached := range []bool{false, true} {
+		name := "uncached"
+		if cached {
+			name = "cached"
+		}
+		b.Run(name, func(b *testing.B) {
+			cacheStatements = cached
+			defer func() { cacheStatements = true }()
+			f(b)
+		})
+	}
+}
+
+func BenchmarkViewBoard(b *testing.B) {
+	benchmarkCaching(b, func(b *testing.B) {
+		db := newTestBackend(b, tchan.Board{Name: "b"})
+		for i := 0; i < 50; i++ {
+			createThread(b, db, "b", 5)
+		}
+
+		b.SetParallelism(4)
+		b.ResetTimer()
+		b.RunParallel(func(pb *testing.PB) {
+			ctx := context.Background()
+			for pb.Next() {
+				bo := tchan.BoardOverview{}
+				ok := false
+				if err := db.PopulateBoard(ctx, "b", 1, &bo, &ok); err != nil || !ok {
+					b.Errorf("failed to fetch board: %v", err)
+					return
+				}
+			}
+		})
+	})
+}
+
+func BenchmarkViewThread(b *testing.B) {
+	benchmarkCaching(b, func(b *testing.B) {
+		db := newTestBackend(b, tchan.Board{Name: "b"})
+		opID := createThread(b, db, "b", 99)
+
+		b.SetParallelism(4)
+		b.ResetTimer()
+		b.RunParallel(func(pb *testing.PB) {
+			ctx := context.Background()
+			for pb.Next() {
+				thr := tchan.Thread{}
+				ok := false
+				if err := db.PopulateThread(ctx, "b", opID, PostRange{Last: 50}, &thr, &ok); err != nil || !ok {
+					b.Errorf("failed to fetch thread: %v", err)
+					return
+				}
+			}
+		})
+	})
+}
+
+func BenchmarkReply(b *testing.B) {
+	benchmarkCaching(b, func(b *testing.B) {
+		db := newTestBackend(b, tchan.Board{Name: "b", ThreadLengthMax: 1 << 30})
+		var ops []int64
+		for i := 0; i < 10; i++ {
+			ops = append(ops, createThread(b, db, "b", 0))
+		}
+
+		b.SetParallelism(4)
+		b.ResetTimer()
+		b.RunParallel(func(pb *testing.PB) {
+			ctx := context.Background()
+			for i := 0; pb.Next(); i++ {
+				post := tchan.Post{Author: "Anonymous", Content: "benchmark"}
+				ok := false
+				if err := db.AddReply(ctx, "b", ops[i%len(ops)], &post, &ok); err != nil || !ok {
+					b.Errorf("failed to reply: %v", err)
+					return
+				}
+			}
+		})
+	})
+}
@@ -0,0 +1,73 @@
+package backend_test
+
+import (
+	"testing"
+
+	"github.com/fgahr/termchan/tchan"
+	"github.com/fgahr/termchan/tchan/backend"
+	"github.com/fgahr/termchan/tchan/backend/backendtest"
+	"github.com/fgahr/termchan/tchan/config"
+)
+
+func newBackend(t *testing.T, conf config.Settings, boards []tchan.Board) backend.DB {
+	conf.Boards = boards
+	db, err := backend.New(&conf)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := db.Init(); err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { db.Close() })
+	return db
+}
+
+func TestSQLiteConformance(t *testing.T) {
+	backendtest.Run(t, func(t *testing.T, boards ...tchan.Board) backend.DB {
+		conf := config.Defaults()
+		if err := conf.SetWorkingDirectory(t.TempDir()); err != nil {
+			t.Fatal(err)
+		}
+		return newBackend(t, conf, boards)
+	})
+}
+
+func TestSQLiteSingleConformance(t *testing.T) {
+	backendtest.Run(t, func(t *testing.T, boards ...tchan.Board) backend.DB {
+		conf := config.Defaults()
+		if err := conf.SetWorkingDirectory(t.TempDir()); err != nil {
+			t.Fatal(err)
+		}
+		conf.Database.Layout = config.SingleLayout
+		return newBackend(t, conf, boards)
+	})
+}
+
+func TestMemoryConformance(t *testing.T) {
+	backendtest.Run(t, func(t *testing.T, boards ...tchan.Board) backend.DB {
+		conf := config.Defaults()
+		conf.Database.Driver = config.Memory
+		return newBackend(t, conf, boards)
+	})
+}
+
+func TestEphemeralConformance(t *testing.T) {
+	backendtest.Run(t, func(t *testing.T, boards ...tchan.Board) backend.DB {
+		conf := config.Defaults()
+		if err := conf.SetWorkingDirectory(t.TempDir()); err != nil {
+			t.Fatal(err)
+		}
+		for i := range boards {
+			boards[i].Ephemeral = true
+		}
+		return newBackend(t, conf, boards)
+	})
+}
+
+func TestPostgresConformance(t *testing.T) {
+	backendtest.Run(t, func(t *testing.T, boards ...tchan.Board) backend.DB {
+		conf := config.Defaults()
+		conf.Database = config.Database{Driver: config.Postgres, DSN: backend.ResetTestPostgres(t)}
+		return newBackend(t, conf, boards)
+	})
+}
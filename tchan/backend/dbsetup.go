@@ -5,76 +5,182 @@ import (
 	"path/filepath"
 
 	"github.com/pkg/errors"
+
+	"github.com/fgahr/termchan/tchan/config"
 )
 
+// sqliteOptions are appended to the paths of writable databases. In WAL mode,
+// readers do not block writers and vice versa. Writing transactions wait for
+// each other instead of failing to upgrade their lock.
+const sqliteOptions = "?_journal_mode=WAL&_busy_timeout=5000&_txlock=immediate"
+
+// setPoolLimits applies the configured connection pool sizes to a database.
+func setPoolLimits(db *sql.DB, conf config.Database) {
+	if conf.MaxOpenConns > 0 {
+		db.SetMaxOpenConns(conf.MaxOpenConns)
+	}
+	if conf.MaxIdleConns > 0 {
+		db.SetMaxIdleConns(conf.MaxIdleConns)
+	}
+}
+
 func (s *sqlite) initBoardDB(boardName string) (*sql.DB, error) {
 	path := filepath.Join(s.boardsDirectory, boardName+".db")
+	return openBoardDB(path, boardMigrations(boardName), s.searchable)
+}
+
+// initSingleDB sets up the database holding all boards in the single layout.
+func (s *sqlite) initSingleDB() (*sql.DB, error) {
+	return openBoardDB(s.conf.SingleDatabase(), singleMigrations, s.searchable)
+}
+
+// openBoardDB opens a database holding one or more boards, migrating it and
+// maintaining the search index if supported.
+func openBoardDB(path string, migrations []migration, searchable bool) (*sql.DB, error) {
 	var boardDB *sql.DB
 	var err error
 
-	if boardDB, err = sql.Open("sqlite3", path); err != nil {
+	if boardDB, err = sql.Open("sqlite3", path+sqliteOptions); err != nil {
 		return boardDB, errors.Wrapf(err, "failed to connect to file %s", path)
 	}
 
+	if err = migrate(boardDB, migrations); err != nil {
+		return boardDB, errors.Wrapf(err, "failed to migrate %s", path)
+	}
+
+	// Depends on the build, hence not part of the migrations
+	if searchable {
+		err = initSearchIndex(boardDB)
+	} else {
+		err = dropSearchTriggers(boardDB)
+	}
+	if err != nil {
+		return boardDB, errors.Wrap(err, "failed to set up search index")
+	}
+
+	return boardDB, nil
+}
+
+// hasFTS5 tells whether SQLite was compiled with full-text search, requiring
+// the sqlite_fts5 build tag.
+func hasFTS5(db *sql.DB) (bool, error) {
+	var enabled bool
+	err := db.QueryRow(`SELECT sqlite_compileoption_used('ENABLE_FTS5');`).Scan(&enabled)
+	return enabled, err
+}
+
+var searchTriggers = []string{
+	"index_new_post", "unindex_deleted_post", "unindex_removed_post", "reindex_topic",
+}
+
+// dropSearchTriggers removes the triggers maintaining the search index, which
+// would otherwise prevent posting without full-text search support. The index
+// is rebuilt once support is available again.
+func dropSearchTriggers(boardDB *sql.DB) error {
+	for _, t := range searchTriggers {
+		if _, err := boardDB.Exec("DROP TRIGGER IF EXISTS " + t + ";"); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func initSearchIndex(boardDB *sql.DB) error {
+	// Without triggers, the index is either missing or out of date
+	var current int
+	err := boardDB.QueryRow(`
+SELECT count(*) FROM sqlite_master WHERE type = 'trigger' AND name = 'index_new_post';
+`).Scan(&current)
+	if err != nil {
+		return err
+	}
+
+	// One row per post, with the topic only set for OPs. Rows are matched by
+	// rowid, which equals the post ID unless all boards share the database.
 	_, err = boardDB.Exec(`
-CREATE TABLE IF NOT EXISTS thread (
-    id INTEGER PRIMARY KEY,
-    op_id INTEGER,
-    num_replies INTEGER DEFAULT -1,
-    topic TEXT,
-    created_at TEXT,
-    active_at TEXT
-);
+CREATE VIRTUAL TABLE IF NOT EXISTS post_search USING fts5(topic, content);
 `)
 	if err != nil {
-		return boardDB, errors.Wrap(err, "failed to create thread table")
+		return err
+	}
+
+	if current == 0 {
+		_, err = boardDB.Exec(`
+DELETE FROM post_search;
+INSERT INTO post_search (rowid, topic, content)
+SELECT p.rowid, CASE WHEN t.op_id = p.id THEN t.topic END, p.content
+FROM post p INNER JOIN thread t ON p.board = t.board AND p.thread_id = t.id
+WHERE p.deleted_at IS NULL;
+`)
+		if err != nil {
+			return err
+		}
 	}
 
 	_, err = boardDB.Exec(`
-CREATE TABLE IF NOT EXISTS post (
-    id INTEGER PRIMARY KEY,
-    thread_id INTEGER NOT NULL,
-    author TEXT,
-    author_ip TEXT,
-    content TEXT NOT NULL,
-    created_at TEXT,
-    FOREIGN KEY(thread_id) REFERENCES thread(id) NOT DEFERRABLE
-);
+CREATE TRIGGER IF NOT EXISTS index_new_post
+AFTER INSERT ON post
+FOR EACH ROW
+BEGIN
+INSERT INTO post_search (rowid, topic, content)
+VALUES (NEW.rowid,
+        -- op_id may not have been set yet for a new thread
+        (SELECT topic FROM thread
+         WHERE board = NEW.board AND id = NEW.thread_id AND coalesce(op_id, NEW.id) = NEW.id),
+        NEW.content);
+END;
 `)
 	if err != nil {
-		return boardDB, errors.Wrap(err, "failed to create post table")
+		return err
 	}
 
 	_, err = boardDB.Exec(`
-CREATE INDEX IF NOT EXISTS post_by_thread_id ON post(thread_id);
+CREATE TRIGGER IF NOT EXISTS unindex_deleted_post
+AFTER UPDATE OF deleted_at ON post
+FOR EACH ROW WHEN NEW.deleted_at IS NOT NULL
+BEGIN
+DELETE FROM post_search WHERE rowid = NEW.rowid;
+END;
 `)
 	if err != nil {
-		return boardDB, errors.Wrap(err, "failed to create index post(thread_id)")
+		return err
 	}
 
 	_, err = boardDB.Exec(`
-CREATE TRIGGER IF NOT EXISTS update_thread_timestamp
-AFTER INSERT ON post
+CREATE TRIGGER IF NOT EXISTS unindex_removed_post
+AFTER DELETE ON post
 FOR EACH ROW
 BEGIN
-UPDATE post SET
--- SQLite3 uses UTC internally so we can add the static 'Z' time zone suffix
-created_at = strftime('%Y-%m-%dT%H:%M:%SZ', 'now')
-    WHERE post.id = NEW.id;
-
-UPDATE thread
-SET num_replies = num_replies + 1,
-    op_id = coalesce(op_id, NEW.id),
-    created_at = coalesce(created_at, (SELECT created_at FROM post WHERE id = NEW.id)),
-    -- max() doesn't work with NULL so we make sure to indeed have a value
-    active_at = max(coalesce(active_at, '1970-01-01T00:00:00'),
-                     (SELECT created_at FROM post WHERE id = NEW.id))
-WHERE id = NEW.thread_id;
+DELETE FROM post_search WHERE rowid = OLD.rowid;
 END;
 `)
 	if err != nil {
-		return boardDB, errors.Wrap(err, "failed to create thread update trigger")
+		return err
 	}
 
-	return boardDB, nil
+	_, err = boardDB.Exec(`
+CREATE TRIGGER IF NOT EXISTS reindex_topic
+AFTER UPDATE OF topic ON thread
+FOR EACH ROW
+BEGIN
+UPDATE post_search SET topic = NEW.topic
+WHERE rowid = (SELECT rowid FROM post WHERE board = NEW.board AND id = NEW.op_id);
+END;
+`)
+	return err
+}
+
+func initServerDB(path string) (*sql.DB, error) {
+	var serverDB *sql.DB
+	var err error
+
+	if serverDB, err = sql.Open("sqlite3", path+sqliteOptions); err != nil {
+		return serverDB, errors.Wrapf(err, "failed to connect to file %s", path)
+	}
+
+	if err = migrate(serverDB, serverMigrations); err != nil {
+		return serverDB, errors.Wrapf(err, "failed to migrate %s", path)
+	}
+
+	return serverDB, nil
 }
@@ -0,0 +1,87 @@
+package backend
+
+import (
+	"context"
+	"time"
+
+	"github.com/fgahr/termchan/tchan"
+	"github.com/fgahr/termchan/tchan/config"
+)
+
+// ephemeral serves boards configured as ephemeral from memory and all other
+// boards, as well as bans, from the wrapped backend.
+type ephemeral struct {
+	DB
+	conf *config.Settings
+	mem  *memory
+}
+
+// backend selects the backend responsible for a board.
+func (e *ephemeral) backend(boardName string) DB {
+	if b, ok := e.conf.BoardConfig(boardName); ok && b.Ephemeral {
+		return e.mem
+	}
+	return e.DB
+}
+
+func (e *ephemeral) Init() error {
+	if err := e.mem.Init(); err != nil {
+		return err
+	}
+	return e.DB.Init()
+}
+
+func (e *ephemeral) Refresh() error {
+	if err := e.mem.Refresh(); err != nil {
+		return err
+	}
+	return e.DB.Refresh()
+}
+
+func (e *ephemeral) PopulateBoard(ctx context.Context, boardName string, page int, b *tchan.BoardOverview, ok *bool) error {
+	return e.backend(boardName).PopulateBoard(ctx, boardName, page, b, ok)
+}
+
+func (e *ephemeral) PopulateCatalog(ctx context.Context, boardName string, c *tchan.Catalog, ok *bool) error {
+	return e.backend(boardName).PopulateCatalog(ctx, boardName, c, ok)
+}
+
+func (e *ephemeral) PopulateArchive(ctx context.Context, boardName string, c *tchan.Catalog, ok *bool) error {
+	return e.backend(boardName).PopulateArchive(ctx, boardName, c, ok)
+}
+
+func (e *ephemeral) PopulateThread(ctx context.Context, boardName string, postID int64, pr PostRange, thr *tchan.Thread, ok *bool) error {
+	return e.backend(boardName).PopulateThread(ctx, boardName, postID, pr, thr, ok)
+}
+
+func (e *ephemeral) PopulatePosts(ctx context.Context, boardName string, posts *[]tchan.Post, ok *bool) error {
+	return e.backend(boardName).PopulatePosts(ctx, boardName, posts, ok)
+}
+
+func (e *ephemeral) Search(ctx context.Context, boardName string, query string, limit int, results *[]tchan.SearchResult, ok *bool) error {
+	return e.backend(boardName).Search(ctx, boardName, query, limit, results, ok)
+}
+
+func (e *ephemeral) CreateThread(ctx context.Context, boardName string, topic string, op *tchan.Post) error {
+	return e.backend(boardName).CreateThread(ctx, boardName, topic, op)
+}
+
+func (e *ephemeral) AddReply(ctx context.Context, boardName string, postID int64, post *tchan.Post, ok *bool) error {
+	return e.backend(boardName).AddReply(ctx, boardName, postID, post, ok)
+}
+
+func (e *ephemeral) DeletePost(ctx context.Context, boardName string, postID int64, ok *bool) error {
+	return e.backend(boardName).DeletePost(ctx, boardName, postID, ok)
+}
+
+func (e *ephemeral) SetLocked(ctx context.Context, boardName string, postID int64, locked bool, ok *bool) error {
+	return e.backend(boardName).SetLocked(ctx, boardName, postID, locked, ok)
+}
+
+func (e *ephemeral) SetSticky(ctx context.Context, boardName string, postID int64, sticky bool, ok *bool) error {
+	return e.backend(boardName).SetSticky(ctx, boardName, postID, sticky, ok)
+}
+
+func (e *ephemeral) PopulateActivity(ctx context.Context, boardName string, ip string, content string, since time.Time, a *Activity) error {
+	return e.backend(boardName).PopulateActivity(ctx, boardName, ip, content, since, a)
+}
@@ -0,0 +1,4 @@
+package backend
+
+// ResetTestPostgres is exported for the conformance tests.
+var ResetTestPostgres = resetTestPostgres
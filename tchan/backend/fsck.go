@@ -0,0 +1,274 @@
+package backend
+
+import (
+	"database/sql"
+	"fmt"
+	"path/filepath"
+	"strings"
+
+	"github.com/lib/pq"
+	"github.com/pkg/errors"
+
+	"github.com/fgahr/termchan/tchan/config"
+	"github.com/fgahr/termchan/tchan/util"
+)
+
+// FsckReport lists the inconsistencies found in the database of a board.
+type FsckReport struct {
+	Board string
+	// Threads without any posts, e.g. left behind by a failed thread creation
+	OrphanedThreads []int64
+	// Posts whose thread does not exist
+	DanglingPosts []int64
+	// Threads whose OP, reply count or activity do not match their posts
+	InconsistentThreads []int64
+}
+
+// Clean tells whether no inconsistencies were found.
+func (r FsckReport) Clean() bool {
+	return len(r.OrphanedThreads) == 0 && len(r.DanglingPosts) == 0 && len(r.InconsistentThreads) == 0
+}
+
+// fsckDialect adapts the consistency checks to a database.
+type fsckDialect struct {
+	// Prepended to table names, e.g. a schema
+	prefix string
+	// Expression giving the current time
+	now string
+	// Quoted board name if rows carry one
+	board string
+}
+
+// format fills in the placeholders of a check or repair: %[1]s is the table
+// prefix, %[2]s the current time, %[3]s and %[4]s restrict the thread t and
+// post p to the board, %[5]s and %[6]s add the board to inserted rows.
+func (d fsckDialect) format(query string) string {
+	inThread, inPost, column, value := "TRUE", "TRUE", "", ""
+	if d.board != "" {
+		inThread, inPost = "t.board = "+d.board, "p.board = "+d.board
+		column, value = "board, ", d.board+", "
+	}
+	return fmt.Sprintf(query, d.prefix, d.now, inThread, inPost, column, value)
+}
+
+// The checks share their conditions with the repairs and hence have no
+// trailing ORDER BY.
+const (
+	fsckOrphanedThreads = `
+SELECT t.id FROM %[1]sthread t WHERE %[3]s AND NOT EXISTS (
+    SELECT 1 FROM %[1]spost p WHERE %[4]s AND p.thread_id = t.id)`
+	fsckDanglingPosts = `
+SELECT p.id FROM %[1]spost p WHERE %[4]s AND NOT EXISTS (
+    SELECT 1 FROM %[1]sthread t WHERE %[3]s AND t.id = p.thread_id)`
+	fsckInconsistentThreads = `
+SELECT t.id FROM %[1]sthread t
+INNER JOIN (
+    SELECT p.thread_id, min(p.id) AS op_id, count(*) - 1 AS num_replies, max(p.created_at) AS active_at
+    FROM %[1]spost p WHERE %[4]s GROUP BY p.thread_id
+) s ON s.thread_id = t.id
+WHERE %[3]s AND (t.op_id IS NULL OR t.op_id <> s.op_id OR t.num_replies <> s.num_replies
+OR t.active_at IS NULL OR t.active_at <> s.active_at)`
+)
+
+// fsckRepairs are applied in order. Dangling posts are restored to archived
+// threads with an empty topic, which are then completed by recomputing their
+// statistics.
+var fsckRepairs = []string{`
+INSERT INTO %[1]sthread (%[5]sid, topic, archived_at)
+SELECT DISTINCT %[6]sp.thread_id, '', %[2]s FROM %[1]spost p
+WHERE %[4]s AND p.id IN (` + fsckDanglingPosts + `);
+`, `
+DELETE FROM %[1]sthread AS t WHERE %[3]s AND t.id IN (` + fsckOrphanedThreads + `);
+`, `
+UPDATE %[1]sthread AS t SET
+    op_id = (SELECT min(p.id) FROM %[1]spost p WHERE %[4]s AND p.thread_id = t.id),
+    num_replies = (SELECT count(*) - 1 FROM %[1]spost p WHERE %[4]s AND p.thread_id = t.id),
+    active_at = (SELECT max(p.created_at) FROM %[1]spost p WHERE %[4]s AND p.thread_id = t.id),
+    created_at = coalesce(t.created_at, (SELECT min(p.created_at) FROM %[1]spost p WHERE %[4]s AND p.thread_id = t.id))
+WHERE %[3]s AND t.id IN (` + fsckInconsistentThreads + `);
+`}
+
+// Fsck checks the databases of all boards for inconsistencies, repairing them
+// unless dryRun is set. Orphaned threads are removed, dangling posts restored
+// to archived threads and the statistics of inconsistent threads recomputed.
+// All schemas have to be up to date.
+func Fsck(conf *config.Settings, dryRun bool) ([]FsckReport, error) {
+	statuses, err := CheckSchemas(conf)
+	if err != nil {
+		return nil, err
+	}
+	for _, st := range statuses {
+		if st.Exists && len(st.Pending) > 0 {
+			return nil, errors.Errorf("%s has pending migrations, run migrate first", st.Path)
+		}
+	}
+
+	switch conf.Database.Driver {
+	case config.Postgres:
+		return fsckPostgres(conf, dryRun)
+	case config.Memory:
+		// Nothing persisted to be checked
+		return nil, nil
+	}
+
+	if conf.Database.SingleFile() {
+		return fsckSingle(conf, dryRun)
+	}
+
+	reports := make([]FsckReport, 0, len(conf.Boards))
+	for _, b := range conf.Boards {
+		if b.Ephemeral {
+			continue
+		}
+		path := filepath.Join(conf.BoardsDirectory(), b.Name+".db")
+		db, err := openFsckDB(path, dryRun)
+		if err != nil {
+			return reports, err
+		} else if db == nil {
+			continue
+		}
+		report, err := fsckBoard(db, b.Name, sqliteFsckDialect(b.Name), dryRun)
+		db.Close()
+		if err != nil {
+			return reports, errors.Wrapf(err, "failed to check %s", path)
+		}
+		reports = append(reports, report)
+	}
+	return reports, nil
+}
+
+func fsckSingle(conf *config.Settings, dryRun bool) ([]FsckReport, error) {
+	path := conf.SingleDatabase()
+	db, err := openFsckDB(path, dryRun)
+	if err != nil || db == nil {
+		return nil, err
+	}
+	defer db.Close()
+
+	reports := make([]FsckReport, 0, len(conf.Boards))
+	for _, b := range conf.Boards {
+		if b.Ephemeral {
+			continue
+		}
+		report, err := fsckBoard(db, b.Name, sqliteFsckDialect(b.Name), dryRun)
+		if err != nil {
+			return reports, errors.Wrapf(err, "failed to check /%s/ in %s", b.Name, path)
+		}
+		reports = append(reports, report)
+	}
+	return reports, nil
+}
+
+// openFsckDB opens an SQLite database to be checked, read-only for a dry run.
+// A missing database gives nil.
+func openFsckDB(path string, dryRun bool) (*sql.DB, error) {
+	if exists, err := util.FileExists(path); err != nil {
+		return nil, errors.Wrapf(err, "failed to look for database %s", path)
+	} else if !exists {
+		return nil, nil
+	}
+
+	dsn := path
+	if dryRun {
+		dsn = "file:" + path + "?mode=ro"
+	}
+	db, err := sql.Open("sqlite3", dsn)
+	return db, errors.Wrapf(err, "failed to connect to file %s", path)
+}
+
+func sqliteFsckDialect(boardName string) fsckDialect {
+	return fsckDialect{
+		now:   "strftime('%Y-%m-%dT%H:%M:%SZ', 'now')",
+		board: "'" + strings.ReplaceAll(boardName, "'", "''") + "'",
+	}
+}
+
+func fsckPostgres(conf *config.Settings, dryRun bool) ([]FsckReport, error) {
+	dsn := conf.Database.DSN
+	if dryRun {
+		var err error
+		if dsn, err = pgReadOnlyDSN(dsn); err != nil {
+			return nil, errors.Wrap(err, "invalid connection string")
+		}
+	}
+	db, err := sql.Open("postgres", dsn)
+	if err != nil {
+		return nil, errors.Wrap(err, "failed to connect to postgres")
+	}
+	defer db.Close()
+
+	reports := make([]FsckReport, 0, len(conf.Boards))
+	for _, b := range conf.Boards {
+		if b.Ephemeral {
+			continue
+		}
+		schema := boardSchema(b.Name)
+		var exists bool
+		err := db.QueryRow(`SELECT to_regclass($1) IS NOT NULL;`, pq.QuoteIdentifier(schema)+".thread").Scan(&exists)
+		if err != nil {
+			return reports, errors.Wrapf(err, "failed to look for schema %s", schema)
+		} else if !exists {
+			continue
+		}
+
+		report, err := fsckBoard(db, b.Name, fsckDialect{prefix: pq.QuoteIdentifier(schema) + ".", now: "now()"}, dryRun)
+		if err != nil {
+			return reports, errors.Wrapf(err, "failed to check %s", schema)
+		}
+		reports = append(reports, report)
+	}
+	return reports, nil
+}
+
+// fsckBoard checks the database of a single board and repairs it unless
+// dryRun is set.
+func fsckBoard(db *sql.DB, boardName string, d fsckDialect, dryRun bool) (FsckReport, error) {
+	report := FsckReport{Board: boardName}
+	tx, err := db.Begin()
+	if err != nil {
+		return report, err
+	}
+	defer tx.Rollback()
+
+	checks := []struct {
+		query string
+		ids   *[]int64
+	}{
+		{fsckOrphanedThreads, &report.OrphanedThreads},
+		{fsckDanglingPosts, &report.DanglingPosts},
+		{fsckInconsistentThreads, &report.InconsistentThreads},
+	}
+	for _, c := range checks {
+		if *c.ids, err = queryIDs(tx, d.format(c.query)+"\nORDER BY 1;"); err != nil {
+			return report, err
+		}
+	}
+
+	if dryRun || report.Clean() {
+		return report, nil
+	}
+	for _, stmt := range fsckRepairs {
+		if _, err = tx.Exec(d.format(stmt)); err != nil {
+			return report, errors.Wrap(err, "repair failed")
+		}
+	}
+	return report, tx.Commit()
+}
+
+func queryIDs(tx *sql.Tx, query string) ([]int64, error) {
+	rows, err := tx.Query(query)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	ids := make([]int64, 0)
+	for rows.Next() {
+		var id int64
+		if err := rows.Scan(&id); err != nil {
+			return ids, err
+		}
+		ids = append(ids, id)
+	}
+	return ids, rows.Err()
+}
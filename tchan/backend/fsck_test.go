@@ -0,0 +1,84 @@
+package backend
+
+import (
+	"context"
+	"fmt"
+	"testing"
+
+	"github.com/fgahr/termchan/tchan"
+	"github.com/fgahr/termchan/tchan/config"
+)
+
+func TestFsck(t *testing.T) {
+	for _, layout := range []string{config.PerBoardLayout, config.SingleLayout} {
+		t.Run(layout, func(t *testing.T) { testFsck(t, layout) })
+	}
+}
+
+func testFsck(t *testing.T, layout string) {
+	ctx := context.Background()
+	db := newLayoutTestBackend(t, layout, tchan.Board{Name: "b"}, tchan.Board{Name: "g"})
+	// Thread 1 with posts 1 to 3
+	opID := createThread(t, db, "b", 2)
+	createThread(t, db, "g", 1)
+
+	for _, stmt := range []string{
+		`INSERT INTO thread (board, id, topic) VALUES ('b', 2, 'orphan');`,
+		`INSERT INTO post (board, id, thread_id, author, content) VALUES ('b', 4, 42, 'Anonymous', 'lost');`,
+		`UPDATE thread SET num_replies = 7 WHERE board = 'b' AND id = 1;`,
+	} {
+		if _, err := db.boardDBs["b"].Exec(stmt); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	for _, dryRun := range []bool{true, false} {
+		reports, err := Fsck(db.conf, dryRun)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if len(reports) != 2 || !reports[1].Clean() {
+			t.Fatalf("expected a clean report for /g/, got %+v", reports)
+		}
+		r := reports[0]
+		if fmt.Sprint(r.OrphanedThreads, r.DanglingPosts, r.InconsistentThreads) != "[2] [4] [1]" {
+			t.Errorf("expected orphaned thread 2, dangling post 4 and inconsistent thread 1, got %+v", r)
+		}
+	}
+
+	reports, err := Fsck(db.conf, false)
+	if err != nil || !reports[0].Clean() {
+		t.Errorf("expected no inconsistencies after repair, got %+v (%v)", reports, err)
+	}
+
+	thr := tchan.Thread{}
+	ok := false
+	if err := db.PopulateThread(ctx, "b", opID, PostRange{}, &thr, &ok); err != nil || thr.NumReplies() != 2 {
+		t.Errorf("expected 2 replies, got %d (%v)", thr.NumReplies(), err)
+	}
+	c := tchan.Catalog{}
+	if err := db.PopulateArchive(ctx, "b", &c, &ok); err != nil || len(c.Threads) != 1 || c.Threads[0].ID != 4 {
+		t.Errorf("expected dangling post to be restored as an archived thread, got %+v (%v)", c.Threads, err)
+	}
+}
+
+func TestCreateThreadAtomic(t *testing.T) {
+	ctx := context.Background()
+	db := newTestBackend(t, tchan.Board{Name: "b"})
+	_, err := db.boardDBs["b"].Exec(`
+CREATE TRIGGER fail_post BEFORE INSERT ON post WHEN NEW.content = 'fail'
+BEGIN SELECT RAISE(ABORT, 'rejected'); END;
+`)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	op := tchan.Post{Author: "Anonymous", Content: "fail"}
+	if err := db.CreateThread(ctx, "b", "topic", &op); err == nil {
+		t.Fatal("expected thread creation to fail")
+	}
+	reports, err := Fsck(db.conf, true)
+	if err != nil || !reports[0].Clean() {
+		t.Errorf("expected no orphaned thread, got %+v (%v)", reports, err)
+	}
+}
@@ -0,0 +1,682 @@
+package backend
+
+import (
+	"context"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+	"unicode"
+
+	"github.com/pkg/errors"
+
+	"github.com/fgahr/termchan/tchan"
+	"github.com/fgahr/termchan/tchan/config"
+)
+
+// memory keeps boards in memory only, reproducing the behaviour of the SQLite
+// backend. Its data is lost on shutdown but retained on refresh. Operations
+// never wait for I/O, hence contexts are not checked.
+type memory struct {
+	conf *config.Settings
+	// Whether only boards configured as ephemeral are served
+	ephemeralOnly bool
+	lock          sync.RWMutex
+	boards        map[string]*memBoard
+	bans          []memBan
+}
+
+type memBoard struct {
+	// In order of creation
+	threads []*memThread
+	posts   map[int64]*memPost
+	lastID  int64
+}
+
+type memThread struct {
+	opID       int64
+	topic      string
+	numReplies int
+	active     time.Time
+	archived   time.Time
+	locked     bool
+	sticky     bool
+	// In order of creation, starting with the OP
+	posts []*memPost
+}
+
+type memPost struct {
+	tchan.Post
+	thread  *memThread
+	deleted time.Time
+}
+
+type memBan struct {
+	tchan.Ban
+	lifted bool
+}
+
+func newMemory(conf *config.Settings, ephemeralOnly bool) *memory {
+	return &memory{conf: conf, ephemeralOnly: ephemeralOnly}
+}
+
+// now gives the current time at the precision stored by SQLite.
+func now() time.Time {
+	return time.Now().UTC().Truncate(time.Second)
+}
+
+func (m *memory) Init() error {
+	m.lock.Lock()
+	defer m.lock.Unlock()
+
+	m.boards = make(map[string]*memBoard)
+	m.bans = nil
+	m.initBoards()
+	return nil
+}
+
+func (m *memory) initBoards() {
+	boards := make(map[string]*memBoard)
+	for _, board := range m.conf.Boards {
+		if m.ephemeralOnly && !board.Ephemeral {
+			continue
+		}
+		if b, ok := m.boards[board.Name]; ok {
+			boards[board.Name] = b
+		} else {
+			boards[board.Name] = &memBoard{posts: make(map[int64]*memPost)}
+		}
+		m.archiveThreads(boards[board.Name], board)
+	}
+	m.boards = boards
+}
+
+// Refresh adds and removes boards according to the configuration. The
+// threads of remaining boards are kept.
+func (m *memory) Refresh() error {
+	m.lock.Lock()
+	defer m.lock.Unlock()
+
+	m.initBoards()
+	return nil
+}
+
+func (m *memory) Close() error {
+	return nil
+}
+
+// archiveThreads archives all threads which exceed the board's thread length
+// or have fallen off its last page.
+func (m *memory) archiveThreads(b *memBoard, bconf tchan.Board) {
+	t := now()
+	kept := 0
+	for _, thr := range b.activeThreads() {
+		if thr.numReplies > bconf.MaxThreadLength() || kept >= bconf.MaxThreads()*bconf.MaxPages() {
+			thr.archived = t
+		} else {
+			kept++
+		}
+	}
+}
+
+func (m *memory) archive(boardName string, b *memBoard) error {
+	bconf, confOK := m.conf.BoardConfig(boardName)
+	if !confOK {
+		return errors.Errorf("found board but no config for /%s/", boardName)
+	}
+	m.archiveThreads(b, bconf)
+	return nil
+}
+
+// activeThreads gives the threads which are not archived, in the order they
+// appear on the board.
+func (b *memBoard) activeThreads() []*memThread {
+	active := make([]*memThread, 0, len(b.threads))
+	for _, thr := range b.threads {
+		if thr.archived.IsZero() {
+			active = append(active, thr)
+		}
+	}
+	// Latest thread first on equal activity
+	for i, j := 0, len(active)-1; i < j; i, j = i+1, j-1 {
+		active[i], active[j] = active[j], active[i]
+	}
+	sort.SliceStable(active, func(i, j int) bool {
+		if active[i].sticky != active[j].sticky {
+			return active[i].sticky
+		}
+		return active[i].active.After(active[j].active)
+	})
+	return active
+}
+
+// served gives a post as fetched from the database, with deleted posts'
+// data removed.
+func (p *memPost) served() tchan.Post {
+	post := tchan.Post{
+		ID:        p.ID,
+		Author:    p.Author,
+		Tripcode:  p.Tripcode,
+		Timestamp: p.Timestamp,
+		Content:   p.Content,
+		Deleted:   !p.deleted.IsZero(),
+	}
+	tombstone(&post)
+	return post
+}
+
+func (thr *memThread) catalogEntry() tchan.CatalogEntry {
+	return tchan.CatalogEntry{
+		ID:         thr.opID,
+		Topic:      thr.topic,
+		NumReplies: thr.numReplies,
+		Active:     thr.active,
+		Locked:     thr.locked,
+		Sticky:     thr.sticky,
+	}
+}
+
+func (m *memory) PopulateBoard(ctx context.Context, boardName string, page int, b *tchan.BoardOverview, ok *bool) error {
+	m.lock.RLock()
+	defer m.lock.RUnlock()
+
+	board, boardOK := m.boards[boardName]
+	if !boardOK {
+		*ok = false
+		return nil
+	}
+
+	bconf, confOK := m.conf.BoardConfig(boardName)
+	if !confOK {
+		return errors.Errorf("found board but no config for /%s/", boardName)
+	}
+	*ok = true
+
+	active := board.activeThreads()
+	if page < 1 {
+		page = 1
+	}
+	b.Page = page
+	b.NumPages = (len(active) + bconf.MaxThreads() - 1) / bconf.MaxThreads()
+
+	b.Threads = make([]tchan.ThreadSummary, 0)
+	for i := (page - 1) * bconf.MaxThreads(); i < len(active) && i < page*bconf.MaxThreads(); i++ {
+		thr := active[i]
+		t := tchan.ThreadSummary{
+			Topic:      thr.topic,
+			OP:         thr.posts[0].served(),
+			NumReplies: thr.numReplies,
+			Active:     thr.active,
+			Locked:     thr.locked,
+			Sticky:     thr.sticky,
+		}
+		t.OP.ParseContent()
+		// Replies are only linked when viewing the thread
+		t.OP.QuotedBy = make([]int64, 0)
+		b.Threads = append(b.Threads, t)
+	}
+
+	return nil
+}
+
+func (m *memory) PopulateCatalog(ctx context.Context, boardName string, c *tchan.Catalog, ok *bool) error {
+	m.lock.RLock()
+	defer m.lock.RUnlock()
+
+	board, boardOK := m.boards[boardName]
+	if *ok = boardOK; !boardOK {
+		return nil
+	}
+
+	c.Threads = make([]tchan.CatalogEntry, 0)
+	for _, thr := range board.activeThreads() {
+		c.Threads = append(c.Threads, thr.catalogEntry())
+	}
+	return nil
+}
+
+func (m *memory) PopulateArchive(ctx context.Context, boardName string, c *tchan.Catalog, ok *bool) error {
+	m.lock.RLock()
+	defer m.lock.RUnlock()
+
+	board, boardOK := m.boards[boardName]
+	if *ok = boardOK; !boardOK {
+		return nil
+	}
+	c.Archived = true
+
+	archived := make([]*memThread, 0)
+	for i := len(board.threads) - 1; i >= 0; i-- {
+		if !board.threads[i].archived.IsZero() {
+			archived = append(archived, board.threads[i])
+		}
+	}
+	sort.SliceStable(archived, func(i, j int) bool {
+		return archived[i].archived.After(archived[j].archived)
+	})
+
+	c.Threads = make([]tchan.CatalogEntry, 0)
+	for _, thr := range archived {
+		c.Threads = append(c.Threads, thr.catalogEntry())
+	}
+	return nil
+}
+
+func (m *memory) PopulateThread(ctx context.Context, boardName string, postID int64, pr PostRange, thr *tchan.Thread, ok *bool) error {
+	m.lock.RLock()
+	defer m.lock.RUnlock()
+
+	*ok = false
+	board, boardOK := m.boards[boardName]
+	if !boardOK {
+		return nil
+	}
+	post, postOK := board.posts[postID]
+	if !postOK {
+		return nil
+	}
+	*ok = true
+
+	t := post.thread
+	thr.Topic = t.topic
+	thr.Archived = !t.archived.IsZero()
+	thr.Locked = t.locked
+	thr.Sticky = t.sticky
+
+	replies := make([]*memPost, 0)
+	for _, p := range t.posts[1:] {
+		if p.ID > pr.After {
+			replies = append(replies, p)
+		}
+	}
+	switch {
+	case pr.Last > 0:
+		if len(replies) > pr.Last {
+			replies = replies[len(replies)-pr.Last:]
+		}
+	case pr.Page > 0:
+		start := (pr.Page - 1) * pr.PageSize
+		if start > len(replies) {
+			start = len(replies)
+		}
+		end := start + pr.PageSize
+		if end > len(replies) {
+			end = len(replies)
+		}
+		replies = replies[start:end]
+	}
+
+	thr.Posts = []tchan.Post{t.posts[0].served()}
+	for _, p := range replies {
+		thr.Posts = append(thr.Posts, p.served())
+	}
+
+	thr.OmittedBefore = t.numReplies
+	if len(replies) > 0 {
+		thr.OmittedBefore = 0
+		for _, p := range t.posts[1:] {
+			if p.ID < replies[0].ID {
+				thr.OmittedBefore++
+			}
+		}
+	}
+	thr.OmittedAfter = t.numReplies - thr.OmittedBefore - len(replies)
+	thr.LinkQuotes()
+
+	return nil
+}
+
+func (m *memory) PopulatePosts(ctx context.Context, boardName string, posts *[]tchan.Post, ok *bool) error {
+	m.lock.RLock()
+	defer m.lock.RUnlock()
+
+	board, boardOK := m.boards[boardName]
+	if *ok = boardOK; !boardOK {
+		return nil
+	}
+
+	*posts = make([]tchan.Post, 0)
+	for id := int64(1); id <= board.lastID; id++ {
+		if p, exists := board.posts[id]; exists && p.deleted.IsZero() {
+			*posts = append(*posts, p.served())
+		}
+	}
+	return nil
+}
+
+func (m *memory) CreateThread(ctx context.Context, boardName string, topic string, op *tchan.Post) error {
+	m.lock.Lock()
+	defer m.lock.Unlock()
+
+	board, boardOK := m.boards[boardName]
+	if !boardOK {
+		return errors.Errorf("attempting to create thread on non-existing board /%s/", boardName)
+	}
+
+	thr := &memThread{topic: topic, numReplies: -1}
+	board.threads = append(board.threads, thr)
+	board.addPost(thr, op)
+
+	return m.archive(boardName, board)
+}
+
+// addPost adds a post to a thread, as done by the update_thread_timestamp
+// trigger.
+func (b *memBoard) addPost(thr *memThread, post *tchan.Post) {
+	b.lastID++
+	post.ID = b.lastID
+	p := &memPost{Post: *post, thread: thr}
+	p.Timestamp = now()
+	b.posts[p.ID] = p
+
+	thr.posts = append(thr.posts, p)
+	thr.numReplies++
+	if thr.opID == 0 {
+		thr.opID = p.ID
+	}
+	if p.Timestamp.After(thr.active) {
+		thr.active = p.Timestamp
+	}
+}
+
+func (m *memory) AddReply(ctx context.Context, boardName string, postID int64, post *tchan.Post, ok *bool) error {
+	m.lock.Lock()
+	defer m.lock.Unlock()
+
+	board, boardOK := m.boards[boardName]
+	if !boardOK {
+		return errors.Errorf("attempting to add post on non-existing board /%s/", boardName)
+	}
+
+	p, postOK := board.posts[postID]
+	if *ok = postOK; !postOK {
+		return nil
+	}
+	if !p.thread.archived.IsZero() {
+		return ErrThreadArchived
+	}
+	if p.thread.locked {
+		return ErrThreadLocked
+	}
+
+	board.addPost(p.thread, post)
+	return m.archive(boardName, board)
+}
+
+func (m *memory) DeletePost(ctx context.Context, boardName string, postID int64, ok *bool) error {
+	m.lock.Lock()
+	defer m.lock.Unlock()
+
+	board, boardOK := m.boards[boardName]
+	if !boardOK {
+		return errors.Errorf("attempting to delete post on non-existing board /%s/", boardName)
+	}
+
+	p, postOK := board.posts[postID]
+	if *ok = postOK; postOK && p.deleted.IsZero() {
+		p.deleted = now()
+	}
+	return nil
+}
+
+func (m *memory) SetLocked(ctx context.Context, boardName string, postID int64, locked bool, ok *bool) error {
+	return m.setThreadFlag(boardName, postID, func(thr *memThread) { thr.locked = locked }, ok)
+}
+
+func (m *memory) SetSticky(ctx context.Context, boardName string, postID int64, sticky bool, ok *bool) error {
+	return m.setThreadFlag(boardName, postID, func(thr *memThread) { thr.sticky = sticky }, ok)
+}
+
+func (m *memory) setThreadFlag(boardName string, postID int64, set func(*memThread), ok *bool) error {
+	m.lock.Lock()
+	defer m.lock.Unlock()
+
+	board, boardOK := m.boards[boardName]
+	if !boardOK {
+		return errors.Errorf("attempting to moderate thread on non-existing board /%s/", boardName)
+	}
+
+	p, postOK := board.posts[postID]
+	if *ok = postOK; !postOK {
+		return nil
+	}
+	set(p.thread)
+
+	// Unsticking a thread might push it off the board
+	return m.archive(boardName, board)
+}
+
+func (m *memory) PopulateActivity(ctx context.Context, boardName string, ip string, content string, since time.Time, a *Activity) error {
+	m.lock.RLock()
+	defer m.lock.RUnlock()
+
+	board, boardOK := m.boards[boardName]
+	if !boardOK {
+		return errors.Errorf("attempting to check activity on non-existing board /%s/", boardName)
+	}
+
+	*a = Activity{}
+	for _, p := range board.posts {
+		if p.AuthorIP != ip {
+			continue
+		}
+		if p.Timestamp.After(a.LastPost) {
+			a.LastPost = p.Timestamp
+		}
+		if p.ID == p.thread.opID && p.Timestamp.After(a.LastThread) {
+			a.LastThread = p.Timestamp
+		}
+		if p.Content == content && !p.Timestamp.Before(since.Truncate(time.Second)) {
+			a.Duplicate = true
+		}
+	}
+	return nil
+}
+
+// searchTokens splits text into lower-case words, similar to SQLite's
+// unicode61 tokenizer. Each token is given as its byte offsets.
+func searchTokens(text string) [][2]int {
+	tokens := make([][2]int, 0)
+	start := -1
+	for i, r := range text {
+		word := unicode.IsLetter(r) || unicode.IsDigit(r)
+		if word && start < 0 {
+			start = i
+		} else if !word && start >= 0 {
+			tokens = append(tokens, [2]int{start, i})
+			start = -1
+		}
+	}
+	if start >= 0 {
+		tokens = append(tokens, [2]int{start, len(text)})
+	}
+	return tokens
+}
+
+// Number of tokens included in a snippet, as with SQLite
+const snippetTokens = 16
+
+// searchSnippet marks the matching terms in a window of the content around
+// the first match.
+func searchSnippet(content string, tokens [][2]int, terms map[string]bool) string {
+	first := 0
+	for i, tok := range tokens {
+		if terms[strings.ToLower(content[tok[0]:tok[1]])] {
+			first = i
+			break
+		}
+	}
+	start := first - snippetTokens/4
+	if start < 0 || len(tokens) <= snippetTokens {
+		start = 0
+	} else if start > len(tokens)-snippetTokens {
+		start = len(tokens) - snippetTokens
+	}
+	end := start + snippetTokens
+	if end > len(tokens) {
+		end = len(tokens)
+	}
+
+	sb := strings.Builder{}
+	pos := 0
+	if start > 0 {
+		sb.WriteString("...")
+		pos = tokens[start][0]
+	}
+	for _, tok := range tokens[start:end] {
+		sb.WriteString(content[pos:tok[0]])
+		word := content[tok[0]:tok[1]]
+		if terms[strings.ToLower(word)] {
+			sb.WriteString(tchan.SnippetMatchStart + word + tchan.SnippetMatchEnd)
+		} else {
+			sb.WriteString(word)
+		}
+		pos = tok[1]
+	}
+	if end < len(tokens) {
+		sb.WriteString("...")
+	} else {
+		sb.WriteString(content[pos:])
+	}
+	return sb.String()
+}
+
+// Search matches posts containing all words of the query in either their
+// content or, for OPs, their thread's topic. Posts are ranked by the number of
+// matching words.
+func (m *memory) Search(ctx context.Context, boardName string, query string, limit int, results *[]tchan.SearchResult, ok *bool) error {
+	m.lock.RLock()
+	defer m.lock.RUnlock()
+
+	board, boardOK := m.boards[boardName]
+	if *ok = boardOK; !boardOK {
+		return nil
+	}
+
+	*results = make([]tchan.SearchResult, 0)
+	terms := make(map[string]bool)
+	for _, tok := range searchTokens(query) {
+		terms[strings.ToLower(query[tok[0]:tok[1]])] = true
+	}
+	if len(terms) == 0 {
+		return nil
+	}
+
+	for id := board.lastID; id > 0; id-- {
+		p, exists := board.posts[id]
+		if !exists || !p.deleted.IsZero() {
+			continue
+		}
+		tokens := searchTokens(p.Content)
+		counts := make(map[string]int)
+		for _, tok := range tokens {
+			counts[strings.ToLower(p.Content[tok[0]:tok[1]])]++
+		}
+		if p.ID == p.thread.opID {
+			for _, tok := range searchTokens(p.thread.topic) {
+				counts[strings.ToLower(p.thread.topic[tok[0]:tok[1]])]++
+			}
+		}
+
+		hits := 0
+		for term := range terms {
+			if counts[term] == 0 {
+				hits = 0
+				break
+			}
+			hits += counts[term]
+		}
+		if hits == 0 {
+			continue
+		}
+
+		*results = append(*results, tchan.SearchResult{
+			Board:     boardName,
+			ThreadID:  p.thread.opID,
+			PostID:    p.ID,
+			Topic:     p.thread.topic,
+			Author:    p.Author,
+			Tripcode:  p.Tripcode,
+			Timestamp: p.Timestamp,
+			Snippet:   tchan.ParseSnippet(searchSnippet(p.Content, tokens, terms)),
+			Rank:      -float64(hits),
+		})
+	}
+
+	// Ordered by rank, latest post first
+	sort.SliceStable(*results, func(i, j int) bool {
+		return (*results)[i].Rank < (*results)[j].Rank
+	})
+	if len(*results) > limit {
+		*results = (*results)[:limit]
+	}
+	return nil
+}
+
+func (m *memory) AddBan(ctx context.Context, ban *tchan.Ban) error {
+	m.lock.Lock()
+	defer m.lock.Unlock()
+
+	ban.ID = int64(len(m.bans) + 1)
+	ban.Created = now()
+	if ban.Expires != nil {
+		expires := ban.Expires.UTC().Truncate(time.Second)
+		ban.Expires = &expires
+	}
+	m.bans = append(m.bans, memBan{Ban: *ban})
+	return nil
+}
+
+func (m *memory) LiftBan(ctx context.Context, banID int64, ok *bool) error {
+	m.lock.Lock()
+	defer m.lock.Unlock()
+
+	*ok = false
+	if banID > 0 && banID <= int64(len(m.bans)) && !m.bans[banID-1].lifted {
+		m.bans[banID-1].lifted = true
+		*ok = true
+	}
+	return nil
+}
+
+func (b memBan) active(t time.Time) bool {
+	return !b.lifted && (b.Expires == nil || b.Expires.After(t))
+}
+
+func (m *memory) ListBans(ctx context.Context, includeExpired bool, bans *[]tchan.Ban) error {
+	m.lock.RLock()
+	defer m.lock.RUnlock()
+
+	t := time.Now()
+	*bans = make([]tchan.Ban, 0)
+	for _, b := range m.bans {
+		if includeExpired || b.active(t) {
+			*bans = append(*bans, b.Ban)
+		}
+	}
+	return nil
+}
+
+func (m *memory) FindBan(ctx context.Context, boardName string, ip string, ban *tchan.Ban, ok *bool) error {
+	m.lock.RLock()
+	defer m.lock.RUnlock()
+
+	t := time.Now()
+	candidates := make([]tchan.Ban, 0)
+	for _, b := range m.bans {
+		if b.active(t) && (b.Board == "" || b.Board == boardName) && b.Matches(ip) {
+			candidates = append(candidates, b.Ban)
+		}
+	}
+
+	// The longest lasting ban is found first
+	sort.SliceStable(candidates, func(i, j int) bool {
+		ei, ej := candidates[i].Expires, candidates[j].Expires
+		return ei == nil && ej != nil || ei != nil && ej != nil && ei.After(*ej)
+	})
+	*ok = len(candidates) > 0
+	if *ok {
+		*ban = candidates[0]
+	}
+	return nil
+}
@@ -0,0 +1,168 @@
+package backend
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/fgahr/termchan/tchan"
+	"github.com/fgahr/termchan/tchan/config"
+)
+
+func newTestMemory(t *testing.T, boards ...tchan.Board) *memory {
+	conf := config.Defaults()
+	conf.Database.Driver = config.Memory
+	conf.Boards = boards
+	m := newMemory(&conf, false)
+	if err := m.Init(); err != nil {
+		t.Fatal(err)
+	}
+	return m
+}
+
+func TestMemoryThreadRanges(t *testing.T) {
+	ctx := context.Background()
+	db := newTestMemory(t, tchan.Board{Name: "b"})
+	opID := createThread(t, db, "b", 10)
+
+	cases := []struct {
+		pr            PostRange
+		ids           string
+		before, after int
+	}{
+		{PostRange{Last: 3}, "[1 9 10 11]", 7, 0},
+		{PostRange{After: 5, Last: 2}, "[1 10 11]", 8, 0},
+		{PostRange{Page: 2, PageSize: 3}, "[1 5 6 7]", 3, 4},
+		{PostRange{Page: 5, PageSize: 3}, "[1]", 10, 0},
+	}
+	for _, c := range cases {
+		thr := tchan.Thread{}
+		ok := false
+		if err := db.PopulateThread(ctx, "b", opID, c.pr, &thr, &ok); err != nil || !ok {
+			t.Fatalf("%+v: failed to fetch thread: %v", c.pr, err)
+		}
+		if ids := fmt.Sprint(postIDs(thr.Posts)); ids != c.ids {
+			t.Errorf("%+v: expected posts %s, got %s", c.pr, c.ids, ids)
+		}
+		if thr.OmittedBefore != c.before || thr.OmittedAfter != c.after {
+			t.Errorf("%+v: expected %d/%d omitted, got %d/%d",
+				c.pr, c.before, c.after, thr.OmittedBefore, thr.OmittedAfter)
+		}
+	}
+}
+
+func TestMemoryArchive(t *testing.T) {
+	ctx := context.Background()
+	db := newTestMemory(t, tchan.Board{Name: "b", ThreadsMax: 1, PagesMax: 2, ThreadLengthMax: 3})
+	long := createThread(t, db, "b", 4)
+	first := createThread(t, db, "b", 0)
+	second := createThread(t, db, "b", 0)
+	third := createThread(t, db, "b", 0)
+
+	c := tchan.Catalog{}
+	ok := false
+	if err := db.PopulateCatalog(ctx, "b", &c, &ok); err != nil || !ok {
+		t.Fatal(err)
+	}
+	if len(c.Threads) != 2 || c.Threads[0].ID != third || c.Threads[1].ID != second {
+		t.Errorf("expected threads %d and %d to be active, got %+v", third, second, c.Threads)
+	}
+
+	a := tchan.Catalog{}
+	if err := db.PopulateArchive(ctx, "b", &a, &ok); err != nil || !ok {
+		t.Fatal(err)
+	}
+	archived := make(map[int64]bool)
+	for _, e := range a.Threads {
+		archived[e.ID] = true
+	}
+	if len(archived) != 2 || !archived[long] || !archived[first] {
+		t.Errorf("expected threads %d and %d to be archived, got %+v", long, first, a.Threads)
+	}
+}
+
+func TestMemorySearch(t *testing.T) {
+	ctx := context.Background()
+	db := newTestMemory(t, tchan.Board{Name: "b"})
+	op := tchan.Post{Author: "Anonymous", Content: "first post"}
+	if err := db.CreateThread(ctx, "b", "Gardening", &op); err != nil {
+		t.Fatal(err)
+	}
+	reply := tchan.Post{Author: "Anonymous", Content: "Tomatoes need sun, tomatoes need water."}
+	ok := false
+	if err := db.AddReply(ctx, "b", op.ID, &reply, &ok); err != nil || !ok {
+		t.Fatal(err)
+	}
+
+	var results []tchan.SearchResult
+	if err := db.Search(ctx, "b", "tomatoes water", 10, &results, &ok); err != nil || len(results) != 1 {
+		t.Fatalf("expected a single result, got %+v (%v)", results, err)
+	}
+	snippet := ""
+	for _, part := range results[0].Snippet {
+		if part.Match {
+			snippet += "[" + part.Text + "]"
+		} else {
+			snippet += part.Text
+		}
+	}
+	if expected := "[Tomatoes] need sun, [tomatoes] need [water]."; snippet != expected {
+		t.Errorf("expected snippet %q, got %q", expected, snippet)
+	}
+
+	if err := db.Search(ctx, "b", "gardening", 10, &results, &ok); err != nil || len(results) != 1 || results[0].PostID != op.ID {
+		t.Errorf("expected OP to be found by topic, got %+v (%v)", results, err)
+	}
+	if err := db.DeletePost(ctx, "b", reply.ID, &ok); err != nil || !ok {
+		t.Fatal(err)
+	}
+	if err := db.Search(ctx, "b", "tomatoes", 10, &results, &ok); err != nil || len(results) != 0 {
+		t.Errorf("expected deleted post not to be found, got %+v (%v)", results, err)
+	}
+}
+
+func TestEphemeralBoards(t *testing.T) {
+	ctx := context.Background()
+	conf := config.Defaults()
+	dir := t.TempDir()
+	if err := conf.SetWorkingDirectory(dir); err != nil {
+		t.Fatal(err)
+	}
+	conf.Boards = []tchan.Board{{Name: "b"}, {Name: "tmp", Ephemeral: true}}
+	db, err := New(&conf)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := db.Init(); err != nil {
+		t.Fatal(err)
+	}
+	defer db.Close()
+
+	createThread(t, db, "b", 0)
+	opID := createThread(t, db, "tmp", 2)
+	if _, err := os.Stat(filepath.Join(conf.BoardsDirectory(), "tmp.db")); !os.IsNotExist(err) {
+		t.Errorf("expected no database for ephemeral board, got %v", err)
+	}
+
+	// Threads survive a reload but not a restart
+	if err := db.Refresh(); err != nil {
+		t.Fatal(err)
+	}
+	thr := tchan.Thread{}
+	ok := false
+	if err := db.PopulateThread(ctx, "tmp", opID, PostRange{}, &thr, &ok); err != nil || !ok || len(thr.Posts) != 3 {
+		t.Errorf("expected thread to survive reload, got %+v (%v)", thr, err)
+	}
+	db.Close()
+	if err := db.Init(); err != nil {
+		t.Fatal(err)
+	}
+	if err := db.PopulateThread(ctx, "tmp", opID, PostRange{}, &thr, &ok); err != nil || ok {
+		t.Errorf("expected thread to be gone after restart, got %+v (%v)", thr, err)
+	}
+	if err := db.PopulateThread(ctx, "b", 1, PostRange{}, &thr, &ok); err != nil || !ok {
+		t.Errorf("expected persistent thread to remain, got %v", err)
+	}
+}
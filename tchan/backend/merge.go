@@ -0,0 +1,124 @@
+package backend
+
+import (
+	"database/sql"
+	"path/filepath"
+
+	"github.com/pkg/errors"
+
+	"github.com/fgahr/termchan/tchan/config"
+	"github.com/fgahr/termchan/tchan/util"
+)
+
+// MergeReport tells how much of a board was copied into the single database.
+type MergeReport struct {
+	Board   string
+	Threads int64
+	Posts   int64
+}
+
+// MergeBoards copies the per-board databases into the single database of the
+// configured single layout, keeping the IDs of all threads and posts. Boards
+// without a database are skipped, boards already present in the single
+// database refused. The per-board databases are only migrated, not otherwise
+// changed. The search index is rebuilt on the next start.
+func MergeBoards(conf *config.Settings) ([]MergeReport, error) {
+	if !conf.Database.SingleFile() {
+		return nil, errors.Errorf("merging requires the %s driver with the %s layout",
+			config.SQLite, config.SingleLayout)
+	}
+
+	// Without search triggers, copied posts are not indexed one by one
+	db, err := openBoardDB(conf.SingleDatabase(), singleMigrations, false)
+	if db != nil {
+		defer db.Close()
+	}
+	if err != nil {
+		return nil, err
+	}
+	// Attached databases are only visible to a single connection
+	db.SetMaxOpenConns(1)
+
+	reports := make([]MergeReport, 0, len(conf.Boards))
+	for _, b := range conf.Boards {
+		if b.Ephemeral {
+			continue
+		}
+		path := filepath.Join(conf.BoardsDirectory(), b.Name+".db")
+		if exists, err := util.FileExists(path); err != nil {
+			return reports, errors.Wrapf(err, "failed to look for database %s", path)
+		} else if !exists {
+			continue
+		}
+
+		if err := migrateFile(path, boardMigrations(b.Name)); err != nil {
+			return reports, err
+		}
+		report, err := mergeBoard(db, path, b.Name)
+		if err != nil {
+			return reports, errors.Wrapf(err, "failed to merge %s", path)
+		}
+		reports = append(reports, report)
+	}
+	return reports, nil
+}
+
+func migrateFile(path string, migrations []migration) error {
+	db, err := sql.Open("sqlite3", path)
+	if err != nil {
+		return errors.Wrapf(err, "failed to connect to file %s", path)
+	}
+	defer db.Close()
+	return errors.Wrapf(migrate(db, migrations), "failed to migrate %s", path)
+}
+
+// mergeBoard copies the threads and posts of a per-board database into the
+// single database within one transaction.
+func mergeBoard(db *sql.DB, path string, boardName string) (MergeReport, error) {
+	report := MergeReport{Board: boardName}
+	// Not possible within a transaction
+	if _, err := db.Exec(`ATTACH DATABASE ? AS source;`, path); err != nil {
+		return report, err
+	}
+	defer db.Exec(`DETACH DATABASE source;`)
+
+	tx, err := db.Begin()
+	if err != nil {
+		return report, err
+	}
+	defer tx.Rollback()
+
+	var existing int
+	if err = tx.QueryRow(`SELECT count(*) FROM main.thread WHERE board = ?;`, boardName).Scan(&existing); err != nil {
+		return report, err
+	} else if existing > 0 {
+		return report, errors.Errorf("/%s/ already has %d threads in the single database", boardName, existing)
+	}
+
+	result, err := tx.Exec(`
+INSERT INTO main.thread (board, id, op_id, num_replies, topic, created_at, active_at, archived_at, locked, sticky)
+SELECT ?, id, op_id, num_replies, topic, created_at, active_at, archived_at, locked, sticky
+FROM source.thread;
+`, boardName)
+	if err != nil {
+		return report, errors.Wrap(err, "failed to copy threads")
+	}
+	if report.Threads, err = result.RowsAffected(); err != nil {
+		return report, err
+	}
+
+	// Posts with a timestamp leave their thread as it is
+	result, err = tx.Exec(`
+INSERT INTO main.post (board, id, thread_id, author, tripcode, author_ip, content, created_at, deleted_at)
+SELECT ?, id, thread_id, author, tripcode, author_ip, content, created_at, deleted_at
+FROM source.post;
+`, boardName)
+	if err != nil {
+		return report, errors.Wrap(err, "failed to copy posts")
+	}
+	if report.Posts, err = result.RowsAffected(); err != nil {
+		return report, err
+	}
+
+	return report, tx.Commit()
+}
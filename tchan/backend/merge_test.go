@@ -0,0 +1,67 @@
+package backend
+
+import (
+	"context"
+	"reflect"
+	"testing"
+
+	"github.com/fgahr/termchan/tchan"
+	"github.com/fgahr/termchan/tchan/config"
+)
+
+func TestMergeBoards(t *testing.T) {
+	ctx := context.Background()
+	db := newTestBackend(t, tchan.Board{Name: "b"}, tchan.Board{Name: "g"})
+	createThread(t, db, "b", 2)
+	bID := createThread(t, db, "b", 1)
+	gID := createThread(t, db, "g", 3)
+	ok := false
+	if err := db.DeletePost(ctx, "b", bID+1, &ok); err != nil || !ok {
+		t.Fatal(err)
+	}
+
+	before := make(map[string]tchan.Thread)
+	for board, id := range map[string]int64{"b": bID, "g": gID} {
+		thr := tchan.Thread{}
+		if err := db.PopulateThread(ctx, board, id, PostRange{}, &thr, &ok); err != nil || !ok {
+			t.Fatal(err)
+		}
+		before[board] = thr
+	}
+	db.Close()
+
+	conf := *db.conf
+	conf.Database.Layout = config.SingleLayout
+	reports, err := MergeBoards(&conf)
+	if err != nil {
+		t.Fatal(err)
+	}
+	expected := []MergeReport{{"b", 2, 5}, {"g", 1, 4}}
+	if !reflect.DeepEqual(reports, expected) {
+		t.Errorf("expected reports %+v, got %+v", expected, reports)
+	}
+
+	merged := &sqlite{conf: &conf}
+	if err := merged.Init(); err != nil {
+		t.Fatal(err)
+	}
+	defer merged.Close()
+	for board, id := range map[string]int64{"b": bID, "g": gID} {
+		thr := tchan.Thread{}
+		if err := merged.PopulateThread(ctx, board, id, PostRange{}, &thr, &ok); err != nil || !ok {
+			t.Fatalf("/%s/: failed to fetch merged thread %d: %v", board, id, err)
+		}
+		if !reflect.DeepEqual(thr, before[board]) {
+			t.Errorf("/%s/: expected thread %+v, got %+v", board, before[board], thr)
+		}
+	}
+
+	// IDs continue per board
+	if id := createThread(t, merged, "g", 0); id != 5 {
+		t.Errorf("expected new thread on /g/ to start with post 5, got %d", id)
+	}
+
+	if _, err := MergeBoards(&conf); err == nil {
+		t.Error("expected merging twice to fail")
+	}
+}
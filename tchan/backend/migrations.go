@@ -0,0 +1,387 @@
+package backend
+
+import (
+	"database/sql"
+	"fmt"
+	"path/filepath"
+
+	"github.com/pkg/errors"
+
+	"github.com/fgahr/termchan/tchan/config"
+	"github.com/fgahr/termchan/tchan/util"
+)
+
+// execer is implemented by both databases and transactions.
+type execer interface {
+	Exec(query string, args ...interface{}) (sql.Result, error)
+	QueryRow(query string, args ...interface{}) *sql.Row
+}
+
+// migration is a single step in the evolution of a database schema. The
+// schema version of a database, stored as its user_version, is the number of
+// migrations applied to it.
+type migration struct {
+	descr string
+	apply func(db execer) error
+}
+
+// statements creates a migration step executing the given statements in order.
+func statements(stmts ...string) func(execer) error {
+	return func(db execer) error {
+		for _, stmt := range stmts {
+			if _, err := db.Exec(stmt); err != nil {
+				return err
+			}
+		}
+		return nil
+	}
+}
+
+// ensureColumn adds a column to an existing table unless it is present.
+func ensureColumn(db execer, table string, column string, decl string) error {
+	var n int
+	err := db.QueryRow(`
+SELECT count(*) FROM pragma_table_info(?) WHERE name = ?;
+`, table, column).Scan(&n)
+	if err != nil || n > 0 {
+		return err
+	}
+
+	_, err = db.Exec(fmt.Sprintf("ALTER TABLE %s ADD COLUMN %s %s;", table, column, decl))
+	return err
+}
+
+// NOTE: Databases created before versioning was introduced have version 0
+// regardless of their actual schema. The migrations up to and including
+// "index posts by author address" must hence tolerate already being applied.
+// Later migrations need not.
+//
+// Rows carry the name of their board, as in the single layout, so that both
+// layouts share their queries.
+func boardMigrations(boardName string) []migration {
+	return []migration{
+		{"create thread and post tables", statements(`
+CREATE TABLE IF NOT EXISTS thread (
+    id INTEGER PRIMARY KEY,
+    op_id INTEGER,
+    num_replies INTEGER DEFAULT -1,
+    topic TEXT,
+    created_at TEXT,
+    active_at TEXT
+);
+`, `
+CREATE TABLE IF NOT EXISTS post (
+    id INTEGER PRIMARY KEY,
+    thread_id INTEGER NOT NULL,
+    author TEXT,
+    author_ip TEXT,
+    content TEXT NOT NULL,
+    created_at TEXT,
+    FOREIGN KEY(thread_id) REFERENCES thread(id) NOT DEFERRABLE
+);
+`, `
+CREATE INDEX IF NOT EXISTS post_by_thread_id ON post(thread_id);
+`, `
+CREATE TRIGGER IF NOT EXISTS update_thread_timestamp
+AFTER INSERT ON post
+FOR EACH ROW
+BEGIN
+UPDATE post SET
+-- SQLite3 uses UTC internally so we can add the static 'Z' time zone suffix
+created_at = strftime('%Y-%m-%dT%H:%M:%SZ', 'now')
+    WHERE post.id = NEW.id;
+
+UPDATE thread
+SET num_replies = num_replies + 1,
+    op_id = coalesce(op_id, NEW.id),
+    created_at = coalesce(created_at, (SELECT created_at FROM post WHERE id = NEW.id)),
+    -- max() doesn't work with NULL so we make sure to indeed have a value
+    active_at = max(coalesce(active_at, '1970-01-01T00:00:00'),
+                     (SELECT created_at FROM post WHERE id = NEW.id))
+WHERE id = NEW.thread_id;
+END;
+`)},
+		{"archive threads", func(db execer) error {
+			return ensureColumn(db, "thread", "archived_at", "TEXT")
+		}},
+		{"lock, sticky and delete", func(db execer) error {
+			if err := ensureColumn(db, "thread", "locked", "INTEGER DEFAULT 0"); err != nil {
+				return err
+			}
+			if err := ensureColumn(db, "thread", "sticky", "INTEGER DEFAULT 0"); err != nil {
+				return err
+			}
+			return ensureColumn(db, "post", "deleted_at", "TEXT")
+		}},
+		{"tripcodes", func(db execer) error {
+			return ensureColumn(db, "post", "tripcode", "TEXT")
+		}},
+		{"index posts by author address", statements(`
+CREATE INDEX IF NOT EXISTS post_by_author_ip ON post(author_ip);
+`)},
+		{"board column", func(db execer) error {
+			for _, table := range []string{"thread", "post"} {
+				if err := ensureColumn(db, table, "board", "TEXT"); err != nil {
+					return err
+				}
+				if _, err := db.Exec("UPDATE "+table+" SET board = ?;", boardName); err != nil {
+					return err
+				}
+			}
+			return nil
+		}},
+	}
+}
+
+// singleMigrations set up the database holding all boards in the single
+// layout. Post and thread IDs are only unique per board.
+var singleMigrations = []migration{
+	{"create thread and post tables", statements(`
+CREATE TABLE thread (
+    board TEXT NOT NULL,
+    id INTEGER NOT NULL,
+    op_id INTEGER,
+    num_replies INTEGER DEFAULT -1,
+    topic TEXT,
+    created_at TEXT,
+    active_at TEXT,
+    archived_at TEXT,
+    locked INTEGER DEFAULT 0,
+    sticky INTEGER DEFAULT 0,
+    PRIMARY KEY (board, id)
+);
+`, `
+CREATE TABLE post (
+    board TEXT NOT NULL,
+    id INTEGER NOT NULL,
+    thread_id INTEGER NOT NULL,
+    author TEXT,
+    tripcode TEXT,
+    author_ip TEXT,
+    content TEXT NOT NULL,
+    created_at TEXT,
+    deleted_at TEXT,
+    PRIMARY KEY (board, id),
+    FOREIGN KEY(board, thread_id) REFERENCES thread(board, id) NOT DEFERRABLE
+);
+`, `
+CREATE INDEX post_by_thread_id ON post(board, thread_id);
+`, `
+CREATE INDEX post_by_author_ip ON post(author_ip);
+`, `
+-- Posts copied from another database keep their timestamps
+CREATE TRIGGER update_thread_timestamp
+AFTER INSERT ON post
+FOR EACH ROW WHEN NEW.created_at IS NULL
+BEGIN
+UPDATE post SET
+created_at = strftime('%Y-%m-%dT%H:%M:%SZ', 'now')
+    WHERE rowid = NEW.rowid;
+
+UPDATE thread
+SET num_replies = num_replies + 1,
+    op_id = coalesce(op_id, NEW.id),
+    created_at = coalesce(created_at, (SELECT created_at FROM post WHERE rowid = NEW.rowid)),
+    active_at = max(coalesce(active_at, '1970-01-01T00:00:00'),
+                     (SELECT created_at FROM post WHERE rowid = NEW.rowid))
+WHERE board = NEW.board AND id = NEW.thread_id;
+END;
+`)},
+}
+
+var serverMigrations = []migration{
+	{"create ban table", statements(`
+CREATE TABLE IF NOT EXISTS ban (
+    id INTEGER PRIMARY KEY,
+    target TEXT NOT NULL,
+    -- NULL for bans on all boards
+    board TEXT,
+    reason TEXT,
+    created_at TEXT DEFAULT (strftime('%Y-%m-%dT%H:%M:%SZ', 'now')),
+    -- NULL for permanent bans
+    expires_at TEXT,
+    lifted_at TEXT
+);
+`)},
+}
+
+func schemaVersion(db execer) (int, error) {
+	var version int
+	err := db.QueryRow("PRAGMA user_version;").Scan(&version)
+	return version, errors.Wrap(err, "failed to determine schema version")
+}
+
+// migrate applies all migrations not yet applied to the database, each in its
+// own transaction.
+func migrate(db *sql.DB, migrations []migration) error {
+	version, err := schemaVersion(db)
+	if err != nil {
+		return err
+	}
+	if version > len(migrations) {
+		return errors.Errorf("schema version %d is newer than the supported version %d",
+			version, len(migrations))
+	}
+
+	for i := version; i < len(migrations); i++ {
+		m := migrations[i]
+		tx, err := db.Begin()
+		if err != nil {
+			return err
+		}
+		if err = m.apply(tx); err == nil {
+			// Pragmas do not accept bound parameters
+			_, err = tx.Exec(fmt.Sprintf("PRAGMA user_version = %d;", i+1))
+		}
+		if err != nil {
+			tx.Rollback()
+			return errors.Wrapf(err, "migration %d (%s) failed", i+1, m.descr)
+		}
+		if err = tx.Commit(); err != nil {
+			return errors.Wrapf(err, "migration %d (%s) failed", i+1, m.descr)
+		}
+	}
+	return nil
+}
+
+// SchemaStatus describes the schema version of a database and the migrations
+// yet to be applied to it.
+type SchemaStatus struct {
+	Path    string
+	Exists  bool
+	Version int
+	Pending []string
+}
+
+// CheckSchemas determines the schema status of all configured databases
+// without changing them.
+func CheckSchemas(conf *config.Settings) ([]SchemaStatus, error) {
+	switch conf.Database.Driver {
+	case config.Postgres:
+		return checkPostgresSchemas(conf)
+	case config.Memory:
+		return nil, nil
+	}
+	statuses := make([]SchemaStatus, 0, len(conf.Boards)+1)
+
+	st, err := checkSchema(conf.ServerDatabase(), serverMigrations)
+	if err != nil {
+		return statuses, err
+	}
+	statuses = append(statuses, st)
+
+	if conf.Database.SingleFile() {
+		st, err := checkSchema(conf.SingleDatabase(), singleMigrations)
+		return append(statuses, st), err
+	}
+	for _, b := range conf.Boards {
+		if b.Ephemeral {
+			continue
+		}
+		path := filepath.Join(conf.BoardsDirectory(), b.Name+".db")
+		st, err := checkSchema(path, boardMigrations(b.Name))
+		if err != nil {
+			return statuses, err
+		}
+		statuses = append(statuses, st)
+	}
+	return statuses, nil
+}
+
+func checkSchema(path string, migrations []migration) (SchemaStatus, error) {
+	st := SchemaStatus{Path: path}
+	var err error
+	if st.Exists, err = util.FileExists(path); err != nil {
+		return st, errors.Wrapf(err, "failed to look for database %s", path)
+	}
+
+	// Opening a missing file would create it
+	if st.Exists {
+		db, err := sql.Open("sqlite3", path)
+		if err != nil {
+			return st, errors.Wrapf(err, "failed to connect to file %s", path)
+		}
+		defer db.Close()
+
+		if st.Version, err = schemaVersion(db); err != nil {
+			return st, errors.Wrapf(err, "failed to check %s", path)
+		}
+		if st.Version > len(migrations) {
+			return st, errors.Errorf("%s: schema version %d is newer than the supported version %d",
+				path, st.Version, len(migrations))
+		}
+	}
+
+	for i := st.Version; i < len(migrations); i++ {
+		st.Pending = append(st.Pending, fmt.Sprintf("%d: %s", i+1, migrations[i].descr))
+	}
+	return st, nil
+}
+
+// OpenReadOnly opens the existing board databases for reading, without
+// creating, migrating or otherwise changing them. Missing boards are treated
+// as non-existing. With SQLite, bans and search are unavailable.
+func OpenReadOnly(conf *config.Settings) (DB, error) {
+	switch conf.Database.Driver {
+	case config.Postgres:
+		return openPostgresReadOnly(conf)
+	case config.Memory:
+		// Nothing persisted to be read
+		m := newMemory(conf, false)
+		return m, m.Init()
+	}
+	s := &sqlite{conf: conf, boardsDirectory: conf.BoardsDirectory(), boardDBs: make(map[string]*cachedDB)}
+	if conf.Database.SingleFile() {
+		path := conf.SingleDatabase()
+		db, err := openReadOnly(path, singleMigrations)
+		if err != nil || db == nil {
+			return s, err
+		}
+		s.single = db
+		for _, b := range conf.Boards {
+			if !b.Ephemeral {
+				s.boardDBs[b.Name] = db
+			}
+		}
+		return s, nil
+	}
+	for _, b := range conf.Boards {
+		if b.Ephemeral {
+			continue
+		}
+		path := filepath.Join(s.boardsDirectory, b.Name+".db")
+		db, err := openReadOnly(path, boardMigrations(b.Name))
+		if err != nil {
+			s.Close()
+			return nil, err
+		} else if db != nil {
+			s.boardDBs[b.Name] = db
+		}
+	}
+	return s, nil
+}
+
+// openReadOnly opens an existing database for reading, which has to be up to
+// date. A missing database gives nil.
+func openReadOnly(path string, migrations []migration) (*cachedDB, error) {
+	if exists, err := util.FileExists(path); err != nil {
+		return nil, errors.Wrapf(err, "failed to look for database %s", path)
+	} else if !exists {
+		return nil, nil
+	}
+
+	db, err := sql.Open("sqlite3", "file:"+path+"?mode=ro")
+	if err != nil {
+		return nil, errors.Wrapf(err, "failed to connect to file %s", path)
+	}
+
+	if version, err := schemaVersion(db); err != nil {
+		db.Close()
+		return nil, errors.Wrapf(err, "failed to check %s", path)
+	} else if version != len(migrations) {
+		db.Close()
+		return nil, errors.Errorf("%s has schema version %d instead of %d, run migrate first",
+			path, version, len(migrations))
+	}
+	return newCachedDB(db), nil
+}
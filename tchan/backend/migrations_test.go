@@ -0,0 +1,106 @@
+package backend
+
+import (
+	"database/sql"
+	"io/ioutil"
+	"path/filepath"
+	"reflect"
+	"sort"
+	"testing"
+
+	_ "github.com/mattn/go-sqlite3"
+)
+
+func openTestDB(t *testing.T) *sql.DB {
+	db, err := sql.Open("sqlite3", filepath.Join(t.TempDir(), "test.db"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { db.Close() })
+	return db
+}
+
+func schemaObjects(t *testing.T, db *sql.DB) []string {
+	rows, err := db.Query(`
+SELECT m.type || ' ' || m.name || coalesce('.' || c.name, '')
+FROM sqlite_master m LEFT JOIN pragma_table_info(m.name) c
+WHERE m.name NOT LIKE 'sqlite_%';
+`)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer rows.Close()
+
+	objects := []string{}
+	for rows.Next() {
+		var o string
+		if err := rows.Scan(&o); err != nil {
+			t.Fatal(err)
+		}
+		objects = append(objects, o)
+	}
+	sort.Strings(objects)
+	return objects
+}
+
+func TestMigrateFixtures(t *testing.T) {
+	migrations := boardMigrations("b")
+	fresh := openTestDB(t)
+	if err := migrate(fresh, migrations); err != nil {
+		t.Fatal(err)
+	}
+	expected := schemaObjects(t, fresh)
+
+	fixtures, err := filepath.Glob(filepath.Join("testdata", "board_*.sql"))
+	if err != nil || len(fixtures) == 0 {
+		t.Fatalf("no fixtures found: %v", err)
+	}
+	for _, fixture := range fixtures {
+		t.Run(filepath.Base(fixture), func(t *testing.T) {
+			db := openTestDB(t)
+			schema, err := ioutil.ReadFile(fixture)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if _, err := db.Exec(string(schema)); err != nil {
+				t.Fatal(err)
+			}
+
+			// Repeated migration must have no effect
+			for i := 0; i < 2; i++ {
+				if err := migrate(db, migrations); err != nil {
+					t.Fatal(err)
+				}
+			}
+
+			if version, _ := schemaVersion(db); version != len(migrations) {
+				t.Errorf("expected version %d, got %d", len(migrations), version)
+			}
+			if objects := schemaObjects(t, db); !reflect.DeepEqual(objects, expected) {
+				t.Errorf("expected schema %v, got %v", expected, objects)
+			}
+
+			var numPosts, numReplies int
+			err = db.QueryRow(`
+SELECT count(*), (SELECT num_replies FROM thread WHERE id = 1) FROM post
+WHERE board = 'b' AND deleted_at IS NULL AND tripcode IS NULL;
+`).Scan(&numPosts, &numReplies)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if numPosts != 2 || numReplies != 1 {
+				t.Errorf("expected 2 posts and 1 reply to be kept, got %d and %d", numPosts, numReplies)
+			}
+		})
+	}
+}
+
+func TestMigrateNewerSchema(t *testing.T) {
+	db := openTestDB(t)
+	if _, err := db.Exec("PRAGMA user_version = 1000;"); err != nil {
+		t.Fatal(err)
+	}
+	if err := migrate(db, boardMigrations("b")); err == nil {
+		t.Error("expected migration of newer schema to fail")
+	}
+}
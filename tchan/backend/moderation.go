@@ -0,0 +1,88 @@
+package backend
+
+import (
+	"context"
+
+	"github.com/pkg/errors"
+)
+
+func (s *sqlite) DeletePost(ctx context.Context, boardName string, postID int64, ok *bool) error {
+	boardDB, boardOK := s.boardDBs[boardName]
+	if !boardOK {
+		return errors.Errorf("attempting to delete post on non-existing board /%s/", boardName)
+	}
+
+	result, err := boardDB.ExecContext(ctx, `
+UPDATE post SET deleted_at = strftime('%Y-%m-%dT%H:%M:%SZ', 'now')
+WHERE board = ? AND id = ? AND deleted_at IS NULL;
+`, boardName, postID)
+	if err != nil {
+		return err
+	}
+
+	n, err := result.RowsAffected()
+	if err != nil {
+		return err
+	}
+	if n == 0 {
+		// Either missing or already deleted
+		_, *ok, err = getThreadID(ctx, boardDB, boardName, postID)
+		return err
+	}
+	*ok = true
+	return nil
+}
+
+func (s *sqlite) SetLocked(ctx context.Context, boardName string, postID int64, locked bool, ok *bool) error {
+	return s.setThreadFlag(ctx, boardName, postID, "locked", locked, ok)
+}
+
+func (s *sqlite) SetSticky(ctx context.Context, boardName string, postID int64, sticky bool, ok *bool) error {
+	return s.setThreadFlag(ctx, boardName, postID, "sticky", sticky, ok)
+}
+
+// setThreadFlag sets a boolean column in the thread table. The column name is
+// never user-supplied.
+func (s *sqlite) setThreadFlag(ctx context.Context, boardName string, postID int64, column string, value bool, ok *bool) error {
+	boardDB, boardOK := s.boardDBs[boardName]
+	if !boardOK {
+		return errors.Errorf("attempting to moderate thread on non-existing board /%s/", boardName)
+	}
+
+	threadID, idOK, err := getThreadID(ctx, boardDB, boardName, postID)
+	if err != nil {
+		return err
+	}
+	*ok = idOK
+	if !idOK {
+		return nil
+	}
+
+	if _, err = boardDB.ExecContext(ctx, `UPDATE thread SET `+column+` = ? WHERE board = ? AND id = ?;`, value, boardName, threadID); err != nil {
+		return errors.Wrapf(err, "failed to update thread(%s)", column)
+	}
+
+	// Unsticking a thread might push it off the board
+	return s.archiveThreads(ctx, boardName, boardDB)
+}
+
+// ModerationActions lists the actions understood by Moderate.
+var ModerationActions = []string{"delete", "lock", "unlock", "sticky", "unsticky"}
+
+// Moderate applies a moderation action to a post or the thread containing it.
+func Moderate(ctx context.Context, db DB, action string, boardName string, postID int64, ok *bool) error {
+	switch action {
+	case "delete":
+		return db.DeletePost(ctx, boardName, postID, ok)
+	case "lock":
+		return db.SetLocked(ctx, boardName, postID, true, ok)
+	case "unlock":
+		return db.SetLocked(ctx, boardName, postID, false, ok)
+	case "sticky":
+		return db.SetSticky(ctx, boardName, postID, true, ok)
+	case "unsticky":
+		return db.SetSticky(ctx, boardName, postID, false, ok)
+	default:
+		return errors.Wrap(ErrUnknownAction, action)
+	}
+}
@@ -0,0 +1,948 @@
+package backend
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+	"net/url"
+	"strings"
+	"time"
+
+	// PostgreSQL bindings
+	"github.com/lib/pq"
+	"github.com/pkg/errors"
+
+	"github.com/fgahr/termchan/tchan"
+	"github.com/fgahr/termchan/tchan/config"
+)
+
+// postgres keeps all boards in a single PostgreSQL database, each board in its
+// own schema. Post IDs are hence counted per board as with SQLite. Data which
+// is not specific to a board lives in the termchan schema.
+type postgres struct {
+	conf *config.Settings
+	db   *sql.DB
+	// Schema names of the configured boards
+	schemas map[string]string
+}
+
+// boardSchema gives the name of the schema holding a board's tables.
+func boardSchema(boardName string) string {
+	return "board_" + boardName
+}
+
+func (p *postgres) Init() error {
+	db, err := sql.Open("postgres", p.conf.Database.DSN)
+	if err != nil {
+		return errors.Wrap(err, "failed to connect to postgres")
+	}
+	setPoolLimits(db, p.conf.Database)
+	p.db = db
+
+	if err = pgMigrate(db, "termchan", pgServerMigrations); err != nil {
+		return errors.Wrap(err, "server schema setup failed")
+	}
+
+	p.schemas = nil
+	return p.initBoards()
+}
+
+// initBoards sets up the schemas of boards not set up before.
+func (p *postgres) initBoards() error {
+	schemas := make(map[string]string)
+	for _, board := range p.conf.Boards {
+		if board.Ephemeral {
+			continue
+		}
+		schema := boardSchema(board.Name)
+		if _, known := p.schemas[board.Name]; !known {
+			if err := pgMigrate(p.db, schema, pgBoardMigrations(schema)); err != nil {
+				return errors.Wrapf(err, "schema setup for /%s/ failed", board.Name)
+			}
+		}
+		schemas[board.Name] = schema
+		if err := pgArchiveThreads(context.Background(), p.db, schema, board); err != nil {
+			return errors.Wrapf(err, "archiving threads on /%s/ failed", board.Name)
+		}
+	}
+
+	p.schemas = schemas
+	return nil
+}
+
+// Refresh sets up newly configured boards and forgets removed ones, whose
+// schemas are kept. The connection is retained, hence a change of the
+// connection string requires a restart.
+func (p *postgres) Refresh() error {
+	return p.initBoards()
+}
+
+func (p *postgres) Close() error {
+	if p.db == nil {
+		return nil
+	}
+	return p.db.Close()
+}
+
+// pgMigrationLock is the advisory lock serializing migrations of termchan
+// processes sharing a database.
+const pgMigrationLock = 0x7463686e
+
+// pgMigrate brings a schema up to date. Unlike with SQLite, all pending
+// migrations are applied in a single transaction. The schema version is
+// tracked in termchan.schema_version.
+func pgMigrate(db *sql.DB, schema string, migrations []migration) error {
+	tx, err := db.Begin()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	if _, err = tx.Exec(`SELECT pg_advisory_xact_lock($1);`, pgMigrationLock); err != nil {
+		return errors.Wrap(err, "failed to acquire migration lock")
+	}
+	if err = statements(`
+CREATE SCHEMA IF NOT EXISTS termchan;
+`, `
+CREATE TABLE IF NOT EXISTS termchan.schema_version (
+    schema_name TEXT PRIMARY KEY,
+    version INTEGER NOT NULL
+);
+`)(tx); err != nil {
+		return errors.Wrap(err, "failed to set up schema versioning")
+	}
+
+	version, err := pgSchemaVersion(tx, schema)
+	if err != nil {
+		return errors.Wrap(err, "failed to read schema version")
+	}
+	if version > len(migrations) {
+		return errors.Errorf("schema version %d is newer than the supported version %d", version, len(migrations))
+	}
+	if version == len(migrations) {
+		return nil
+	}
+
+	for i := version; i < len(migrations); i++ {
+		if err = migrations[i].apply(tx); err != nil {
+			return errors.Wrapf(err, "migration %d (%s) failed", i+1, migrations[i].descr)
+		}
+	}
+
+	_, err = tx.Exec(`
+INSERT INTO termchan.schema_version (schema_name, version) VALUES ($1, $2)
+ON CONFLICT (schema_name) DO UPDATE SET version = EXCLUDED.version;
+`, schema, len(migrations))
+	if err != nil {
+		return errors.Wrap(err, "failed to update schema version")
+	}
+	return tx.Commit()
+}
+
+func pgSchemaVersion(db execer, schema string) (int, error) {
+	var version int
+	err := db.QueryRow(`
+SELECT coalesce((SELECT version FROM termchan.schema_version WHERE schema_name = $1), 0);
+`, schema).Scan(&version)
+	return version, err
+}
+
+// pgStatements is like statements, substituting the quoted schema name for
+// %[1]s.
+func pgStatements(schema string, stmts ...string) func(execer) error {
+	for i, stmt := range stmts {
+		stmts[i] = inSchema(schema, stmt)
+	}
+	return statements(stmts...)
+}
+
+var pgServerMigrations = []migration{
+	{"create ban table", statements(`
+CREATE TABLE termchan.ban (
+    id BIGSERIAL PRIMARY KEY,
+    target TEXT NOT NULL,
+    -- NULL for bans on all boards
+    board TEXT,
+    reason TEXT,
+    created_at TIMESTAMPTZ NOT NULL DEFAULT now(),
+    -- NULL for permanent bans
+    expires_at TIMESTAMPTZ,
+    lifted_at TIMESTAMPTZ
+);
+`, `
+-- Timestamps are served in the same format as stored by SQLite
+CREATE FUNCTION termchan.rfc3339(ts TIMESTAMPTZ) RETURNS TEXT AS $$
+SELECT to_char(ts AT TIME ZONE 'UTC', 'YYYY-MM-DD"T"HH24:MI:SS"Z"');
+$$ LANGUAGE SQL IMMUTABLE;
+`)},
+}
+
+// pgBoardMigrations gives the migrations for a board's schema. The triggers
+// mirror those of the SQLite schema, including the search index.
+func pgBoardMigrations(schema string) []migration {
+	return []migration{
+		{"create thread and post tables", pgStatements(schema, `
+CREATE SCHEMA IF NOT EXISTS %[1]s;
+`, `
+CREATE TABLE %[1]s.thread (
+    id BIGSERIAL PRIMARY KEY,
+    op_id BIGINT,
+    num_replies INTEGER NOT NULL DEFAULT -1,
+    topic TEXT,
+    created_at TIMESTAMPTZ,
+    active_at TIMESTAMPTZ,
+    archived_at TIMESTAMPTZ,
+    locked BOOLEAN NOT NULL DEFAULT FALSE,
+    sticky BOOLEAN NOT NULL DEFAULT FALSE
+);
+`, `
+CREATE TABLE %[1]s.post (
+    id BIGSERIAL PRIMARY KEY,
+    thread_id BIGINT NOT NULL REFERENCES %[1]s.thread(id),
+    author TEXT,
+    tripcode TEXT,
+    author_ip TEXT,
+    content TEXT NOT NULL,
+    created_at TIMESTAMPTZ NOT NULL DEFAULT now(),
+    deleted_at TIMESTAMPTZ,
+    -- NULL for deleted posts
+    search TSVECTOR
+);
+`, `
+CREATE INDEX post_by_thread_id ON %[1]s.post(thread_id);
+`, `
+CREATE INDEX post_by_author_ip ON %[1]s.post(author_ip);
+`, `
+CREATE INDEX post_search ON %[1]s.post USING gin(search);
+`, `
+CREATE FUNCTION %[1]s.update_thread_timestamp() RETURNS trigger AS $$
+BEGIN
+    UPDATE %[1]s.thread
+    SET num_replies = num_replies + 1,
+        op_id = coalesce(op_id, NEW.id),
+        created_at = coalesce(created_at, NEW.created_at),
+        -- greatest() ignores NULL
+        active_at = greatest(active_at, NEW.created_at)
+    WHERE id = NEW.thread_id;
+    RETURN NULL;
+END;
+$$ LANGUAGE plpgsql;
+`, `
+CREATE TRIGGER update_thread_timestamp
+AFTER INSERT ON %[1]s.post
+FOR EACH ROW EXECUTE PROCEDURE %[1]s.update_thread_timestamp();
+`, `
+CREATE FUNCTION %[1]s.index_post() RETURNS trigger AS $$
+BEGIN
+    IF NEW.deleted_at IS NOT NULL THEN
+        NEW.search = NULL;
+    ELSE
+        -- op_id may not have been set yet for a new thread
+        NEW.search = setweight(to_tsvector('simple', coalesce(
+            (SELECT topic FROM %[1]s.thread
+             WHERE id = NEW.thread_id AND coalesce(op_id, NEW.id) = NEW.id), '')), 'A')
+            || to_tsvector('simple', NEW.content);
+    END IF;
+    RETURN NEW;
+END;
+$$ LANGUAGE plpgsql;
+`, `
+CREATE TRIGGER index_post
+BEFORE INSERT OR UPDATE OF content, deleted_at ON %[1]s.post
+FOR EACH ROW EXECUTE PROCEDURE %[1]s.index_post();
+`, `
+CREATE FUNCTION %[1]s.reindex_topic() RETURNS trigger AS $$
+BEGIN
+    -- Picked up by index_post
+    UPDATE %[1]s.post SET content = content WHERE id = NEW.op_id;
+    RETURN NULL;
+END;
+$$ LANGUAGE plpgsql;
+`, `
+CREATE TRIGGER reindex_topic
+AFTER UPDATE OF topic ON %[1]s.thread
+FOR EACH ROW EXECUTE PROCEDURE %[1]s.reindex_topic();
+`)},
+	}
+}
+
+// pgArchiveThreads archives all threads which exceed the board's thread
+// length or have fallen off its last page.
+func pgArchiveThreads(ctx context.Context, db ctxExecer, schema string, bconf tchan.Board) error {
+	_, err := db.ExecContext(ctx, inSchema(schema, `
+UPDATE %[1]s.thread SET archived_at = now()
+WHERE archived_at IS NULL AND num_replies > -1
+AND (num_replies > $1 OR id NOT IN (
+    SELECT id FROM %[1]s.thread
+    WHERE archived_at IS NULL AND num_replies > -1 AND num_replies <= $1
+    ORDER BY sticky DESC, active_at DESC, id DESC
+    LIMIT $2
+));
+`), bconf.MaxThreadLength(), bconf.MaxThreads()*bconf.MaxPages())
+	return err
+}
+
+func (p *postgres) archiveThreads(ctx context.Context, boardName string, schema string, db ctxExecer) error {
+	bconf, confOK := p.conf.BoardConfig(boardName)
+	if !confOK {
+		return errors.Errorf("found schema but no config for /%s/", boardName)
+	}
+	return pgArchiveThreads(ctx, db, schema, bconf)
+}
+
+// inSchema substitutes the quoted schema name for %[1]s in a query.
+func inSchema(schema string, query string) string {
+	return fmt.Sprintf(query, pq.QuoteIdentifier(schema))
+}
+
+func (p *postgres) query(ctx context.Context, schema string, query string, args ...interface{}) (*sql.Rows, error) {
+	return p.db.QueryContext(ctx, inSchema(schema, query), args...)
+}
+
+func (p *postgres) queryRow(ctx context.Context, schema string, query string, args ...interface{}) *sql.Row {
+	return p.db.QueryRowContext(ctx, inSchema(schema, query), args...)
+}
+
+func (p *postgres) exec(ctx context.Context, schema string, query string, args ...interface{}) (sql.Result, error) {
+	return p.db.ExecContext(ctx, inSchema(schema, query), args...)
+}
+
+func (p *postgres) PopulateBoard(ctx context.Context, boardName string, page int, b *tchan.BoardOverview, ok *bool) error {
+	schema, boardOK := p.schemas[boardName]
+	if !boardOK {
+		*ok = false
+		return nil
+	}
+
+	bconf, confOK := p.conf.BoardConfig(boardName)
+	if !confOK {
+		return errors.Errorf("found schema but no config for /%s/", boardName)
+	}
+	*ok = true
+
+	var numThreads int
+	err := p.queryRow(ctx, schema, `
+SELECT count(*) FROM %[1]s.thread
+WHERE num_replies > -1 AND archived_at IS NULL;
+`).Scan(&numThreads)
+	if err != nil {
+		return errors.Wrap(err, "failed to count active threads")
+	}
+	if page < 1 {
+		page = 1
+	}
+	b.Page = page
+	b.NumPages = (numThreads + bconf.MaxThreads() - 1) / bconf.MaxThreads()
+
+	threadRows, err := p.query(ctx, schema, `
+SELECT t.topic, t.num_replies, termchan.rfc3339(t.created_at), termchan.rfc3339(t.active_at), t.locked, t.sticky,
+       op.id, op.author, coalesce(op.tripcode, ''), op.content, op.deleted_at IS NOT NULL
+FROM %[1]s.thread t INNER JOIN %[1]s.post op ON t.op_id = op.id
+AND t.num_replies > -1 AND t.archived_at IS NULL
+ORDER BY t.sticky DESC, t.active_at DESC, t.id DESC
+LIMIT $1 OFFSET $2;
+`, bconf.MaxThreads(), (page-1)*bconf.MaxThreads())
+	if err != nil {
+		return errors.Wrap(err, "failed to gather thread summaries")
+	}
+	defer threadRows.Close()
+
+	b.Threads = make([]tchan.ThreadSummary, 0)
+	for threadRows.Next() {
+		t := tchan.ThreadSummary{}
+		var createdTS, activeTS string
+		err = threadRows.Scan(&t.Topic, &t.NumReplies, &createdTS, &activeTS, &t.Locked, &t.Sticky,
+			&t.OP.ID, &t.OP.Author, &t.OP.Tripcode, &t.OP.Content, &t.OP.Deleted)
+		if err != nil {
+			return errors.Wrap(err, "failed to extract thread summary")
+		}
+		tombstone(&t.OP)
+
+		if t.OP.Timestamp, err = time.Parse(time.RFC3339, createdTS); err != nil {
+			return errors.Wrap(err, "malformed date string in thread table (created_at)")
+		}
+		if t.Active, err = time.Parse(time.RFC3339, activeTS); err != nil {
+			return errors.Wrap(err, "malformed date string in thread table (active_at)")
+		}
+		t.OP.ParseContent()
+		// Replies are only linked when viewing the thread
+		t.OP.QuotedBy = make([]int64, 0)
+
+		b.Threads = append(b.Threads, t)
+	}
+
+	return threadRows.Err()
+}
+
+func (p *postgres) PopulateCatalog(ctx context.Context, boardName string, c *tchan.Catalog, ok *bool) error {
+	schema, boardOK := p.schemas[boardName]
+	if !boardOK {
+		*ok = false
+		return nil
+	}
+	*ok = true
+
+	rows, err := p.query(ctx, schema, `
+SELECT op_id, topic, num_replies, termchan.rfc3339(active_at), locked, sticky FROM %[1]s.thread
+WHERE num_replies > -1 AND archived_at IS NULL
+ORDER BY sticky DESC, active_at DESC, id DESC;
+`)
+	if err != nil {
+		return errors.Wrap(err, "failed to gather catalog entries")
+	}
+	defer rows.Close()
+
+	return scanCatalog(rows, c)
+}
+
+func (p *postgres) PopulateArchive(ctx context.Context, boardName string, c *tchan.Catalog, ok *bool) error {
+	schema, boardOK := p.schemas[boardName]
+	if !boardOK {
+		*ok = false
+		return nil
+	}
+	*ok = true
+	c.Archived = true
+
+	rows, err := p.query(ctx, schema, `
+SELECT op_id, topic, num_replies, termchan.rfc3339(active_at), locked, sticky FROM %[1]s.thread
+WHERE num_replies > -1 AND archived_at IS NOT NULL
+ORDER BY archived_at DESC, id DESC;
+`)
+	if err != nil {
+		return errors.Wrap(err, "failed to gather archive entries")
+	}
+	defer rows.Close()
+
+	return scanCatalog(rows, c)
+}
+
+// pgPostColumns are the post columns expected by scanPosts.
+const pgPostColumns = `id, author, coalesce(tripcode, ''), termchan.rfc3339(created_at), content, deleted_at IS NOT NULL`
+
+func (p *postgres) getThreadInfo(ctx context.Context, schema string, postID int64) (int64, threadInfo, bool, error) {
+	var threadID int64
+	info := threadInfo{}
+	err := p.queryRow(ctx, schema, `
+SELECT t.id, coalesce(t.topic, ''), t.op_id, t.num_replies, t.archived_at IS NOT NULL, t.locked, t.sticky
+FROM %[1]s.post p INNER JOIN %[1]s.thread t ON p.thread_id = t.id
+WHERE p.id = $1;
+`, postID).Scan(&threadID, &info.topic, &info.opID, &info.numReplies,
+		&info.archived, &info.locked, &info.sticky)
+	if err == sql.ErrNoRows {
+		return threadID, info, false, nil
+	}
+	return threadID, info, err == nil, err
+}
+
+func (p *postgres) PopulateThread(ctx context.Context, boardName string, postID int64, pr PostRange, thr *tchan.Thread, ok *bool) error {
+	*ok = false
+
+	schema, boardOK := p.schemas[boardName]
+	if !boardOK {
+		return nil
+	}
+
+	threadID, info, idOK, err := p.getThreadInfo(ctx, schema, postID)
+	if err != nil || !idOK {
+		return err
+	}
+	thr.Topic = info.topic
+	thr.Archived = info.archived
+	thr.Locked = info.locked
+	thr.Sticky = info.sticky
+
+	*ok = true
+
+	opRows, err := p.query(ctx, schema, `
+SELECT `+pgPostColumns+` FROM %[1]s.post WHERE id = $1;
+`, info.opID)
+	if err != nil {
+		return err
+	}
+	defer opRows.Close()
+	if thr.Posts, err = scanPosts(opRows); err != nil {
+		return err
+	}
+
+	var rows *sql.Rows
+	switch {
+	case pr.Last > 0:
+		// Fetch in reverse to apply the limit, then restore the order
+		rows, err = p.query(ctx, schema, `
+SELECT * FROM (
+    SELECT `+pgPostColumns+` FROM %[1]s.post
+    WHERE thread_id = $1 AND id <> $2 AND id > $3
+    ORDER BY id DESC
+    LIMIT $4
+) AS latest ORDER BY id ASC;
+`, threadID, info.opID, pr.After, pr.Last)
+	case pr.Page > 0:
+		rows, err = p.query(ctx, schema, `
+SELECT `+pgPostColumns+` FROM %[1]s.post
+WHERE thread_id = $1 AND id <> $2 AND id > $3
+ORDER BY id ASC
+LIMIT $4 OFFSET $5;
+`, threadID, info.opID, pr.After, pr.PageSize, (pr.Page-1)*pr.PageSize)
+	default:
+		rows, err = p.query(ctx, schema, `
+SELECT `+pgPostColumns+` FROM %[1]s.post
+WHERE thread_id = $1 AND id <> $2 AND id > $3
+ORDER BY id ASC;
+`, threadID, info.opID, pr.After)
+	}
+	if err != nil {
+		return err
+	}
+	defer rows.Close()
+	replies, err := scanPosts(rows)
+	if err != nil {
+		return err
+	}
+
+	if len(replies) > 0 {
+		err = p.queryRow(ctx, schema, `
+SELECT count(*) FROM %[1]s.post WHERE thread_id = $1 AND id <> $2 AND id < $3;
+`, threadID, info.opID, replies[0].ID).Scan(&thr.OmittedBefore)
+		if err != nil {
+			return err
+		}
+	} else {
+		thr.OmittedBefore = info.numReplies
+	}
+	thr.OmittedAfter = info.numReplies - thr.OmittedBefore - len(replies)
+
+	thr.Posts = append(thr.Posts, replies...)
+	thr.LinkQuotes()
+
+	return nil
+}
+
+func (p *postgres) PopulatePosts(ctx context.Context, boardName string, posts *[]tchan.Post, ok *bool) error {
+	schema, boardOK := p.schemas[boardName]
+	if !boardOK {
+		*ok = false
+		return nil
+	}
+	*ok = true
+
+	rows, err := p.query(ctx, schema, `
+SELECT `+pgPostColumns+` FROM %[1]s.post
+WHERE deleted_at IS NULL
+ORDER BY id ASC;
+`)
+	if err != nil {
+		return errors.Wrapf(err, "failed to gather posts for /%s/", boardName)
+	}
+	defer rows.Close()
+
+	*posts, err = scanPosts(rows)
+	return errors.Wrapf(err, "failed to extract posts for /%s/", boardName)
+}
+
+// pgHeadlineOptions make ts_headline mark matches like SQLite's snippet().
+var pgHeadlineOptions = fmt.Sprintf(`StartSel="%s", StopSel="%s", MaxWords=16, MinWords=8`,
+	tchan.SnippetMatchStart, tchan.SnippetMatchEnd)
+
+func (p *postgres) Search(ctx context.Context, boardName string, query string, limit int, results *[]tchan.SearchResult, ok *bool) error {
+	schema, boardOK := p.schemas[boardName]
+	if !boardOK {
+		*ok = false
+		return nil
+	}
+	*ok = true
+
+	*results = make([]tchan.SearchResult, 0)
+	if strings.TrimSpace(query) == "" {
+		return nil
+	}
+
+	// Ranks are negated to sort like SQLite's, best match first
+	rows, err := p.query(ctx, schema, `
+SELECT p.id, t.op_id, coalesce(t.topic, ''), p.author, coalesce(p.tripcode, ''), termchan.rfc3339(p.created_at),
+       ts_headline('simple', p.content, q, $2), -ts_rank(p.search, q)
+FROM %[1]s.post p
+INNER JOIN %[1]s.thread t ON t.id = p.thread_id,
+plainto_tsquery('simple', $1) q
+WHERE p.search @@ q
+ORDER BY 8 ASC, p.id DESC
+LIMIT $3;
+`, query, pgHeadlineOptions, limit)
+	if err != nil {
+		return errors.Wrapf(err, "failed to search /%s/", boardName)
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		r := tchan.SearchResult{Board: boardName}
+		var ts, snippet string
+		err := rows.Scan(&r.PostID, &r.ThreadID, &r.Topic, &r.Author, &r.Tripcode, &ts, &snippet, &r.Rank)
+		if err != nil {
+			return errors.Wrap(err, "failed to extract search result")
+		}
+		if r.Timestamp, err = time.Parse(time.RFC3339, ts); err != nil {
+			return errors.Wrap(err, "malformed date string in post table")
+		}
+		r.Snippet = tchan.ParseSnippet(snippet)
+		*results = append(*results, r)
+	}
+	return rows.Err()
+}
+
+func (p *postgres) CreateThread(ctx context.Context, boardName string, topic string, op *tchan.Post) error {
+	schema, boardOK := p.schemas[boardName]
+	if !boardOK {
+		return errors.Errorf("attempting to create thread on non-existing board /%s/", boardName)
+	}
+
+	// Without its OP, the thread would be left behind as an orphan
+	tx, err := p.db.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	var threadID int64
+	err = tx.QueryRowContext(ctx, inSchema(schema, `
+INSERT INTO %[1]s.thread (topic) VALUES ($1) RETURNING id;
+`), topic).Scan(&threadID)
+	if err != nil {
+		return err
+	}
+
+	err = tx.QueryRowContext(ctx, inSchema(schema, `
+INSERT INTO %[1]s.post (thread_id, author, tripcode, author_ip, content) VALUES ($1, $2, $3, $4, $5)
+RETURNING id;
+`), threadID, op.Author, op.Tripcode, op.AuthorIP, op.Content).Scan(&op.ID)
+	if err != nil {
+		return err
+	}
+
+	if err = p.archiveThreads(ctx, boardName, schema, tx); err != nil {
+		return err
+	}
+	return tx.Commit()
+}
+
+func (p *postgres) AddReply(ctx context.Context, boardName string, postID int64, post *tchan.Post, ok *bool) error {
+	schema, boardOK := p.schemas[boardName]
+	if !boardOK {
+		return errors.Errorf("attempting to add post on non-existing board /%s/", boardName)
+	}
+
+	tx, err := p.db.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	// The thread must not be locked or archived in the meantime
+	var threadID int64
+	var archived, locked bool
+	err = tx.QueryRowContext(ctx, inSchema(schema, `
+SELECT t.id, t.archived_at IS NOT NULL, t.locked
+FROM %[1]s.post p INNER JOIN %[1]s.thread t ON p.thread_id = t.id
+WHERE p.id = $1
+FOR UPDATE OF t;
+`), postID).Scan(&threadID, &archived, &locked)
+	if err == sql.ErrNoRows {
+		*ok = false
+		return nil
+	} else if err != nil {
+		return err
+	}
+	*ok = true
+
+	if archived {
+		return ErrThreadArchived
+	}
+	if locked {
+		return ErrThreadLocked
+	}
+
+	err = tx.QueryRowContext(ctx, inSchema(schema, `
+INSERT INTO %[1]s.post (thread_id, author, tripcode, author_ip, content) VALUES ($1, $2, $3, $4, $5)
+RETURNING id;
+`), threadID, post.Author, post.Tripcode, post.AuthorIP, post.Content).Scan(&post.ID)
+	if err != nil {
+		return err
+	}
+
+	if err = p.archiveThreads(ctx, boardName, schema, tx); err != nil {
+		return err
+	}
+	return tx.Commit()
+}
+
+func (p *postgres) DeletePost(ctx context.Context, boardName string, postID int64, ok *bool) error {
+	schema, boardOK := p.schemas[boardName]
+	if !boardOK {
+		return errors.Errorf("attempting to delete post on non-existing board /%s/", boardName)
+	}
+
+	// Deleting a deleted post succeeds, as with SQLite
+	result, err := p.exec(ctx, schema, `
+UPDATE %[1]s.post SET deleted_at = coalesce(deleted_at, now()) WHERE id = $1;
+`, postID)
+	if err != nil {
+		return err
+	}
+
+	n, err := result.RowsAffected()
+	*ok = n > 0
+	return err
+}
+
+func (p *postgres) SetLocked(ctx context.Context, boardName string, postID int64, locked bool, ok *bool) error {
+	return p.setThreadFlag(ctx, boardName, postID, "locked", locked, ok)
+}
+
+func (p *postgres) SetSticky(ctx context.Context, boardName string, postID int64, sticky bool, ok *bool) error {
+	return p.setThreadFlag(ctx, boardName, postID, "sticky", sticky, ok)
+}
+
+// setThreadFlag sets a boolean column in the thread table. The column name is
+// never user-supplied.
+func (p *postgres) setThreadFlag(ctx context.Context, boardName string, postID int64, column string, value bool, ok *bool) error {
+	schema, boardOK := p.schemas[boardName]
+	if !boardOK {
+		return errors.Errorf("attempting to moderate thread on non-existing board /%s/", boardName)
+	}
+
+	result, err := p.exec(ctx, schema, `
+UPDATE %[1]s.thread SET `+column+` = $1
+WHERE id = (SELECT thread_id FROM %[1]s.post WHERE id = $2);
+`, value, postID)
+	if err != nil {
+		return errors.Wrapf(err, "failed to update thread(%s)", column)
+	}
+	n, err := result.RowsAffected()
+	if *ok = n > 0; err != nil || !*ok {
+		return err
+	}
+
+	// Unsticking a thread might push it off the board
+	return p.archiveThreads(ctx, boardName, schema, p.db)
+}
+
+func (p *postgres) PopulateActivity(ctx context.Context, boardName string, ip string, content string, since time.Time, a *Activity) error {
+	schema, boardOK := p.schemas[boardName]
+	if !boardOK {
+		return errors.Errorf("attempting to check activity on non-existing board /%s/", boardName)
+	}
+
+	var lastPost, lastThread sql.NullString
+	err := p.queryRow(ctx, schema, `
+SELECT termchan.rfc3339(max(created_at)) FROM %[1]s.post WHERE author_ip = $1;
+`, ip).Scan(&lastPost)
+	if err != nil {
+		return errors.Wrap(err, "failed to find latest post")
+	}
+
+	err = p.queryRow(ctx, schema, `
+SELECT termchan.rfc3339(max(p.created_at)) FROM %[1]s.post p INNER JOIN %[1]s.thread t ON t.op_id = p.id
+WHERE p.author_ip = $1;
+`, ip).Scan(&lastThread)
+	if err != nil {
+		return errors.Wrap(err, "failed to find latest thread")
+	}
+
+	if a.LastPost, err = parseOptionalTime(lastPost); err != nil {
+		return errors.Wrap(err, "malformed date string in post table (created_at)")
+	}
+	if a.LastThread, err = parseOptionalTime(lastThread); err != nil {
+		return errors.Wrap(err, "malformed date string in post table (created_at)")
+	}
+
+	err = p.queryRow(ctx, schema, `
+SELECT count(*) > 0 FROM %[1]s.post
+WHERE author_ip = $1 AND content = $2 AND created_at >= $3;
+`, ip, content, since).Scan(&a.Duplicate)
+	if err != nil {
+		return errors.Wrap(err, "failed to check for duplicate posts")
+	}
+
+	return nil
+}
+
+func (p *postgres) AddBan(ctx context.Context, ban *tchan.Ban) error {
+	var board interface{}
+	if ban.Board != "" {
+		board = ban.Board
+	}
+
+	err := p.db.QueryRowContext(ctx, `
+INSERT INTO termchan.ban (target, board, reason, expires_at) VALUES ($1, $2, $3, $4)
+RETURNING id;
+`, ban.Target, board, ban.Reason, ban.Expires).Scan(&ban.ID)
+	return errors.Wrap(err, "failed to persist ban")
+}
+
+func (p *postgres) LiftBan(ctx context.Context, banID int64, ok *bool) error {
+	result, err := p.db.ExecContext(ctx, `
+UPDATE termchan.ban SET lifted_at = now()
+WHERE id = $1 AND lifted_at IS NULL;
+`, banID)
+	if err != nil {
+		return errors.Wrap(err, "failed to lift ban")
+	}
+
+	n, err := result.RowsAffected()
+	*ok = n > 0
+	return err
+}
+
+// pgBanColumns are the ban columns expected by scanBans.
+const pgBanColumns = `id, target, coalesce(board, ''), coalesce(reason, ''), termchan.rfc3339(created_at), termchan.rfc3339(expires_at)`
+
+func (p *postgres) ListBans(ctx context.Context, includeExpired bool, bans *[]tchan.Ban) error {
+	rows, err := p.db.QueryContext(ctx, `
+SELECT `+pgBanColumns+`
+FROM termchan.ban
+WHERE $1 OR (lifted_at IS NULL AND (expires_at IS NULL OR expires_at > now()))
+ORDER BY id ASC;
+`, includeExpired)
+	if err != nil {
+		return errors.Wrap(err, "failed to gather bans")
+	}
+	defer rows.Close()
+
+	*bans, err = scanBans(rows)
+	return err
+}
+
+func (p *postgres) FindBan(ctx context.Context, boardName string, ip string, ban *tchan.Ban, ok *bool) error {
+	*ok = false
+	// Postgres could match address ranges itself but the stored targets are
+	// not guaranteed to be valid inet values.
+	rows, err := p.db.QueryContext(ctx, `
+SELECT `+pgBanColumns+`
+FROM termchan.ban
+WHERE lifted_at IS NULL AND (board IS NULL OR board = $1)
+AND (expires_at IS NULL OR expires_at > now())
+ORDER BY expires_at DESC NULLS FIRST;
+`, boardName)
+	if err != nil {
+		return errors.Wrap(err, "failed to gather bans")
+	}
+	defer rows.Close()
+
+	bans, err := scanBans(rows)
+	if err != nil {
+		return err
+	}
+
+	// Ordered such that the longest lasting ban is found first
+	for _, b := range bans {
+		if b.Matches(ip) {
+			*ban = b
+			*ok = true
+			return nil
+		}
+	}
+	return nil
+}
+
+// pgReadOnlyDSN amends a connection string such that all transactions are
+// read-only, for both the URL and the key/value format.
+func pgReadOnlyDSN(dsn string) (string, error) {
+	if strings.HasPrefix(dsn, "postgres://") || strings.HasPrefix(dsn, "postgresql://") {
+		u, err := url.Parse(dsn)
+		if err != nil {
+			return "", err
+		}
+		q := u.Query()
+		q.Set("default_transaction_read_only", "on")
+		u.RawQuery = q.Encode()
+		return u.String(), nil
+	}
+	return dsn + " default_transaction_read_only=on", nil
+}
+
+// openPostgresReadOnly connects to the configured boards without creating or
+// migrating their schemas.
+func openPostgresReadOnly(conf *config.Settings) (DB, error) {
+	dsn, err := pgReadOnlyDSN(conf.Database.DSN)
+	if err != nil {
+		return nil, errors.Wrap(err, "invalid connection string")
+	}
+	db, err := sql.Open("postgres", dsn)
+	if err != nil {
+		return nil, errors.Wrap(err, "failed to connect to postgres")
+	}
+	p := &postgres{conf: conf, db: db, schemas: make(map[string]string)}
+
+	for _, b := range conf.Boards {
+		if b.Ephemeral {
+			continue
+		}
+		schema := boardSchema(b.Name)
+		version, err := pgSchemaVersion(db, schema)
+		if err != nil {
+			db.Close()
+			return nil, errors.Wrapf(err, "failed to check schema for /%s/", b.Name)
+		}
+		if version == 0 {
+			// Missing boards are treated as non-existing
+			continue
+		}
+		if n := len(pgBoardMigrations(schema)); version != n {
+			db.Close()
+			return nil, errors.Errorf("schema for /%s/ has version %d instead of %d, run migrate first",
+				b.Name, version, n)
+		}
+		p.schemas[b.Name] = schema
+	}
+	return p, nil
+}
+
+// checkPostgresSchemas gives the schema status of all configured boards.
+func checkPostgresSchemas(conf *config.Settings) ([]SchemaStatus, error) {
+	db, err := sql.Open("postgres", conf.Database.DSN)
+	if err != nil {
+		return nil, errors.Wrap(err, "failed to connect to postgres")
+	}
+	defer db.Close()
+
+	var versioned bool
+	err = db.QueryRow(`SELECT to_regclass('termchan.schema_version') IS NOT NULL;`).Scan(&versioned)
+	if err != nil {
+		return nil, errors.Wrap(err, "failed to look for schema versions")
+	}
+
+	check := func(schema string, migrations []migration) (SchemaStatus, error) {
+		st := SchemaStatus{Path: "postgres:" + schema}
+		if versioned {
+			if st.Version, err = pgSchemaVersion(db, schema); err != nil {
+				return st, errors.Wrapf(err, "failed to check %s", schema)
+			}
+		}
+		st.Exists = st.Version > 0
+		if st.Version > len(migrations) {
+			return st, errors.Errorf("%s: schema version %d is newer than the supported version %d",
+				schema, st.Version, len(migrations))
+		}
+		for i := st.Version; i < len(migrations); i++ {
+			st.Pending = append(st.Pending, fmt.Sprintf("%d: %s", i+1, migrations[i].descr))
+		}
+		return st, nil
+	}
+
+	statuses := make([]SchemaStatus, 0, len(conf.Boards)+1)
+	st, err := check("termchan", pgServerMigrations)
+	if err != nil {
+		return nil, err
+	}
+	statuses = append(statuses, st)
+	for _, b := range conf.Boards {
+		if b.Ephemeral {
+			continue
+		}
+		schema := boardSchema(b.Name)
+		if st, err = check(schema, pgBoardMigrations(schema)); err != nil {
+			return nil, err
+		}
+		statuses = append(statuses, st)
+	}
+	return statuses, nil
+}
@@ -0,0 +1,243 @@
+package backend
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"sync"
+	"testing"
+
+	"github.com/pkg/errors"
+
+	"github.com/fgahr/termchan/tchan"
+	"github.com/fgahr/termchan/tchan/config"
+)
+
+// Postgres tests run against the database given by TERMCHAN_TEST_POSTGRES,
+// which is wiped before each test. Without it, a temporary cluster is started
+// if initdb and pg_ctl are available. Otherwise, the tests are skipped.
+var testPostgres struct {
+	once sync.Once
+	dsn  string
+	// Directory of the temporary cluster, if any
+	dir string
+	err error
+}
+
+func TestMain(m *testing.M) {
+	code := m.Run()
+	if testPostgres.dir != "" {
+		data := filepath.Join(testPostgres.dir, "data")
+		exec.Command("pg_ctl", "-D", data, "-m", "immediate", "stop").Run()
+		os.RemoveAll(testPostgres.dir)
+	}
+	os.Exit(code)
+}
+
+func startTestCluster() (string, string, error) {
+	for _, cmd := range []string{"initdb", "pg_ctl"} {
+		if _, err := exec.LookPath(cmd); err != nil {
+			return "", "", err
+		}
+	}
+	// The socket path must be short
+	dir, err := os.MkdirTemp("", "tchan-pg")
+	if err != nil {
+		return "", "", err
+	}
+	data := filepath.Join(dir, "data")
+	out, err := exec.Command("initdb", "-D", data, "-U", "termchan", "--auth=trust").CombinedOutput()
+	if err != nil {
+		return dir, "", errors.Wrapf(err, "initdb: %s", out)
+	}
+	out, err = exec.Command("pg_ctl", "-D", data, "-w", "-l", filepath.Join(dir, "log"),
+		"-o", fmt.Sprintf("-k %s -c listen_addresses=''", dir), "start").CombinedOutput()
+	if err != nil {
+		return dir, "", errors.Wrapf(err, "pg_ctl: %s", out)
+	}
+	return dir, fmt.Sprintf("host=%s user=termchan dbname=postgres sslmode=disable", dir), nil
+}
+
+func testPostgresDSN(t *testing.T) string {
+	testPostgres.once.Do(func() {
+		if dsn := os.Getenv("TERMCHAN_TEST_POSTGRES"); dsn != "" {
+			testPostgres.dsn = dsn
+			return
+		}
+		testPostgres.dir, testPostgres.dsn, testPostgres.err = startTestCluster()
+	})
+	if testPostgres.dsn == "" {
+		t.Skipf("no postgres available, set TERMCHAN_TEST_POSTGRES: %v", testPostgres.err)
+	}
+	return testPostgres.dsn
+}
+
+// resetTestPostgres drops all termchan data from the test database, returning
+// its DSN.
+func resetTestPostgres(t *testing.T) string {
+	dsn := testPostgresDSN(t)
+	db, err := sql.Open("postgres", dsn)
+	if err != nil {
+		t.Fatal(err)
+	}
+	_, err = db.Exec(`
+DO $$
+DECLARE s TEXT;
+BEGIN
+    FOR s IN SELECT nspname FROM pg_namespace WHERE nspname = 'termchan' OR nspname LIKE 'board\_%' LOOP
+        EXECUTE 'DROP SCHEMA ' || quote_ident(s) || ' CASCADE';
+    END LOOP;
+END
+$$;
+`)
+	db.Close()
+	if err != nil {
+		t.Fatal(err)
+	}
+	return dsn
+}
+
+// newTestPostgres sets up a backend on an empty database for the given boards,
+// which have to be sorted by name.
+func newTestPostgres(t *testing.T, boards ...tchan.Board) *postgres {
+	dsn := resetTestPostgres(t)
+	conf := config.Defaults()
+	conf.Database = config.Database{Driver: config.Postgres, DSN: dsn}
+	conf.Boards = boards
+
+	p := &postgres{conf: &conf}
+	if err := p.Init(); err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { p.Close() })
+	return p
+}
+
+func TestPostgresThreads(t *testing.T) {
+	ctx := context.Background()
+	db := newTestPostgres(t, tchan.Board{Name: "b", PageLength: 3}, tchan.Board{Name: "g"})
+	opID := createThread(t, db, "b", 10)
+	if opID != 1 {
+		t.Errorf("expected first post on /b/ to have ID 1, got %d", opID)
+	}
+	if id := createThread(t, db, "g", 0); id != 1 {
+		t.Errorf("expected post IDs to be counted per board, got %d on /g/", id)
+	}
+
+	thr := tchan.Thread{Board: tchan.Board{Name: "b"}}
+	ok := false
+	if err := db.PopulateThread(ctx, "b", 5, PostRange{Last: 3}, &thr, &ok); err != nil || !ok {
+		t.Fatalf("failed to fetch thread: %v", err)
+	}
+	if ids := fmt.Sprint(postIDs(thr.Posts)); ids != "[1 9 10 11]" || thr.OmittedBefore != 7 {
+		t.Errorf("expected posts [1 9 10 11] with 7 omitted, got %s with %d omitted", ids, thr.OmittedBefore)
+	}
+	if thr.Topic != "topic" || thr.Posts[0].Timestamp.IsZero() {
+		t.Errorf("expected thread data to be set, got %+v", thr)
+	}
+
+	bo := tchan.BoardOverview{}
+	if err := db.PopulateBoard(ctx, "b", 1, &bo, &ok); err != nil || !ok {
+		t.Fatalf("failed to fetch board: %v", err)
+	}
+	if len(bo.Threads) != 1 || bo.Threads[0].NumReplies != 10 || bo.Threads[0].Active.IsZero() {
+		t.Errorf("expected a single thread with 10 replies, got %+v", bo.Threads)
+	}
+
+	if err := db.SetLocked(ctx, "b", opID, true, &ok); err != nil || !ok {
+		t.Fatalf("failed to lock thread: %v", err)
+	}
+	reply := tchan.Post{Author: "Anonymous", Content: "late"}
+	if err := db.AddReply(ctx, "b", opID, &reply, &ok); errors.Cause(err) != ErrThreadLocked {
+		t.Errorf("expected reply to locked thread to fail, got %v", err)
+	}
+	if err := db.AddReply(ctx, "b", 100, &reply, &ok); err != nil || ok {
+		t.Errorf("expected reply to missing thread to be rejected, got %v", err)
+	}
+}
+
+func TestPostgresSearch(t *testing.T) {
+	ctx := context.Background()
+	db := newTestPostgres(t, tchan.Board{Name: "b"})
+	op := tchan.Post{Author: "Anonymous", Content: "first post"}
+	if err := db.CreateThread(ctx, "b", "gardening", &op); err != nil {
+		t.Fatal(err)
+	}
+	reply := tchan.Post{Author: "Anonymous", Content: "tomatoes need sun"}
+	ok := false
+	if err := db.AddReply(ctx, "b", op.ID, &reply, &ok); err != nil || !ok {
+		t.Fatalf("failed to reply: %v", err)
+	}
+
+	search := func(query string) string {
+		var results []tchan.SearchResult
+		if err := db.Search(ctx, "b", query, 10, &results, &ok); err != nil || !ok {
+			t.Fatalf("failed to search for %q: %v", query, err)
+		}
+		ids := make([]int64, 0, len(results))
+		for _, r := range results {
+			ids = append(ids, r.PostID)
+		}
+		return fmt.Sprint(ids)
+	}
+
+	if ids := search("tomatoes"); ids != "[2]" {
+		t.Errorf("expected reply to be found, got %s", ids)
+	}
+	if ids := search("gardening"); ids != "[1]" {
+		t.Errorf("expected OP to be found by topic, got %s", ids)
+	}
+	if err := db.DeletePost(ctx, "b", reply.ID, &ok); err != nil || !ok {
+		t.Fatalf("failed to delete post: %v", err)
+	}
+	if ids := search("tomatoes"); ids != "[]" {
+		t.Errorf("expected deleted post to be unindexed, got %s", ids)
+	}
+	if _, err := db.exec(ctx, "board_b", `UPDATE %[1]s.thread SET topic = 'cooking';`); err != nil {
+		t.Fatal(err)
+	}
+	if ids := search("cooking"); ids != "[1]" {
+		t.Errorf("expected new topic to be indexed, got %s", ids)
+	}
+}
+
+func TestPostgresBans(t *testing.T) {
+	ctx := context.Background()
+	db := newTestPostgres(t, tchan.Board{Name: "b"}, tchan.Board{Name: "g"})
+	ban := tchan.Ban{Target: "203.0.113.0/24", Board: "b", Reason: "spam"}
+	if err := db.AddBan(ctx, &ban); err != nil {
+		t.Fatal(err)
+	}
+
+	found := tchan.Ban{}
+	ok := false
+	if err := db.FindBan(ctx, "b", "203.0.113.9", &found, &ok); err != nil || !ok || found.Reason != "spam" {
+		t.Errorf("expected ban on /b/ to be found, got %+v (%v)", found, err)
+	}
+	if err := db.FindBan(ctx, "g", "203.0.113.9", &found, &ok); err != nil || ok {
+		t.Errorf("expected no ban on /g/, got %+v (%v)", found, err)
+	}
+
+	if err := db.LiftBan(ctx, ban.ID, &ok); err != nil || !ok {
+		t.Fatalf("failed to lift ban: %v", err)
+	}
+	var bans []tchan.Ban
+	if err := db.ListBans(ctx, false, &bans); err != nil || len(bans) != 0 {
+		t.Errorf("expected no bans in effect, got %d (%v)", len(bans), err)
+	}
+}
+
+func TestPgReadOnlyDSN(t *testing.T) {
+	cases := map[string]string{
+		"host=/run/postgresql dbname=termchan":             "host=/run/postgresql dbname=termchan default_transaction_read_only=on",
+		"postgres://tc@localhost/termchan?sslmode=disable": "postgres://tc@localhost/termchan?default_transaction_read_only=on&sslmode=disable",
+	}
+	for dsn, expected := range cases {
+		if ro, err := pgReadOnlyDSN(dsn); err != nil || ro != expected {
+			t.Errorf("expected %q, got %q (%v)", expected, ro, err)
+		}
+	}
+}
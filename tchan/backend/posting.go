@@ -0,0 +1,112 @@
+package backend
+
+import (
+	"context"
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/pkg/errors"
+
+	"github.com/fgahr/termchan/tchan"
+	"github.com/fgahr/termchan/tchan/config"
+	"github.com/fgahr/termchan/tchan/tripcode"
+)
+
+// FilterRejection signals that a post was rejected by one of the board's
+// filters. The filter is not part of the message so as not to reveal it.
+type FilterRejection struct {
+	Filter *tchan.Filter
+}
+
+func (r *FilterRejection) Error() string {
+	return "post rejected by content filter"
+}
+
+// PreparePost checks and filters the content and author of a post submitted
+// to a board. The author's address is left for the caller to set.
+func PreparePost(conf *config.Settings, boardName string, name string, content string) (tchan.Post, error) {
+	bc, ok := conf.BoardConfig(boardName)
+	if !ok {
+		return tchan.Post{}, errors.Errorf("no such board: /%s/", boardName)
+	}
+
+	// Trimming extraneous spaces avoids some kinds of abuse/trolling
+	content, rejectedBy := bc.FilterContent(strings.TrimSpace(content))
+	if rejectedBy != nil {
+		return tchan.Post{}, &FilterRejection{Filter: rejectedBy}
+	}
+	content = strings.TrimSpace(content)
+	if len(content) > bc.MaxPostBytes() {
+		return tchan.Post{}, errors.Errorf("post too large: %d bytes (max %d bytes)", len(content), bc.MaxPostBytes())
+	} else if content == "" {
+		return tchan.Post{}, errors.New("empty post content")
+	}
+
+	author, trip, err := tripcode.Split(name, conf.TripcodeSecret)
+	if err != nil {
+		return tchan.Post{}, err
+	}
+	// Only the name, passwords are never shown
+	author, rejectedBy = bc.FilterContent(author)
+	if rejectedBy != nil {
+		return tchan.Post{}, &FilterRejection{Filter: rejectedBy}
+	}
+	if author = strings.TrimSpace(author); author == "" {
+		author = "Anonymous"
+	}
+
+	return tchan.Post{Author: author, Tripcode: trip, Timestamp: time.Now(), Content: content}, nil
+}
+
+// BanRejection signals that the author of a post is banned from the board.
+type BanRejection struct {
+	Ban tchan.Ban
+}
+
+func (r *BanRejection) Error() string {
+	if r.Ban.Reason == "" {
+		return fmt.Sprintf("banned (#%d)", r.Ban.ID)
+	}
+	return fmt.Sprintf("banned (#%d): %s", r.Ban.ID, r.Ban.Reason)
+}
+
+// LimitRejection signals that a post was refused by flood protection.
+type LimitRejection struct {
+	Reason string
+}
+
+func (r *LimitRejection) Error() string {
+	return r.Reason
+}
+
+// CheckPostAllowed tells whether a client may submit a post with the given
+// content to a board, taking into account bans and the board's flood
+// protection. Refusals are given as *BanRejection or *LimitRejection.
+func CheckPostAllowed(ctx context.Context, db DB, bc tchan.Board, ip string, content string, newThread bool) error {
+	ban := tchan.Ban{}
+	ok := false
+	if err := db.FindBan(ctx, bc.Name, ip, &ban, &ok); err != nil {
+		return errors.Wrap(err, "failed to check for bans")
+	} else if ok {
+		return &BanRejection{Ban: ban}
+	}
+
+	if bc.PostCooldown() == 0 && bc.ThreadCooldown() == 0 && bc.DuplicateWindow() == 0 {
+		return nil
+	}
+	now := time.Now()
+	act := Activity{}
+	if err := db.PopulateActivity(ctx, bc.Name, ip, content, now.Add(-bc.DuplicateWindow()), &act); err != nil {
+		return errors.Wrap(err, "failed to check posting activity")
+	}
+
+	if wait := act.LastPost.Add(bc.PostCooldown()).Sub(now); wait > 0 {
+		return &LimitRejection{Reason: fmt.Sprintf("posting too fast, please wait %v", wait.Round(time.Second))}
+	} else if wait := act.LastThread.Add(bc.ThreadCooldown()).Sub(now); newThread && wait > 0 {
+		return &LimitRejection{Reason: fmt.Sprintf("creating threads too fast, please wait %v", wait.Round(time.Second))}
+	} else if act.Duplicate && bc.DuplicateWindow() > 0 {
+		return &LimitRejection{Reason: "duplicate post, please say something new"}
+	}
+	return nil
+}
@@ -0,0 +1,76 @@
+package backend
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/pkg/errors"
+
+	"github.com/fgahr/termchan/tchan"
+	"github.com/fgahr/termchan/tchan/config"
+)
+
+func TestPreparePost(t *testing.T) {
+	conf := config.Defaults()
+	filter := tchan.Filter{Pattern: "spam", Reject: true}
+	if err := filter.Compile(); err != nil {
+		t.Fatal(err)
+	}
+	conf.Boards = []tchan.Board{{Name: "b", Filters: []tchan.Filter{filter}}}
+
+	post, err := PreparePost(&conf, "b", "  ", "  hello\n")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if post.Author != "Anonymous" || post.Content != "hello" || post.Timestamp.IsZero() {
+		t.Errorf("unexpected post: %+v", post)
+	}
+
+	post, err = PreparePost(&conf, "b", "me#secret", "hello")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if post.Author != "me" || post.Tripcode == "" {
+		t.Errorf("expected name and tripcode, got %+v", post)
+	}
+
+	for _, field := range [][2]string{{"", "buy spam"}, {"spammer", "hello"}} {
+		_, err = PreparePost(&conf, "b", field[0], field[1])
+		if rejection, ok := errors.Cause(err).(*FilterRejection); !ok || rejection.Filter.Pattern != "spam" {
+			t.Errorf("expected rejection by filter, got %v", err)
+		}
+	}
+	if _, err := PreparePost(&conf, "b", "", " \n "); err == nil {
+		t.Error("expected empty post to be refused")
+	}
+	if _, err := PreparePost(&conf, "nope", "", "hello"); err == nil {
+		t.Error("expected missing board to be refused")
+	}
+}
+
+func TestCheckPostAllowed(t *testing.T) {
+	ctx := context.Background()
+	bc := tchan.Board{Name: "b", PostCooldownSecs: 60}
+	db := newTestBackend(t, bc)
+	if err := db.AddBan(ctx, &tchan.Ban{Target: "192.0.2.1", Reason: "spam"}); err != nil {
+		t.Fatal(err)
+	}
+
+	if _, ok := errors.Cause(CheckPostAllowed(ctx, db, bc, "192.0.2.1", "hello", true)).(*BanRejection); !ok {
+		t.Error("expected banned client to be refused")
+	}
+	if err := CheckPostAllowed(ctx, db, bc, "192.0.2.2", "hello", true); err != nil {
+		t.Fatalf("expected first post to be allowed, got %v", err)
+	}
+	op := tchan.Post{Author: "Anonymous", Content: "hello", Timestamp: time.Now(), AuthorIP: "192.0.2.2"}
+	if err := db.CreateThread(ctx, "b", "", &op); err != nil {
+		t.Fatal(err)
+	}
+	if _, ok := errors.Cause(CheckPostAllowed(ctx, db, bc, "192.0.2.2", "again", false)).(*LimitRejection); !ok {
+		t.Error("expected second post to be limited")
+	}
+	if err := CheckPostAllowed(ctx, db, bc, "192.0.2.3", "again", false); err != nil {
+		t.Errorf("expected other client to be allowed, got %v", err)
+	}
+}
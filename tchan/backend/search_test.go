@@ -0,0 +1,111 @@
+//go:build sqlite_fts5
+// +build sqlite_fts5
+
+package backend
+
+import (
+	"context"
+	"fmt"
+	"testing"
+
+	"github.com/fgahr/termchan/tchan"
+	"github.com/fgahr/termchan/tchan/config"
+)
+
+func searchIDs(t *testing.T, db *sqlite, query string) string {
+	ctx := context.Background()
+	var results []tchan.SearchResult
+	ok := false
+	if err := db.Search(ctx, "b", query, 10, &results, &ok); err != nil || !ok {
+		t.Fatalf("failed to search for %q: %v", query, err)
+	}
+	ids := make([]int64, 0, len(results))
+	for _, r := range results {
+		ids = append(ids, r.PostID)
+	}
+	return fmt.Sprint(ids)
+}
+
+func TestSearchIndex(t *testing.T) {
+	ctx := context.Background()
+	db := newTestBackend(t, tchan.Board{Name: "b"})
+	if !db.searchable {
+		t.Fatal("expected search to be available with FTS5")
+	}
+
+	op := tchan.Post{Author: "Anonymous", Content: "first post"}
+	if err := db.CreateThread(ctx, "b", "gardening", &op); err != nil {
+		t.Fatal(err)
+	}
+	for _, content := range []string{"tomatoes need sun", "so do cucumbers"} {
+		reply := tchan.Post{Author: "Anonymous", Content: content}
+		ok := false
+		if err := db.AddReply(ctx, "b", op.ID, &reply, &ok); err != nil || !ok {
+			t.Fatalf("failed to reply to %d: %v", op.ID, err)
+		}
+	}
+
+	cases := []struct{ query, ids string }{
+		{"tomatoes", "[2]"},
+		{"gardening", "[1]"},
+		{"sun tomatoes", "[2]"},
+		{"potatoes", "[]"},
+		{`"unbalanced`, "[]"},
+	}
+	for _, c := range cases {
+		if ids := searchIDs(t, db, c.query); ids != c.ids {
+			t.Errorf("searching %q: expected posts %s, got %s", c.query, c.ids, ids)
+		}
+	}
+
+	ok := false
+	if err := db.DeletePost(ctx, "b", 2, &ok); err != nil || !ok {
+		t.Fatalf("failed to delete post: %v", err)
+	}
+	if ids := searchIDs(t, db, "tomatoes"); ids != "[]" {
+		t.Errorf("expected deleted post to be unindexed, found %s", ids)
+	}
+
+	if _, err := db.boardDBs["b"].Exec(`UPDATE thread SET topic = 'cooking' WHERE op_id = ?;`, op.ID); err != nil {
+		t.Fatal(err)
+	}
+	if ids := searchIDs(t, db, "gardening"); ids != "[]" {
+		t.Errorf("expected old topic to be unindexed, found %s", ids)
+	}
+	if ids := searchIDs(t, db, "cooking"); ids != "[1]" {
+		t.Errorf("expected new topic to be indexed, found %s", ids)
+	}
+
+	if _, err := db.boardDBs["b"].Exec(`DELETE FROM post WHERE id = 3;`); err != nil {
+		t.Fatal(err)
+	}
+	if ids := searchIDs(t, db, "cucumbers"); ids != "[]" {
+		t.Errorf("expected removed post to be unindexed, found %s", ids)
+	}
+}
+
+func TestSearchAfterMerge(t *testing.T) {
+	db := newTestBackend(t, tchan.Board{Name: "b"}, tchan.Board{Name: "g"})
+	createThread(t, db, "g", 1)
+	createThread(t, db, "b", 2)
+	db.Close()
+
+	conf := *db.conf
+	conf.Database.Layout = config.SingleLayout
+	if _, err := MergeBoards(&conf); err != nil {
+		t.Fatal(err)
+	}
+	merged := &sqlite{conf: &conf}
+	if err := merged.Init(); err != nil {
+		t.Fatal(err)
+	}
+	defer merged.Close()
+
+	// Post 2 also exists on /g/
+	if ids := searchIDs(t, merged, "reply"); ids != "[2 3]" {
+		t.Errorf("expected replies [2 3] on /b/, got %s", ids)
+	}
+	if ids := searchIDs(t, merged, "topic"); ids != "[1]" {
+		t.Errorf("expected OP 1 to be found by its topic, got %s", ids)
+	}
+}
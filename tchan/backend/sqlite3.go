@@ -1,8 +1,11 @@
 package backend
 
 import (
+	"context"
 	"database/sql"
+	"log"
 	"os"
+	"strings"
 	"time"
 
 	"github.com/pkg/errors"
@@ -17,7 +20,14 @@ import (
 type sqlite struct {
 	conf            *config.Settings
 	boardsDirectory string
-	boardDBs        map[string]*sql.DB
+	boardDBs        map[string]*cachedDB
+	serverDB        *cachedDB
+	// Shared by all boards in the single layout
+	single *cachedDB
+	// Database settings the connections were opened with
+	dbConf config.Database
+	// Whether full-text search is supported
+	searchable bool
 }
 
 func (s *sqlite) Init() error {
@@ -31,37 +41,143 @@ func (s *sqlite) Init() error {
 		}
 	}
 
-	boards := make(map[string]*sql.DB)
-	for _, board := range s.conf.Boards {
-		bdb, err := s.initBoardDB(board.Name)
+	s.dbConf = s.conf.Database
+	serverDB, err := initServerDB(s.conf.ServerDatabase())
+	if err != nil {
+		return errors.Wrap(err, "server database setup failed")
+	}
+	setPoolLimits(serverDB, s.dbConf)
+	s.serverDB = newCachedDB(serverDB)
+
+	if s.searchable, err = hasFTS5(serverDB); err != nil {
+		return errors.Wrap(err, "failed to check for full-text search support")
+	} else if !s.searchable {
+		log.Println("full-text search unavailable, build with -tags sqlite_fts5 to enable it")
+	}
+
+	s.single = nil
+	if s.dbConf.SingleFile() {
+		db, err := s.initSingleDB()
 		if err != nil {
-			return errors.Wrapf(err, "database setup for /%s/ failed", board.Name)
+			return errors.Wrap(err, "database setup for boards failed")
 		}
-		boards[board.Name] = bdb
+		setPoolLimits(db, s.dbConf)
+		s.single = newCachedDB(db)
 	}
 
-	s.boardDBs = boards
-	return nil
+	s.boardDBs = make(map[string]*cachedDB)
+	return s.syncBoards()
 }
 
+// Refresh opens the databases of added boards and closes those of removed
+// ones, keeping their files. Other boards keep their connections unless the
+// database settings changed, which requires reopening all databases.
 func (s *sqlite) Refresh() error {
-	if err := s.Close(); err != nil {
-		return err
+	if s.conf.Database != s.dbConf {
+		log.Println("database settings changed, reopening all databases")
+		if err := s.Close(); err != nil {
+			return err
+		}
+		return s.Init()
+	}
+	return s.syncBoards()
+}
+
+// syncBoards brings the open board databases in line with the configuration.
+func (s *sqlite) syncBoards() error {
+	configured := make(map[string]bool)
+	for _, board := range s.conf.Boards {
+		if board.Ephemeral {
+			continue
+		}
+		configured[board.Name] = true
+
+		bdb, ok := s.boardDBs[board.Name]
+		if !ok {
+			if bdb = s.single; bdb == nil {
+				db, err := s.initBoardDB(board.Name)
+				if err != nil {
+					return errors.Wrapf(err, "database setup for /%s/ failed", board.Name)
+				}
+				setPoolLimits(db, s.dbConf)
+				bdb = newCachedDB(db)
+			}
+			s.boardDBs[board.Name] = bdb
+		}
+		// The board's limits may have changed
+		if err := archiveThreads(context.Background(), bdb, board); err != nil {
+			return errors.Wrapf(err, "archiving threads on /%s/ failed", board.Name)
+		}
+	}
+
+	var err error
+	for name, db := range s.boardDBs {
+		if configured[name] {
+			continue
+		}
+		delete(s.boardDBs, name)
+		if db == s.single {
+			continue
+		}
+		if cerr := db.Close(); err == nil {
+			err = cerr
+		}
 	}
-	return s.Init()
+	return err
 }
 
 func (s *sqlite) Close() error {
 	var err error
 	for _, db := range s.boardDBs {
-		if err == nil {
-			err = db.Close()
+		if db == s.single {
+			continue
+		}
+		if cerr := db.Close(); err == nil {
+			err = cerr
 		}
 	}
-	return nil
+	for _, db := range []*cachedDB{s.single, s.serverDB} {
+		if db == nil {
+			continue
+		}
+		if cerr := db.Close(); err == nil {
+			err = cerr
+		}
+	}
+	return err
+}
+
+// ctxExecer is implemented by databases and transactions.
+type ctxExecer interface {
+	ExecContext(ctx context.Context, query string, args ...interface{}) (sql.Result, error)
+}
+
+// archiveThreads archives all threads which exceed the board's thread length
+// or have fallen off its last page.
+func archiveThreads(ctx context.Context, db ctxExecer, bconf tchan.Board) error {
+	_, err := db.ExecContext(ctx, `
+UPDATE thread SET archived_at = strftime('%Y-%m-%dT%H:%M:%SZ', 'now')
+WHERE board = ? AND archived_at IS NULL AND num_replies > -1
+AND (num_replies > ? OR id NOT IN (
+    SELECT id FROM thread
+    WHERE board = ? AND archived_at IS NULL AND num_replies > -1 AND num_replies <= ?
+    ORDER BY sticky DESC, active_at DESC, id DESC
+    LIMIT ?
+));
+`, bconf.Name, bconf.MaxThreadLength(), bconf.Name, bconf.MaxThreadLength(), bconf.MaxThreads()*bconf.MaxPages())
+	return err
 }
 
-func (s *sqlite) PopulateBoard(boardName string, b *tchan.BoardOverview, ok *bool) error {
+func countActiveThreads(ctx context.Context, db *cachedDB, boardName string) (int, error) {
+	var n int
+	err := db.QueryRowContext(ctx, `
+SELECT count(*) FROM thread
+WHERE board = ? AND num_replies > -1 AND archived_at IS NULL;
+`, boardName).Scan(&n)
+	return n, err
+}
+
+func (s *sqlite) PopulateBoard(ctx context.Context, boardName string, page int, b *tchan.BoardOverview, ok *bool) error {
 	boardDB, boardOK := s.boardDBs[boardName]
 	if !boardOK {
 		*ok = false
@@ -74,13 +190,24 @@ func (s *sqlite) PopulateBoard(boardName string, b *tchan.BoardOverview, ok *boo
 	}
 	*ok = true
 
-	threadRows, err := boardDB.Query(`
-SELECT t.topic, t.num_replies, t.created_at, t.active_at, op.id, op.author, op.content
-FROM thread t INNER JOIN post op ON t.op_id = op.id
-AND t.num_replies > -1 AND t.num_replies <= ?
-ORDER BY t.active_at DESC
-LIMIT ?;
-`, bconf.MaxThreadLength(), bconf.MaxThreads())
+	numThreads, err := countActiveThreads(ctx, boardDB, boardName)
+	if err != nil {
+		return errors.Wrap(err, "failed to count active threads")
+	}
+	if page < 1 {
+		page = 1
+	}
+	b.Page = page
+	b.NumPages = (numThreads + bconf.MaxThreads() - 1) / bconf.MaxThreads()
+
+	threadRows, err := boardDB.QueryContext(ctx, `
+SELECT t.topic, t.num_replies, t.created_at, t.active_at, t.locked, t.sticky,
+       op.id, op.author, coalesce(op.tripcode, ''), op.content, op.deleted_at IS NOT NULL
+FROM thread t INNER JOIN post op ON t.board = op.board AND t.op_id = op.id
+AND t.board = ? AND t.num_replies > -1 AND t.archived_at IS NULL
+ORDER BY t.sticky DESC, t.active_at DESC, t.id DESC
+LIMIT ? OFFSET ?;
+`, boardName, bconf.MaxThreads(), (page-1)*bconf.MaxThreads())
 	if err != nil {
 		return errors.Wrap(err, "failed to gather thread summaries")
 	}
@@ -90,11 +217,12 @@ LIMIT ?;
 	for threadRows.Next() {
 		t := tchan.ThreadSummary{}
 		var createdTS, activeTS string
-		err = threadRows.Scan(&t.Topic, &t.NumReplies, &createdTS, &activeTS,
-			&t.OP.ID, &t.OP.Author, &t.OP.Content)
+		err = threadRows.Scan(&t.Topic, &t.NumReplies, &createdTS, &activeTS, &t.Locked, &t.Sticky,
+			&t.OP.ID, &t.OP.Author, &t.OP.Tripcode, &t.OP.Content, &t.OP.Deleted)
 		if err != nil {
-			errors.Wrap(err, "failed to extract thread summary")
+			return errors.Wrap(err, "failed to extract thread summary")
 		}
+		tombstone(&t.OP)
 
 		var created time.Time
 		if created, err = time.Parse(time.RFC3339, createdTS); err != nil {
@@ -107,6 +235,9 @@ LIMIT ?;
 			return errors.Wrap(err, "malformed date string in thread table (active_at)")
 		}
 		t.Active = active
+		t.OP.ParseContent()
+		// Replies are only linked when viewing the thread
+		t.OP.QuotedBy = make([]int64, 0)
 
 		b.Threads = append(b.Threads, t)
 	}
@@ -114,11 +245,72 @@ LIMIT ?;
 	return nil
 }
 
-func getThreadID(db *sql.DB, postID int64) (int64, bool, error) {
+func (s *sqlite) PopulateCatalog(ctx context.Context, boardName string, c *tchan.Catalog, ok *bool) error {
+	boardDB, boardOK := s.boardDBs[boardName]
+	if !boardOK {
+		*ok = false
+		return nil
+	}
+	*ok = true
+
+	rows, err := boardDB.QueryContext(ctx, `
+SELECT op_id, topic, num_replies, active_at, locked, sticky FROM thread
+WHERE board = ? AND num_replies > -1 AND archived_at IS NULL
+ORDER BY sticky DESC, active_at DESC, id DESC;
+`, boardName)
+	if err != nil {
+		return errors.Wrap(err, "failed to gather catalog entries")
+	}
+	defer rows.Close()
+
+	return scanCatalog(rows, c)
+}
+
+func (s *sqlite) PopulateArchive(ctx context.Context, boardName string, c *tchan.Catalog, ok *bool) error {
+	boardDB, boardOK := s.boardDBs[boardName]
+	if !boardOK {
+		*ok = false
+		return nil
+	}
+	*ok = true
+	c.Archived = true
+
+	rows, err := boardDB.QueryContext(ctx, `
+SELECT op_id, topic, num_replies, active_at, locked, sticky FROM thread
+WHERE board = ? AND num_replies > -1 AND archived_at IS NOT NULL
+ORDER BY archived_at DESC, id DESC;
+`, boardName)
+	if err != nil {
+		return errors.Wrap(err, "failed to gather archive entries")
+	}
+	defer rows.Close()
+
+	return scanCatalog(rows, c)
+}
+
+func scanCatalog(rows *sql.Rows, c *tchan.Catalog) error {
+	var err error
+	c.Threads = make([]tchan.CatalogEntry, 0)
+	for rows.Next() {
+		e := tchan.CatalogEntry{}
+		var activeTS string
+		if err = rows.Scan(&e.ID, &e.Topic, &e.NumReplies, &activeTS, &e.Locked, &e.Sticky); err != nil {
+			return errors.Wrap(err, "failed to extract catalog entry")
+		}
+		if e.Active, err = time.Parse(time.RFC3339, activeTS); err != nil {
+			return errors.Wrap(err, "malformed date string in thread table (active_at)")
+		}
+		c.Threads = append(c.Threads, e)
+	}
+
+	return rows.Err()
+}
+
+func getThreadID(ctx context.Context, db *cachedDB, boardName string, postID int64) (int64, bool, error) {
 	var threadID int64
-	result, err := db.Query(`
-SELECT thread_id FROM post WHERE id = ?;
-`, postID)
+	result, err := db.QueryContext(ctx, `
+SELECT thread_id FROM post WHERE board = ? AND id = ?;
+`, boardName, postID)
 	if err != nil {
 		return 0, false, err
 	}
@@ -134,28 +326,113 @@ SELECT thread_id FROM post WHERE id = ?;
 	return threadID, true, nil
 }
 
-func getTopic(db *sql.DB, threadID int64) (string, error) {
-	topic := ""
-	result, err := db.Query(`
-SELECT topic FROM thread WHERE id = ?;
-`, threadID)
+type threadInfo struct {
+	topic      string
+	opID       int64
+	numReplies int
+	archived   bool
+	locked     bool
+	sticky     bool
+}
+
+func getThreadInfo(ctx context.Context, db *cachedDB, boardName string, threadID int64) (threadInfo, error) {
+	info := threadInfo{}
+	result, err := db.QueryContext(ctx, `
+SELECT topic, op_id, num_replies, archived_at IS NOT NULL, locked, sticky
+FROM thread WHERE board = ? AND id = ?;
+`, boardName, threadID)
 	if err != nil {
-		return topic, err
+		return info, err
 	}
 	defer result.Close()
 	if !result.Next() {
-		return topic, errors.Errorf("no thread table entry for thread %d", threadID)
+		return info, errors.Errorf("no thread table entry for thread %d", threadID)
+	}
+
+	err = result.Scan(&info.topic, &info.opID, &info.numReplies,
+		&info.archived, &info.locked, &info.sticky)
+	if err != nil {
+		return info, errors.Errorf("invalid thread table entry for thread %d", threadID)
+	}
+
+	return info, nil
+}
+
+// tombstone removes the data of deleted posts before they are served.
+func tombstone(post *tchan.Post) {
+	if post.Deleted {
+		post.Author = ""
+		post.Tripcode = ""
+		post.Content = ""
+	}
+}
+
+func scanPosts(rows *sql.Rows) ([]tchan.Post, error) {
+	posts := make([]tchan.Post, 0)
+	for rows.Next() {
+		post := tchan.Post{}
+		var ts string
+		err := rows.Scan(&post.ID, &post.Author, &post.Tripcode, &ts, &post.Content, &post.Deleted)
+		if err != nil {
+			return posts, err
+		}
+		tombstone(&post)
+
+		post.Timestamp, err = time.Parse(time.RFC3339, ts)
+		if err != nil {
+			return posts, err
+		}
+		posts = append(posts, post)
 	}
+	return posts, rows.Err()
+}
 
-	err = result.Scan(&topic)
+// selectReplies fetches the replies of a thread within the given range.
+func selectReplies(ctx context.Context, db *cachedDB, boardName string, info threadInfo, threadID int64, pr PostRange) ([]tchan.Post, error) {
+	var rows *sql.Rows
+	var err error
+	switch {
+	case pr.Last > 0:
+		// Fetch in reverse to apply the limit, then restore the order
+		rows, err = db.QueryContext(ctx, `
+SELECT * FROM (
+    SELECT id, author, coalesce(tripcode, ''), created_at, content, deleted_at IS NOT NULL FROM post
+    WHERE board = ? AND thread_id = ? AND id <> ? AND id > ?
+    ORDER BY id DESC
+    LIMIT ?
+) ORDER BY id ASC;
+`, boardName, threadID, info.opID, pr.After, pr.Last)
+	case pr.Page > 0:
+		rows, err = db.QueryContext(ctx, `
+SELECT id, author, coalesce(tripcode, ''), created_at, content, deleted_at IS NOT NULL FROM post
+WHERE board = ? AND thread_id = ? AND id <> ? AND id > ?
+ORDER BY id ASC
+LIMIT ? OFFSET ?;
+`, boardName, threadID, info.opID, pr.After, pr.PageSize, (pr.Page-1)*pr.PageSize)
+	default:
+		rows, err = db.QueryContext(ctx, `
+SELECT id, author, coalesce(tripcode, ''), created_at, content, deleted_at IS NOT NULL FROM post
+WHERE board = ? AND thread_id = ? AND id <> ? AND id > ?
+ORDER BY id ASC;
+`, boardName, threadID, info.opID, pr.After)
+	}
 	if err != nil {
-		return topic, errors.Errorf("invalid topic for thread %d", threadID)
+		return nil, err
 	}
+	defer rows.Close()
+
+	return scanPosts(rows)
+}
 
-	return topic, nil
+func countRepliesBefore(ctx context.Context, db *cachedDB, boardName string, info threadInfo, threadID int64, postID int64) (int, error) {
+	var n int
+	err := db.QueryRowContext(ctx, `
+SELECT count(*) FROM post WHERE board = ? AND thread_id = ? AND id <> ? AND id < ?;
+`, boardName, threadID, info.opID, postID).Scan(&n)
+	return n, err
 }
 
-func (s *sqlite) PopulateThread(boardName string, postID int64, thr *tchan.Thread, ok *bool) error {
+func (s *sqlite) PopulateThread(ctx context.Context, boardName string, postID int64, pr PostRange, thr *tchan.Thread, ok *bool) error {
 	*ok = false
 
 	boardDB, boardOK := s.boardDBs[boardName]
@@ -163,113 +440,278 @@ func (s *sqlite) PopulateThread(boardName string, postID int64, thr *tchan.Threa
 		return nil
 	}
 
-	threadID, idOK, err := getThreadID(boardDB, postID)
+	threadID, idOK, err := getThreadID(ctx, boardDB, boardName, postID)
 	if err != nil {
 		return err
 	}
 	if !idOK {
 		return nil
 	}
-	thr.Topic, err = getTopic(boardDB, threadID)
+	info, err := getThreadInfo(ctx, boardDB, boardName, threadID)
 	if err != nil {
 		return err
 	}
+	thr.Topic = info.topic
+	thr.Archived = info.archived
+	thr.Locked = info.locked
+	thr.Sticky = info.sticky
 
 	*ok = true
 
-	result, err := boardDB.Query(`
-SELECT id, author, created_at, content FROM post
-WHERE thread_id = ?
-ORDER BY created_at ASC;
-`, threadID)
+	opRows, err := boardDB.QueryContext(ctx, `
+SELECT id, author, coalesce(tripcode, ''), created_at, content, deleted_at IS NOT NULL FROM post
+WHERE board = ? AND id = ?;
+`, boardName, info.opID)
 	if err != nil {
 		return err
 	}
-	defer result.Close()
+	defer opRows.Close()
+	if thr.Posts, err = scanPosts(opRows); err != nil {
+		return err
+	}
 
-	for result.Next() {
-		post := tchan.Post{}
-		var ts string
-		err = result.Scan(&post.ID, &post.Author, &ts, &post.Content)
-		if err != nil {
-			return err
-		}
+	replies, err := selectReplies(ctx, boardDB, boardName, info, threadID, pr)
+	if err != nil {
+		return err
+	}
 
-		post.Timestamp, err = time.Parse(time.RFC3339, ts)
+	if len(replies) > 0 {
+		thr.OmittedBefore, err = countRepliesBefore(ctx, boardDB, boardName, info, threadID, replies[0].ID)
 		if err != nil {
 			return err
 		}
-		thr.Posts = append(thr.Posts, post)
+	} else {
+		thr.OmittedBefore = info.numReplies
 	}
+	thr.OmittedAfter = info.numReplies - thr.OmittedBefore - len(replies)
+
+	thr.Posts = append(thr.Posts, replies...)
+	thr.LinkQuotes()
 
 	return nil
 }
 
-func (s *sqlite) CreateThread(boardName string, topic string, op *tchan.Post) error {
+func (s *sqlite) CreateThread(ctx context.Context, boardName string, topic string, op *tchan.Post) error {
 	boardDB, boardOK := s.boardDBs[boardName]
 	if !boardOK {
 		return errors.Errorf("attempting to create thread on non-existing board /%s/", boardName)
 	}
 
-	tresult, err := boardDB.Exec(`
-INSERT INTO thread (topic) VALUES (?);
-`, topic)
+	// Without its OP, the thread would be left behind as an orphan
+	tx, err := boardDB.BeginTx(ctx, nil)
 	if err != nil {
 		return err
 	}
+	defer tx.Rollback()
 
-	threadID, err := tresult.LastInsertId()
+	var threadID int64
+	err = tx.QueryRowContext(ctx, `
+INSERT INTO thread (board, id, topic)
+VALUES (?1, (SELECT coalesce(max(id), 0) + 1 FROM thread WHERE board = ?1), ?2)
+RETURNING id;
+`, boardName, topic).Scan(&threadID)
 	if err != nil {
 		return err
 	}
 
-	presult, err := boardDB.Exec(`
-INSERT INTO post (thread_id, author, content) VALUES (?, ?, ?);
-`, threadID, op.Author, op.Content)
-	if err != nil {
+	if op.ID, err = insertPost(ctx, tx, boardName, threadID, op); err != nil {
 		return err
 	}
 
-	op.ID, err = presult.LastInsertId()
-	return err
+	if err = s.archiveThreads(ctx, boardName, tx); err != nil {
+		return err
+	}
+	return tx.Commit()
 }
 
-func (s *sqlite) AddReply(boardName string, postID int64, post *tchan.Post, ok *bool) error {
+func (s *sqlite) AddReply(ctx context.Context, boardName string, postID int64, post *tchan.Post, ok *bool) error {
 	boardDB, boardOK := s.boardDBs[boardName]
 	if !boardOK {
 		return errors.Errorf("attempting to add post on non-existing board /%s/", boardName)
 	}
 
-	postRow, err := boardDB.Query(`
-SELECT thread_id FROM post WHERE id = ?;
-`, postID)
+	// The thread must not be locked or archived in the meantime
+	tx, err := boardDB.BeginTx(ctx, nil)
 	if err != nil {
 		return err
 	}
+	defer tx.Rollback()
 
-	if !postRow.Next() {
+	var threadID int64
+	var archived, locked bool
+	err = tx.QueryRowContext(ctx, `
+SELECT p.thread_id, t.archived_at IS NOT NULL, t.locked
+FROM post p INNER JOIN thread t ON p.board = t.board AND p.thread_id = t.id
+WHERE p.board = ? AND p.id = ?;
+`, boardName, postID).Scan(&threadID, &archived, &locked)
+	if err == sql.ErrNoRows {
 		*ok = false
 		return nil
+	} else if err != nil {
+		return err
 	}
 	*ok = true
 
-	var threadID int
-	if err = postRow.Scan(&threadID); err != nil {
+	if archived {
+		return ErrThreadArchived
+	}
+	if locked {
+		return ErrThreadLocked
+	}
+
+	if post.ID, err = insertPost(ctx, tx, boardName, threadID, post); err != nil {
 		return err
 	}
 
-	if err = postRow.Close(); err != nil {
+	if err = s.archiveThreads(ctx, boardName, tx); err != nil {
 		return err
 	}
+	return tx.Commit()
+}
+
+// insertPost adds a post to a thread, giving its ID. IDs are assigned per
+// board; the writing transaction keeps others from taking the same one.
+func insertPost(ctx context.Context, tx *cachedTx, boardName string, threadID int64, post *tchan.Post) (int64, error) {
+	var id int64
+	err := tx.QueryRowContext(ctx, `
+INSERT INTO post (board, id, thread_id, author, tripcode, author_ip, content)
+VALUES (?1, (SELECT coalesce(max(id), 0) + 1 FROM post WHERE board = ?1), ?2, ?3, ?4, ?5, ?6)
+RETURNING id;
+`, boardName, threadID, post.Author, post.Tripcode, post.AuthorIP, post.Content).Scan(&id)
+	return id, err
+}
 
-	result, err := boardDB.Exec(`
-INSERT INTO post (thread_id, author, content) VALUES (?, ?, ?);
-`, threadID, post.Author, post.Content)
+func (s *sqlite) archiveThreads(ctx context.Context, boardName string, db ctxExecer) error {
+	bconf, confOK := s.conf.BoardConfig(boardName)
+	if !confOK {
+		return errors.Errorf("found DB but no config for /%s/", boardName)
+	}
+	return archiveThreads(ctx, db, bconf)
+}
+
+func parseOptionalTime(ts sql.NullString) (time.Time, error) {
+	if !ts.Valid {
+		return time.Time{}, nil
+	}
+	return time.Parse(time.RFC3339, ts.String)
+}
+
+func (s *sqlite) PopulateActivity(ctx context.Context, boardName string, ip string, content string, since time.Time, a *Activity) error {
+	boardDB, boardOK := s.boardDBs[boardName]
+	if !boardOK {
+		return errors.Errorf("attempting to check activity on non-existing board /%s/", boardName)
+	}
+
+	var lastPost, lastThread sql.NullString
+	err := boardDB.QueryRowContext(ctx, `
+SELECT max(created_at) FROM post WHERE board = ? AND author_ip = ?;
+`, boardName, ip).Scan(&lastPost)
 	if err != nil {
-		return err
+		return errors.Wrap(err, "failed to find latest post")
 	}
 
-	post.ID, err = result.LastInsertId()
+	err = boardDB.QueryRowContext(ctx, `
+SELECT max(p.created_at) FROM post p INNER JOIN thread t ON t.board = p.board AND t.op_id = p.id
+WHERE p.board = ? AND p.author_ip = ?;
+`, boardName, ip).Scan(&lastThread)
+	if err != nil {
+		return errors.Wrap(err, "failed to find latest thread")
+	}
 
-	return err
+	if a.LastPost, err = parseOptionalTime(lastPost); err != nil {
+		return errors.Wrap(err, "malformed date string in post table (created_at)")
+	}
+	if a.LastThread, err = parseOptionalTime(lastThread); err != nil {
+		return errors.Wrap(err, "malformed date string in post table (created_at)")
+	}
+
+	err = boardDB.QueryRowContext(ctx, `
+SELECT count(*) > 0 FROM post
+WHERE board = ? AND author_ip = ? AND content = ? AND created_at >= ?;
+`, boardName, ip, content, since.UTC().Format(time.RFC3339)).Scan(&a.Duplicate)
+	if err != nil {
+		return errors.Wrap(err, "failed to check for duplicate posts")
+	}
+
+	return nil
+}
+
+func (s *sqlite) PopulatePosts(ctx context.Context, boardName string, posts *[]tchan.Post, ok *bool) error {
+	boardDB, boardOK := s.boardDBs[boardName]
+	if !boardOK {
+		*ok = false
+		return nil
+	}
+	*ok = true
+
+	rows, err := boardDB.QueryContext(ctx, `
+SELECT id, author, coalesce(tripcode, ''), created_at, content, deleted_at IS NOT NULL FROM post
+WHERE board = ? AND deleted_at IS NULL
+ORDER BY id ASC;
+`, boardName)
+	if err != nil {
+		return errors.Wrapf(err, "failed to gather posts for /%s/", boardName)
+	}
+	defer rows.Close()
+
+	*posts, err = scanPosts(rows)
+	return errors.Wrapf(err, "failed to extract posts for /%s/", boardName)
+}
+
+// searchQuery turns user input into an FTS5 query matching all given terms,
+// avoiding syntax errors on special characters.
+func searchQuery(input string) string {
+	terms := strings.Fields(input)
+	for i, t := range terms {
+		terms[i] = `"` + strings.ReplaceAll(t, `"`, `""`) + `"`
+	}
+	return strings.Join(terms, " ")
+}
+
+func (s *sqlite) Search(ctx context.Context, boardName string, query string, limit int, results *[]tchan.SearchResult, ok *bool) error {
+	if !s.searchable {
+		return ErrSearchUnavailable
+	}
+	boardDB, boardOK := s.boardDBs[boardName]
+	if !boardOK {
+		*ok = false
+		return nil
+	}
+	*ok = true
+
+	*results = make([]tchan.SearchResult, 0)
+	match := searchQuery(query)
+	if match == "" {
+		return nil
+	}
+
+	rows, err := boardDB.QueryContext(ctx, `
+SELECT p.id, t.op_id, coalesce(t.topic, ''), p.author, coalesce(p.tripcode, ''), p.created_at,
+       snippet(post_search, 1, ?, ?, '...', 16), post_search.rank
+FROM post_search
+INNER JOIN post p ON p.rowid = post_search.rowid
+INNER JOIN thread t ON t.board = p.board AND t.id = p.thread_id
+WHERE post_search MATCH ? AND p.board = ?
+ORDER BY post_search.rank
+LIMIT ?;
+`, tchan.SnippetMatchStart, tchan.SnippetMatchEnd, match, boardName, limit)
+	if err != nil {
+		return errors.Wrapf(err, "failed to search /%s/", boardName)
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		r := tchan.SearchResult{Board: boardName}
+		var ts, snippet string
+		err := rows.Scan(&r.PostID, &r.ThreadID, &r.Topic, &r.Author, &r.Tripcode, &ts, &snippet, &r.Rank)
+		if err != nil {
+			return errors.Wrap(err, "failed to extract search result")
+		}
+		if r.Timestamp, err = time.Parse(time.RFC3339, ts); err != nil {
+			return errors.Wrap(err, "malformed date string in post table")
+		}
+		r.Snippet = tchan.ParseSnippet(snippet)
+		*results = append(*results, r)
+	}
+	return rows.Err()
 }
@@ -0,0 +1,229 @@
+package backend
+
+import (
+	"context"
+	"fmt"
+	"testing"
+
+	"github.com/pkg/errors"
+
+	"github.com/fgahr/termchan/tchan"
+	"github.com/fgahr/termchan/tchan/config"
+)
+
+// newTestBackend sets up a backend with fresh databases for the given boards,
+// which have to be sorted by name.
+func newTestBackend(t testing.TB, boards ...tchan.Board) *sqlite {
+	return newLayoutTestBackend(t, config.PerBoardLayout, boards...)
+}
+
+// newLayoutTestBackend is like newTestBackend, with the given database layout.
+func newLayoutTestBackend(t testing.TB, layout string, boards ...tchan.Board) *sqlite {
+	conf := config.Defaults()
+	if err := conf.SetWorkingDirectory(t.TempDir()); err != nil {
+		t.Fatal(err)
+	}
+	conf.Database.Layout = layout
+	conf.Boards = boards
+
+	db := &sqlite{conf: &conf}
+	if err := db.Init(); err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { db.Close() })
+	return db
+}
+
+func createThread(t testing.TB, db DB, board string, numReplies int) int64 {
+	ctx := context.Background()
+	op := tchan.Post{Author: "Anonymous", Content: "op"}
+	if err := db.CreateThread(ctx, board, "topic", &op); err != nil {
+		t.Fatal(err)
+	}
+	for i := 0; i < numReplies; i++ {
+		reply := tchan.Post{Author: "Anonymous", Content: fmt.Sprintf("reply %d", i+1)}
+		ok := false
+		if err := db.AddReply(ctx, board, op.ID, &reply, &ok); err != nil || !ok {
+			t.Fatalf("failed to reply to %d: %v", op.ID, err)
+		}
+	}
+	return op.ID
+}
+
+func postIDs(posts []tchan.Post) []int64 {
+	ids := make([]int64, 0, len(posts))
+	for _, p := range posts {
+		ids = append(ids, p.ID)
+	}
+	return ids
+}
+
+func TestPopulateThreadRanges(t *testing.T) {
+	ctx := context.Background()
+	db := newTestBackend(t, tchan.Board{Name: "b", PageLength: 3})
+	// OP is post 1, replies are posts 2 to 11
+	opID := createThread(t, db, "b", 10)
+
+	cases := []struct {
+		pr            PostRange
+		ids           string
+		before, after int
+	}{
+		{PostRange{}, "[1 2 3 4 5 6 7 8 9 10 11]", 0, 0},
+		{PostRange{Last: 3}, "[1 9 10 11]", 7, 0},
+		{PostRange{After: 5}, "[1 6 7 8 9 10 11]", 4, 0},
+		{PostRange{After: 5, Last: 2}, "[1 10 11]", 8, 0},
+		{PostRange{Page: 2, PageSize: 3}, "[1 5 6 7]", 3, 4},
+		{PostRange{Page: 4, PageSize: 3}, "[1 11]", 9, 0},
+		{PostRange{Page: 5, PageSize: 3}, "[1]", 10, 0},
+		{PostRange{After: 11}, "[1]", 10, 0},
+	}
+	for _, c := range cases {
+		thr := tchan.Thread{Board: tchan.Board{Name: "b"}}
+		ok := false
+		if err := db.PopulateThread(ctx, "b", opID, c.pr, &thr, &ok); err != nil || !ok {
+			t.Fatalf("%+v: failed to fetch thread: %v", c.pr, err)
+		}
+		if ids := fmt.Sprint(postIDs(thr.Posts)); ids != c.ids {
+			t.Errorf("%+v: expected posts %s, got %s", c.pr, c.ids, ids)
+		}
+		if thr.OmittedBefore != c.before || thr.OmittedAfter != c.after {
+			t.Errorf("%+v: expected %d/%d omitted, got %d/%d",
+				c.pr, c.before, c.after, thr.OmittedBefore, thr.OmittedAfter)
+		}
+		if thr.NumReplies() != 10 {
+			t.Errorf("%+v: expected 10 replies, got %d", c.pr, thr.NumReplies())
+		}
+	}
+}
+
+func TestPopulateBoardPages(t *testing.T) {
+	ctx := context.Background()
+	db := newTestBackend(t, tchan.Board{Name: "b", ThreadsMax: 2})
+	for i := 0; i < 5; i++ {
+		createThread(t, db, "b", 0)
+	}
+
+	for page, expected := range map[int]string{1: "[5 4]", 2: "[3 2]", 3: "[1]", 4: "[]"} {
+		b := tchan.BoardOverview{}
+		ok := false
+		if err := db.PopulateBoard(ctx, "b", page, &b, &ok); err != nil || !ok {
+			t.Fatalf("page %d: failed to fetch board: %v", page, err)
+		}
+		ops := make([]tchan.Post, 0)
+		for _, thr := range b.Threads {
+			ops = append(ops, thr.OP)
+		}
+		if ids := fmt.Sprint(postIDs(ops)); ids != expected {
+			t.Errorf("page %d: expected threads %s, got %s", page, expected, ids)
+		}
+		if b.Page != page || b.NumPages != 3 {
+			t.Errorf("page %d: expected page %d of 3, got %d of %d", page, page, b.Page, b.NumPages)
+		}
+	}
+}
+
+func TestArchiveLongThreads(t *testing.T) {
+	ctx := context.Background()
+	db := newTestBackend(t, tchan.Board{Name: "b", ThreadLengthMax: 2})
+	opID := createThread(t, db, "b", 2)
+
+	reply := tchan.Post{Author: "Anonymous", Content: "one too many"}
+	ok := false
+	if err := db.AddReply(ctx, "b", opID, &reply, &ok); err != nil || !ok {
+		t.Fatalf("expected last reply to succeed, got %v", err)
+	}
+
+	reply = tchan.Post{Author: "Anonymous", Content: "too late"}
+	if err := db.AddReply(ctx, "b", opID, &reply, &ok); errors.Cause(err) != ErrThreadArchived {
+		t.Errorf("expected reply to archived thread to fail, got %v", err)
+	}
+
+	c := tchan.Catalog{}
+	if err := db.PopulateArchive(ctx, "b", &c, &ok); err != nil || len(c.Threads) != 1 || c.Threads[0].ID != opID {
+		t.Errorf("expected thread %d in archive, got %+v (%v)", opID, c.Threads, err)
+	}
+}
+
+func TestArchiveLastPage(t *testing.T) {
+	ctx := context.Background()
+	db := newTestBackend(t, tchan.Board{Name: "b", ThreadsMax: 1, PagesMax: 2})
+	first := createThread(t, db, "b", 0)
+	createThread(t, db, "b", 0)
+
+	ok := false
+	if err := db.SetSticky(ctx, "b", first, true, &ok); err != nil || !ok {
+		t.Fatal(err)
+	}
+	// Pushes the second thread off the last page, the sticky one stays
+	third := createThread(t, db, "b", 0)
+
+	c := tchan.Catalog{}
+	if err := db.PopulateCatalog(ctx, "b", &c, &ok); err != nil {
+		t.Fatal(err)
+	}
+	if len(c.Threads) != 2 || c.Threads[0].ID != first || c.Threads[1].ID != third {
+		t.Errorf("expected threads [%d %d] to remain active, got %+v", first, third, c.Threads)
+	}
+	if err := db.PopulateArchive(ctx, "b", &c, &ok); err != nil || len(c.Threads) != 1 || c.Threads[0].ID == first {
+		t.Errorf("expected second thread in archive, got %+v (%v)", c.Threads, err)
+	}
+}
+
+func TestCancelledQueries(t *testing.T) {
+	db := newTestBackend(t, tchan.Board{Name: "b"})
+	opID := createThread(t, db, "b", 1)
+
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	ok := false
+	bo := tchan.BoardOverview{}
+	if err := db.PopulateBoard(ctx, "b", 1, &bo, &ok); errors.Cause(err) != context.Canceled {
+		t.Errorf("expected cancelled board query to fail, got %v", err)
+	}
+	reply := tchan.Post{Author: "Anonymous", Content: "too late"}
+	if err := db.AddReply(ctx, "b", opID, &reply, &ok); errors.Cause(err) != context.Canceled {
+		t.Errorf("expected cancelled reply to fail, got %v", err)
+	}
+
+	thr := tchan.Thread{}
+	if err := db.PopulateThread(context.Background(), "b", opID, PostRange{}, &thr, &ok); err != nil || len(thr.Posts) != 2 {
+		t.Errorf("expected cancelled reply not to be stored, got %d posts (%v)", len(thr.Posts), err)
+	}
+}
+
+func TestRefreshBoards(t *testing.T) {
+	for _, layout := range []string{config.PerBoardLayout, config.SingleLayout} {
+		t.Run(layout, func(t *testing.T) { testRefreshBoards(t, layout) })
+	}
+}
+
+func testRefreshBoards(t *testing.T, layout string) {
+	ctx := context.Background()
+	db := newLayoutTestBackend(t, layout, tchan.Board{Name: "b"}, tchan.Board{Name: "g"})
+	createThread(t, db, "b", 0)
+	gID := createThread(t, db, "g", 1)
+	bDB := db.boardDBs["b"]
+
+	db.conf.Boards = []tchan.Board{{Name: "b"}, {Name: "n"}}
+	if err := db.Refresh(); err != nil {
+		t.Fatal(err)
+	}
+	if db.boardDBs["b"] != bDB {
+		t.Error("expected database of unchanged board to be kept open")
+	}
+	thr := tchan.Thread{}
+	ok := false
+	if err := db.PopulateThread(ctx, "g", gID, PostRange{}, &thr, &ok); err != nil || ok {
+		t.Errorf("expected removed board to be gone, got %v", err)
+	}
+	createThread(t, db, "n", 0)
+
+	db.conf.Boards = []tchan.Board{{Name: "b"}, {Name: "g"}, {Name: "n"}}
+	if err := db.Refresh(); err != nil {
+		t.Fatal(err)
+	}
+	if err := db.PopulateThread(ctx, "g", gID, PostRange{}, &thr, &ok); err != nil || !ok || len(thr.Posts) != 2 {
+		t.Errorf("expected threads of re-added board to be kept, got %+v (%v)", thr, err)
+	}
+}
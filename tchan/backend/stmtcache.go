@@ -0,0 +1,126 @@
+package backend
+
+import (
+	"context"
+	"database/sql"
+	"sync"
+)
+
+// cacheStatements can be disabled to compare performance in benchmarks.
+var cacheStatements = true
+
+// cachedDB prepares each query it runs only once. As all queries are constant
+// apart from their arguments, the cache remains small.
+type cachedDB struct {
+	*sql.DB
+	lock  sync.RWMutex
+	stmts map[string]*sql.Stmt
+}
+
+func newCachedDB(db *sql.DB) *cachedDB {
+	return &cachedDB{DB: db, stmts: make(map[string]*sql.Stmt)}
+}
+
+// stmt gives the prepared statement for a query, preparing it if necessary.
+func (c *cachedDB) stmt(ctx context.Context, query string) (*sql.Stmt, error) {
+	c.lock.RLock()
+	stmt, ok := c.stmts[query]
+	c.lock.RUnlock()
+	if ok {
+		return stmt, nil
+	}
+
+	c.lock.Lock()
+	defer c.lock.Unlock()
+	if stmt, ok = c.stmts[query]; ok {
+		return stmt, nil
+	}
+	stmt, err := c.DB.PrepareContext(ctx, query)
+	if err != nil {
+		return nil, err
+	}
+	c.stmts[query] = stmt
+	return stmt, nil
+}
+
+func (c *cachedDB) QueryContext(ctx context.Context, query string, args ...interface{}) (*sql.Rows, error) {
+	if !cacheStatements {
+		return c.DB.QueryContext(ctx, query, args...)
+	}
+	stmt, err := c.stmt(ctx, query)
+	if err != nil {
+		return nil, err
+	}
+	return stmt.QueryContext(ctx, args...)
+}
+
+func (c *cachedDB) QueryRowContext(ctx context.Context, query string, args ...interface{}) *sql.Row {
+	if !cacheStatements {
+		return c.DB.QueryRowContext(ctx, query, args...)
+	}
+	stmt, err := c.stmt(ctx, query)
+	if err != nil {
+		// Only a Row obtained from the DB can carry the error
+		return c.DB.QueryRowContext(ctx, query, args...)
+	}
+	return stmt.QueryRowContext(ctx, args...)
+}
+
+func (c *cachedDB) ExecContext(ctx context.Context, query string, args ...interface{}) (sql.Result, error) {
+	if !cacheStatements {
+		return c.DB.ExecContext(ctx, query, args...)
+	}
+	stmt, err := c.stmt(ctx, query)
+	if err != nil {
+		return nil, err
+	}
+	return stmt.ExecContext(ctx, args...)
+}
+
+// BeginTx starts a transaction using the cached statements.
+func (c *cachedDB) BeginTx(ctx context.Context, opts *sql.TxOptions) (*cachedTx, error) {
+	tx, err := c.DB.BeginTx(ctx, opts)
+	if err != nil {
+		return nil, err
+	}
+	return &cachedTx{Tx: tx, db: c}, nil
+}
+
+// Close closes all prepared statements along with the database.
+func (c *cachedDB) Close() error {
+	c.lock.Lock()
+	defer c.lock.Unlock()
+	for query, stmt := range c.stmts {
+		stmt.Close()
+		delete(c.stmts, query)
+	}
+	return c.DB.Close()
+}
+
+// cachedTx is a transaction using the statements of a cachedDB.
+type cachedTx struct {
+	*sql.Tx
+	db *cachedDB
+}
+
+func (t *cachedTx) QueryRowContext(ctx context.Context, query string, args ...interface{}) *sql.Row {
+	if !cacheStatements {
+		return t.Tx.QueryRowContext(ctx, query, args...)
+	}
+	stmt, err := t.db.stmt(ctx, query)
+	if err != nil {
+		return t.Tx.QueryRowContext(ctx, query, args...)
+	}
+	return t.Tx.StmtContext(ctx, stmt).QueryRowContext(ctx, args...)
+}
+
+func (t *cachedTx) ExecContext(ctx context.Context, query string, args ...interface{}) (sql.Result, error) {
+	if !cacheStatements {
+		return t.Tx.ExecContext(ctx, query, args...)
+	}
+	stmt, err := t.db.stmt(ctx, query)
+	if err != nil {
+		return nil, err
+	}
+	return t.Tx.StmtContext(ctx, stmt).ExecContext(ctx, args...)
+}
@@ -0,0 +1,46 @@
+-- Board schema as of the introduction of moderation
+CREATE TABLE thread (
+    id INTEGER PRIMARY KEY,
+    op_id INTEGER,
+    num_replies INTEGER DEFAULT -1,
+    topic TEXT,
+    created_at TEXT,
+    active_at TEXT,
+    archived_at TEXT,
+    locked INTEGER DEFAULT 0,
+    sticky INTEGER DEFAULT 0
+);
+
+CREATE TABLE post (
+    id INTEGER PRIMARY KEY,
+    thread_id INTEGER NOT NULL,
+    author TEXT,
+    author_ip TEXT,
+    content TEXT NOT NULL,
+    created_at TEXT,
+    deleted_at TEXT,
+    FOREIGN KEY(thread_id) REFERENCES thread(id) NOT DEFERRABLE
+);
+
+CREATE INDEX post_by_thread_id ON post(thread_id);
+
+CREATE TRIGGER update_thread_timestamp
+AFTER INSERT ON post
+FOR EACH ROW
+BEGIN
+UPDATE post SET
+created_at = strftime('%Y-%m-%dT%H:%M:%SZ', 'now')
+    WHERE post.id = NEW.id;
+
+UPDATE thread
+SET num_replies = num_replies + 1,
+    op_id = coalesce(op_id, NEW.id),
+    created_at = coalesce(created_at, (SELECT created_at FROM post WHERE id = NEW.id)),
+    active_at = max(coalesce(active_at, '1970-01-01T00:00:00'),
+                     (SELECT created_at FROM post WHERE id = NEW.id))
+WHERE id = NEW.thread_id;
+END;
+
+INSERT INTO thread (topic) VALUES ('first');
+INSERT INTO post (thread_id, author, author_ip, content) VALUES (1, 'Anonymous', '127.0.0.1', 'hello');
+INSERT INTO post (thread_id, author, author_ip, content) VALUES (1, 'me', '127.0.0.2', '>>1 hi');
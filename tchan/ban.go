@@ -0,0 +1,41 @@
+package tchan
+
+import (
+	"net"
+	"strings"
+	"time"
+)
+
+// Ban prevents clients from posting on a board or on all boards.
+type Ban struct {
+	ID int64 `json:"id"`
+	// Banned IP address or CIDR range
+	Target string `json:"target"`
+	// Empty for bans on all boards
+	Board   string     `json:"board,omitempty"`
+	Reason  string     `json:"reason"`
+	Created time.Time  `json:"created"`
+	Expires *time.Time `json:"expires,omitempty"`
+}
+
+// ValidBanTarget tells whether the target is an IP address or CIDR range.
+func ValidBanTarget(target string) bool {
+	if strings.Contains(target, "/") {
+		_, _, err := net.ParseCIDR(target)
+		return err == nil
+	}
+	return net.ParseIP(target) != nil
+}
+
+// Matches tells whether the ban applies to the given address.
+func (b Ban) Matches(ip string) bool {
+	addr := net.ParseIP(ip)
+	if addr == nil {
+		return false
+	}
+	if _, cidr, err := net.ParseCIDR(b.Target); err == nil {
+		return cidr.Contains(addr)
+	}
+	target := net.ParseIP(b.Target)
+	return target != nil && target.Equal(addr)
+}
@@ -0,0 +1,37 @@
+package tchan
+
+import (
+	"testing"
+)
+
+func TestBanMatches(t *testing.T) {
+	cases := []struct {
+		target, ip string
+		matches    bool
+	}{
+		{"203.0.113.7", "203.0.113.7", true},
+		{"203.0.113.7", "203.0.113.8", false},
+		{"203.0.113.0/24", "203.0.113.200", true},
+		{"203.0.113.0/24", "203.0.114.1", false},
+		{"2001:db8::/32", "2001:db8:1::1", true},
+		{"2001:db8::/32", "2001:db9::1", false},
+		{"::ffff:203.0.113.7", "203.0.113.7", true},
+		{"203.0.113.7", "unknown", false},
+	}
+	for _, c := range cases {
+		if m := (Ban{Target: c.target}).Matches(c.ip); m != c.matches {
+			t.Errorf("ban on %s matching %s: expected %v, got %v", c.target, c.ip, c.matches, m)
+		}
+	}
+}
+
+func TestValidBanTarget(t *testing.T) {
+	for target, valid := range map[string]bool{
+		"203.0.113.7": true, "203.0.113.0/24": true, "::1": true,
+		"203.0.113.0/33": false, "example.com": false, "": false,
+	} {
+		if ValidBanTarget(target) != valid {
+			t.Errorf("expected validity of %q to be %v", target, valid)
+		}
+	}
+}
@@ -0,0 +1,243 @@
+package client
+
+import (
+	"bufio"
+	"context"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"os"
+	"os/exec"
+	"strconv"
+	"strings"
+
+	"github.com/pkg/errors"
+
+	"github.com/fgahr/termchan/tchan"
+)
+
+const browseHelp = `commands:
+  boards           List all boards
+  /g/ or g         Open a board
+  next, prev       Show the next or previous page of the board
+  42 or /g/42      Open the thread with post 42 in it
+  >>42, >>>/g/42   Follow a quote link
+  reply            Reply to the open thread, written in $EDITOR
+  name <name>      Set the name to post with, e.g. 'name me#secret'
+  back             Return to the board or the list of boards
+  (empty line)     Reload the current view
+  help             Show this message
+  quit             Exit
+`
+
+// Browser is an interactive, line-based client reading commands from one
+// stream and writing boards and threads to another.
+type Browser struct {
+	remote *Remote
+	in     *bufio.Scanner
+	out    io.Writer
+	// Edit lets the user write a post, returning its content
+	Edit func() (string, error)
+	// Current location, the list of boards if no board is open
+	board  string
+	page   int
+	thread int64
+	name   string
+}
+
+// NewBrowser creates a browser posting through $EDITOR.
+func NewBrowser(remote *Remote, in io.Reader, out io.Writer) *Browser {
+	return &Browser{remote: remote, in: bufio.NewScanner(in), out: out, Edit: EditPost}
+}
+
+// Run shows the list of boards, then handles commands until quit or the end
+// of input.
+func (b *Browser) Run(ctx context.Context) error {
+	if err := b.show(ctx); err != nil {
+		return err
+	}
+	for {
+		fmt.Fprint(b.out, b.prompt())
+		if !b.in.Scan() {
+			fmt.Fprintln(b.out)
+			return b.in.Err()
+		}
+
+		line := strings.TrimSpace(b.in.Text())
+		if line == "quit" || line == "q" {
+			return nil
+		}
+		if err := b.handle(ctx, line); err != nil {
+			fmt.Fprintf(b.out, "error: %v\n", err)
+		}
+	}
+}
+
+func (b *Browser) prompt() string {
+	switch {
+	case b.thread != 0:
+		return fmt.Sprintf("/%s/%d> ", b.board, b.thread)
+	case b.board != "":
+		return fmt.Sprintf("/%s/> ", b.board)
+	default:
+		return "> "
+	}
+}
+
+func (b *Browser) handle(ctx context.Context, line string) error {
+	cmd, arg := line, ""
+	if i := strings.IndexByte(line, ' '); i >= 0 {
+		cmd, arg = line[:i], strings.TrimSpace(line[i+1:])
+	}
+
+	switch cmd {
+	case "":
+		return b.show(ctx)
+	case "help", "h", "?":
+		fmt.Fprint(b.out, browseHelp)
+		return nil
+	case "boards":
+		return b.open(ctx, "", 0)
+	case "next", "prev":
+		if b.board == "" || b.thread != 0 {
+			return errors.New("no board open")
+		}
+		page := b.page + 1
+		if cmd == "prev" {
+			page = b.page - 1
+		}
+		if page < 1 {
+			return errors.New("already on the first page")
+		}
+		b.page = page
+		return b.show(ctx)
+	case "back":
+		if b.thread != 0 {
+			return b.open(ctx, b.board, 0)
+		}
+		return b.open(ctx, "", 0)
+	case "name":
+		b.name = arg
+		return nil
+	case "reply", "r":
+		return b.reply(ctx)
+	}
+
+	if strings.HasPrefix(line, ">>") {
+		quotes := tchan.ParseQuotes(line)
+		if len(quotes) != 1 {
+			return errors.Errorf("not a quote link: %s", line)
+		}
+		q := quotes[0]
+		if !q.CrossBoard() {
+			if b.board == "" {
+				return errors.New("no board open")
+			}
+			q.Board = b.board
+		}
+		return b.open(ctx, q.Board, q.PostID)
+	}
+	if id, err := strconv.ParseInt(line, 10, 64); err == nil {
+		if b.board == "" {
+			return errors.New("no board open")
+		}
+		return b.open(ctx, b.board, id)
+	}
+	parts := strings.Split(strings.Trim(line, "/"), "/")
+	switch len(parts) {
+	case 1:
+		return b.open(ctx, parts[0], 0)
+	case 2:
+		if id, err := strconv.ParseInt(parts[1], 10, 64); err == nil {
+			return b.open(ctx, parts[0], id)
+		}
+	}
+	return errors.Errorf("unknown command: %s (try help)", line)
+}
+
+// open shows a board or thread, staying at the current location on failure.
+func (b *Browser) open(ctx context.Context, board string, postID int64) error {
+	prevBoard, prevPage, prevThread := b.board, b.page, b.thread
+	b.board, b.page, b.thread = board, 1, postID
+	if err := b.show(ctx); err != nil {
+		b.board, b.page, b.thread = prevBoard, prevPage, prevThread
+		return err
+	}
+	return nil
+}
+
+// show displays the current location.
+func (b *Browser) show(ctx context.Context) error {
+	switch {
+	case b.thread != 0:
+		thr, err := b.remote.Thread(ctx, b.board, b.thread)
+		if err != nil {
+			return err
+		}
+		// Quote links may lead to any post in the thread
+		b.thread = thr.ID()
+		WriteThread(b.out, thr)
+	case b.board != "":
+		overview, err := b.remote.Board(ctx, b.board, b.page)
+		if err != nil {
+			return err
+		}
+		WriteBoard(b.out, overview)
+	default:
+		boards, err := b.remote.Boards(ctx)
+		if err != nil {
+			return err
+		}
+		WriteBoards(b.out, boards)
+	}
+	return nil
+}
+
+func (b *Browser) reply(ctx context.Context) error {
+	if b.thread == 0 {
+		return errors.New("no thread open")
+	}
+	content, err := b.Edit()
+	if err != nil {
+		return err
+	}
+	if strings.TrimSpace(content) == "" {
+		fmt.Fprintln(b.out, "empty reply, not posted")
+		return nil
+	}
+
+	thr, err := b.remote.Reply(ctx, b.board, b.thread, b.name, content)
+	if err != nil {
+		return err
+	}
+	WriteThread(b.out, thr)
+	return nil
+}
+
+// EditPost lets the user write a post in $EDITOR, falling back to vi.
+func EditPost() (string, error) {
+	f, err := ioutil.TempFile("", "termchan-*.txt")
+	if err != nil {
+		return "", errors.Wrap(err, "failed to create file for editing")
+	}
+	defer os.Remove(f.Name())
+	f.Close()
+
+	editor := os.Getenv("EDITOR")
+	if editor == "" {
+		editor = "vi"
+	}
+	// Allows for editors with arguments, e.g. "emacs -nw"
+	args := append(strings.Fields(editor), f.Name())
+	cmd := exec.Command(args[0], args[1:]...)
+	cmd.Stdin, cmd.Stdout, cmd.Stderr = os.Stdin, os.Stdout, os.Stderr
+	if err := cmd.Run(); err != nil {
+		return "", errors.Wrapf(err, "editor %s failed", editor)
+	}
+
+	content, err := ioutil.ReadFile(f.Name())
+	if err != nil {
+		return "", errors.Wrap(err, "failed to read post")
+	}
+	return string(content), nil
+}
@@ -0,0 +1,77 @@
+package client
+
+import (
+	"bytes"
+	"context"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/fgahr/termchan/tchan"
+	"github.com/fgahr/termchan/tchan/config"
+	"github.com/fgahr/termchan/tchan/http"
+)
+
+func newTestRemote(t *testing.T, boards ...tchan.Board) *Remote {
+	conf := config.Defaults()
+	if err := conf.SetWorkingDirectory(t.TempDir()); err != nil {
+		t.Fatal(err)
+	}
+	conf.Database.Driver = config.Memory
+	conf.Boards = boards
+
+	s, err := http.NewServer(&conf)
+	if err != nil {
+		t.Fatal(err)
+	}
+	hs := httptest.NewServer(s.Handler())
+	t.Cleanup(hs.Close)
+
+	remote, err := Dial(hs.URL)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return remote
+}
+
+func TestBrowse(t *testing.T) {
+	ctx := context.Background()
+	remote := newTestRemote(t, tchan.Board{Name: "b", Descr: "Random"}, tchan.Board{Name: "g", Descr: "Tech"})
+	if _, err := remote.CreateThread(ctx, "b", "hello", "", "first"); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := remote.CreateThread(ctx, "g", "elsewhere", "", "see >>>/b/1"); err != nil {
+		t.Fatal(err)
+	}
+
+	in := strings.NewReader("g\n1\n>>>/b/1\nname me\nreply\nbogus\nquit\n")
+	out := bytes.Buffer{}
+	b := NewBrowser(remote, in, &out)
+	b.Edit = func() (string, error) { return ">>1 hi there", nil }
+	if err := b.Run(ctx); err != nil {
+		t.Fatal(err)
+	}
+
+	for _, expected := range []string{
+		"/b/ - Random\n/g/ - Tech\n",
+		"/g/1 elsewhere (0 replies)",
+		"/g/1> ",
+		// Followed the link to the other board, then replied
+		"/b/1> ",
+		"[2] me wrote at",
+		"Replies: >>2",
+		"error: no such board: /bogus/",
+	} {
+		if !strings.Contains(out.String(), expected) {
+			t.Errorf("expected output to contain %q, got:\n%s", expected, out.String())
+		}
+	}
+
+	thr, err := remote.Thread(ctx, "b", 1)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(thr.Posts) != 2 || thr.Posts[1].Author != "me" || thr.Posts[1].Content != ">>1 hi there" {
+		t.Errorf("expected reply to be posted, got %+v", thr.Posts)
+	}
+}
@@ -0,0 +1,136 @@
+// Package client talks to a termchan server through its JSON API.
+package client
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net"
+	"net/http"
+	"net/url"
+	"strings"
+
+	"github.com/pkg/errors"
+
+	"github.com/fgahr/termchan/tchan"
+)
+
+// Remote is a termchan server reached over HTTP.
+type Remote struct {
+	base string
+	hc   *http.Client
+}
+
+// Dial prepares a connection to a server, given either by URL or as
+// unix:/path/to/socket. A URL without scheme is assumed to use HTTP.
+func Dial(addr string) (*Remote, error) {
+	if strings.HasPrefix(addr, "unix:") {
+		socket := strings.TrimPrefix(addr, "unix:")
+		dialer := net.Dialer{}
+		transport := &http.Transport{
+			DialContext: func(ctx context.Context, _, _ string) (net.Conn, error) {
+				return dialer.DialContext(ctx, "unix", socket)
+			},
+		}
+		// The host name is only used in links, e.g. on the welcome page
+		return &Remote{base: "http://localhost", hc: &http.Client{Transport: transport}}, nil
+	}
+
+	if !strings.Contains(addr, "://") {
+		addr = "http://" + addr
+	}
+	u, err := url.Parse(addr)
+	if err != nil || u.Host == "" {
+		return nil, errors.Errorf("invalid server address: %s", addr)
+	}
+	return &Remote{base: strings.TrimSuffix(u.String(), "/"), hc: http.DefaultClient}, nil
+}
+
+// Boards fetches the boards listed on the welcome page.
+func (r *Remote) Boards(ctx context.Context) ([]tchan.Board, error) {
+	var boards []tchan.Board
+	err := r.do(ctx, "GET", "/", nil, &boards)
+	return boards, err
+}
+
+// Board fetches a page of a board's active threads, the first page for 0.
+func (r *Remote) Board(ctx context.Context, boardName string, page int) (tchan.BoardOverview, error) {
+	params := url.Values{}
+	if page > 1 {
+		params.Set("page", fmt.Sprint(page))
+	}
+	b := tchan.BoardOverview{}
+	err := r.do(ctx, "GET", "/"+boardName, params, &b)
+	return b, err
+}
+
+// Thread fetches the thread with the given post in it.
+func (r *Remote) Thread(ctx context.Context, boardName string, postID int64) (tchan.Thread, error) {
+	thr := tchan.Thread{}
+	err := r.do(ctx, "GET", fmt.Sprintf("/%s/%d", boardName, postID), nil, &thr)
+	return thr, err
+}
+
+// CreateThread posts a new thread, returning it as created.
+func (r *Remote) CreateThread(ctx context.Context, boardName string, topic string, name string, content string) (tchan.Thread, error) {
+	thr := tchan.Thread{}
+	params := url.Values{"topic": {topic}, "name": {name}, "content": {content}}
+	err := r.do(ctx, "POST", "/"+boardName, params, &thr)
+	return thr, err
+}
+
+// Reply posts a reply to the thread with the given post in it, returning the
+// thread including the reply.
+func (r *Remote) Reply(ctx context.Context, boardName string, postID int64, name string, content string) (tchan.Thread, error) {
+	thr := tchan.Thread{}
+	params := url.Values{"name": {name}, "content": {content}}
+	err := r.do(ctx, "POST", fmt.Sprintf("/%s/%d", boardName, postID), params, &thr)
+	return thr, err
+}
+
+// do sends a request, decoding the JSON response into v. Errors reported by
+// the server are returned as such.
+func (r *Remote) do(ctx context.Context, method string, path string, params url.Values, v interface{}) error {
+	if params == nil {
+		params = url.Values{}
+	}
+	params.Set("format", "json")
+
+	var req *http.Request
+	var err error
+	if method == "POST" {
+		req, err = http.NewRequestWithContext(ctx, method, r.base+path, strings.NewReader(params.Encode()))
+		if err == nil {
+			req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+		}
+	} else {
+		req, err = http.NewRequestWithContext(ctx, method, r.base+path+"?"+params.Encode(), nil)
+	}
+	if err != nil {
+		return errors.Wrap(err, "failed to create request")
+	}
+
+	res, err := r.hc.Do(req)
+	if err != nil {
+		return errors.Wrapf(err, "%s %s failed", method, path)
+	}
+	defer res.Body.Close()
+
+	if res.StatusCode != http.StatusOK {
+		failure := struct {
+			Error string     `json:"error"`
+			Ban   *tchan.Ban `json:"ban"`
+		}{}
+		if err := json.NewDecoder(res.Body).Decode(&failure); err != nil || failure.Error == "" {
+			return errors.Errorf("%s %s: %s", method, path, res.Status)
+		}
+		if failure.Ban != nil && failure.Ban.Reason != "" {
+			failure.Error += ": " + failure.Ban.Reason
+		}
+		return errors.New(failure.Error)
+	}
+	if err := json.NewDecoder(res.Body).Decode(v); err != nil {
+		return errors.Wrapf(err, "%s %s: invalid response", method, path)
+	}
+	return nil
+}
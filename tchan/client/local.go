@@ -0,0 +1,83 @@
+package client
+
+import (
+	"context"
+
+	"github.com/pkg/errors"
+
+	"github.com/fgahr/termchan/tchan"
+	"github.com/fgahr/termchan/tchan/backend"
+	"github.com/fgahr/termchan/tchan/config"
+)
+
+// Conn reads and writes threads, either through a server or directly on the
+// local databases.
+type Conn interface {
+	// Thread fetches the thread with the given post in it.
+	Thread(ctx context.Context, boardName string, postID int64) (tchan.Thread, error)
+	// CreateThread posts a new thread, returning it as created.
+	CreateThread(ctx context.Context, boardName string, topic string, name string, content string) (tchan.Thread, error)
+	// Reply posts a reply to the thread with the given post in it,
+	// returning the thread including the reply.
+	Reply(ctx context.Context, boardName string, postID int64, name string, content string) (tchan.Thread, error)
+}
+
+// Local works on the databases directly. Posts are subject to the boards'
+// filters and size limits, but not to bans or flood protection. Followers of
+// threads on a running server are not notified.
+type Local struct {
+	conf *config.Settings
+	db   backend.DB
+}
+
+// NewLocal works on an initialized backend.
+func NewLocal(conf *config.Settings, db backend.DB) *Local {
+	return &Local{conf: conf, db: db}
+}
+
+func (l *Local) Thread(ctx context.Context, boardName string, postID int64) (tchan.Thread, error) {
+	bc, ok := l.conf.BoardConfig(boardName)
+	if !ok {
+		return tchan.Thread{}, errors.Errorf("no such board: /%s/", boardName)
+	}
+
+	thr := tchan.Thread{Board: bc.Public()}
+	if err := l.db.PopulateThread(ctx, boardName, postID, backend.PostRange{}, &thr, &ok); err != nil {
+		return thr, errors.Wrap(err, "failed to fetch thread")
+	} else if !ok {
+		return thr, errors.Errorf("no such thread: /%s/%d", boardName, postID)
+	}
+	return thr, nil
+}
+
+func (l *Local) CreateThread(ctx context.Context, boardName string, topic string, name string, content string) (tchan.Thread, error) {
+	post, err := backend.PreparePost(l.conf, boardName, name, content)
+	if err != nil {
+		return tchan.Thread{}, err
+	}
+	bc, _ := l.conf.BoardConfig(boardName)
+	topic, rejectedBy := bc.FilterContent(topic)
+	if rejectedBy != nil {
+		return tchan.Thread{}, errors.New("post rejected by content filter")
+	}
+
+	if err := l.db.CreateThread(ctx, boardName, topic, &post); err != nil {
+		return tchan.Thread{}, errors.Wrap(err, "failed to create thread")
+	}
+	return l.Thread(ctx, boardName, post.ID)
+}
+
+func (l *Local) Reply(ctx context.Context, boardName string, postID int64, name string, content string) (tchan.Thread, error) {
+	post, err := backend.PreparePost(l.conf, boardName, name, content)
+	if err != nil {
+		return tchan.Thread{}, err
+	}
+
+	ok := false
+	if err := l.db.AddReply(ctx, boardName, postID, &post, &ok); err != nil {
+		return tchan.Thread{}, errors.Wrapf(err, "cannot reply to /%s/%d", boardName, postID)
+	} else if !ok {
+		return tchan.Thread{}, errors.Errorf("no such thread: /%s/%d", boardName, postID)
+	}
+	return l.Thread(ctx, boardName, postID)
+}
@@ -0,0 +1,59 @@
+package client
+
+import (
+	"context"
+	"testing"
+
+	"github.com/fgahr/termchan/tchan"
+	"github.com/fgahr/termchan/tchan/backend"
+	"github.com/fgahr/termchan/tchan/config"
+)
+
+func TestLocal(t *testing.T) {
+	ctx := context.Background()
+	conf := config.Defaults()
+	conf.Database.Driver = config.Memory
+	filter := tchan.Filter{Pattern: "spam", Reject: true}
+	if err := filter.Compile(); err != nil {
+		t.Fatal(err)
+	}
+	conf.Boards = []tchan.Board{{Name: "b", Filters: []tchan.Filter{filter}}}
+	db, err := backend.New(&conf)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := db.Init(); err != nil {
+		t.Fatal(err)
+	}
+	defer db.Close()
+	local := NewLocal(&conf, db)
+
+	thr, err := local.CreateThread(ctx, "b", "hello", "", "  first\n")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if thr.ID() != 1 || thr.Posts[0].Author != "Anonymous" || thr.Posts[0].Content != "first" {
+		t.Errorf("unexpected thread: %+v", thr)
+	}
+	if thr.Board.Filters != nil {
+		t.Error("expected filters to be left out")
+	}
+
+	thr, err = local.Reply(ctx, "b", 1, "me#secret", ">>1 second")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(thr.Posts) != 2 || thr.Posts[1].Author != "me" || thr.Posts[1].Tripcode == "" {
+		t.Errorf("unexpected reply: %+v", thr.Posts)
+	}
+
+	if _, err := local.Reply(ctx, "b", 1, "", "buy spam"); err == nil {
+		t.Error("expected filtered reply to be rejected")
+	}
+	if _, err := local.Reply(ctx, "b", 3, "", "nothing"); err == nil {
+		t.Error("expected reply to missing thread to fail")
+	}
+	if _, err := local.Thread(ctx, "g", 1); err == nil {
+		t.Error("expected missing board to fail")
+	}
+}
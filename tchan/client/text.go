@@ -0,0 +1,102 @@
+package client
+
+import (
+	"fmt"
+	"io"
+	"strings"
+	"time"
+
+	"github.com/fgahr/termchan/tchan"
+)
+
+// Plain-text rendering, laid out like the default ANSI templates.
+
+var (
+	singleSeparator = strings.Repeat("-", 80)
+	doubleSeparator = strings.Repeat("=", 80)
+)
+
+func plural(n int, one string, many string) string {
+	if n == 1 {
+		return fmt.Sprintf("%d %s", n, one)
+	}
+	return fmt.Sprintf("%d %s", n, many)
+}
+
+// WriteBoards writes a listing of boards.
+func WriteBoards(w io.Writer, boards []tchan.Board) {
+	for _, b := range boards {
+		fmt.Fprintf(w, "/%s/ - %s\n", b.Name, b.Descr)
+	}
+}
+
+// WriteBoard writes a page of a board's threads, showing each OP.
+func WriteBoard(w io.Writer, b tchan.BoardOverview) {
+	fmt.Fprintf(w, "/%s/ - %s\n%s\n", b.Name, b.Descr, doubleSeparator)
+	for _, t := range b.Threads {
+		fmt.Fprintf(w, "/%s/%d %s%s (%s) updated %s\n%s\n", b.Name, t.ID(), t.Topic,
+			flags(t.Sticky, t.Locked, false), plural(t.NumReplies, "reply", "replies"),
+			t.Active.Local().Format(time.ANSIC), singleSeparator)
+		WritePost(w, t.OP)
+		fmt.Fprintln(w, doubleSeparator)
+	}
+	fmt.Fprint(w, plural(len(b.Threads), "thread", "threads"))
+	if b.NumPages > 1 {
+		fmt.Fprintf(w, ", page %d of %d", b.Page, b.NumPages)
+	}
+	fmt.Fprintln(w)
+}
+
+// WriteThread writes a thread with all posts fetched.
+func WriteThread(w io.Writer, thr tchan.Thread) {
+	fmt.Fprintf(w, "/%s/%d %s%s\n%s\n", thr.Board.Name, thr.ID(), thr.Topic,
+		flags(thr.Sticky, thr.Locked, thr.Archived), doubleSeparator)
+	for i, p := range thr.Posts {
+		WritePost(w, p)
+		fmt.Fprintln(w, singleSeparator)
+		if i == 0 && thr.OmittedBefore > 0 {
+			fmt.Fprintf(w, "%s omitted\n%s\n", plural(thr.OmittedBefore, "post", "posts"), singleSeparator)
+		}
+	}
+	if thr.OmittedAfter > 0 {
+		fmt.Fprintf(w, "%s omitted\n%s\n", plural(thr.OmittedAfter, "post", "posts"), singleSeparator)
+	}
+	fmt.Fprintln(w, plural(thr.NumReplies(), "reply", "replies"))
+}
+
+// WritePost writes a single post.
+func WritePost(w io.Writer, p tchan.Post) {
+	if p.Deleted {
+		fmt.Fprintf(w, "[%d] [deleted]\n", p.ID)
+	} else {
+		fmt.Fprintf(w, "[%d] %s", p.ID, p.Author)
+		if p.Tripcode != "" {
+			fmt.Fprintf(w, " %s", p.Tripcode)
+		}
+		fmt.Fprintf(w, " wrote at %s\n", p.Timestamp.Local().Format(time.ANSIC))
+	}
+	if len(p.QuotedBy) > 0 {
+		fmt.Fprint(w, "Replies:")
+		for _, id := range p.QuotedBy {
+			fmt.Fprintf(w, " >>%d", id)
+		}
+		fmt.Fprintln(w)
+	}
+	if !p.Deleted {
+		fmt.Fprintf(w, "\n%s\n", p.Content)
+	}
+}
+
+func flags(sticky bool, locked bool, archived bool) string {
+	s := ""
+	if sticky {
+		s += " [sticky]"
+	}
+	if locked {
+		s += " [locked]"
+	}
+	if archived {
+		s += " [archived]"
+	}
+	return s
+}
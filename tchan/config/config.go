@@ -2,13 +2,17 @@ package config
 
 import (
 	"bytes"
+	"crypto/subtle"
 	"encoding/json"
 	"io"
+	"net"
 	"os"
 	"path/filepath"
 	"regexp"
 	"sort"
+	"strconv"
 	"strings"
+	"time"
 
 	"github.com/pkg/errors"
 
@@ -18,9 +22,46 @@ import (
 
 // Settings deals with all variable and optional aspects of termchan.
 type Settings struct {
-	Transport Transport     `json:"transport"`
-	wd        string        `json:"-"`
-	Boards    []tchan.Board `json:"boards"`
+	Transport  Transport     `json:"transport"`
+	Gopher     Gopher        `json:"gopher"`
+	Database   Database      `json:"database"`
+	wd         string        `json:"-"`
+	Boards     []tchan.Board `json:"boards"`
+	Moderators []Moderator   `json:"moderators,omitempty"`
+	// Secret used for secure tripcodes, these are disabled if empty
+	TripcodeSecret string `json:"tripcodeSecret,omitempty"`
+	// Time limits in seconds, defaults are used unless positive
+	RequestTimeoutSecs  int `json:"requestTimeout,omitempty"`
+	ShutdownTimeoutSecs int `json:"shutdownTimeout,omitempty"`
+}
+
+const (
+	requestTimeoutDefault  = 30 * time.Second
+	shutdownTimeoutDefault = 10 * time.Second
+)
+
+// RequestTimeout returns the time after which the database queries of a
+// request are cancelled.
+func (s *Settings) RequestTimeout() time.Duration {
+	if s.RequestTimeoutSecs > 0 {
+		return time.Duration(s.RequestTimeoutSecs) * time.Second
+	}
+	return requestTimeoutDefault
+}
+
+// ShutdownTimeout returns the time given to requests in progress to complete
+// when the server is stopped.
+func (s *Settings) ShutdownTimeout() time.Duration {
+	if s.ShutdownTimeoutSecs > 0 {
+		return time.Duration(s.ShutdownTimeoutSecs) * time.Second
+	}
+	return shutdownTimeoutDefault
+}
+
+// Moderator contains the credentials of a moderator.
+type Moderator struct {
+	Name  string `json:"name"`
+	Token string `json:"token"`
 }
 
 type Protocol int
@@ -73,6 +114,27 @@ func (p *Protocol) UnmarshalJSON(b []byte) error {
 type Transport struct {
 	Protocol Protocol `json:"protocol"`
 	Socket   string   `json:"socket"`
+	// Addresses or CIDR ranges of reverse proxies whose X-Forwarded-For header
+	// is trusted. Peers on a Unix socket are always trusted.
+	TrustedProxies []string `json:"trustedProxies,omitempty"`
+}
+
+// TrustsProxy tells whether the X-Forwarded-For header set by the given
+// address is to be trusted.
+func (t Transport) TrustsProxy(addr net.IP) bool {
+	if addr == nil {
+		return false
+	}
+	for _, p := range t.TrustedProxies {
+		if _, cidr, err := net.ParseCIDR(p); err == nil {
+			if cidr.Contains(addr) {
+				return true
+			}
+		} else if ip := net.ParseIP(p); ip != nil && ip.Equal(addr) {
+			return true
+		}
+	}
+	return false
 }
 
 func (t Transport) String() string {
@@ -86,6 +148,68 @@ func (t Transport) String() string {
 	}
 }
 
+// Gopher configures the Gopher frontend.
+type Gopher struct {
+	// TCP address to listen on
+	Address string `json:"address"`
+	// Host name and port under which clients reach the server, as given in
+	// menus; the port defaults to the one listened on
+	Hostname string `json:"hostname"`
+	Port     int    `json:"port,omitempty"`
+}
+
+// MenuPort returns the port to be given in menus.
+func (g Gopher) MenuPort() int {
+	if g.Port > 0 {
+		return g.Port
+	}
+	if _, port, err := net.SplitHostPort(g.Address); err == nil {
+		if n, err := strconv.Atoi(port); err == nil {
+			return n
+		}
+	}
+	return 70
+}
+
+// Database drivers, selecting the storage backend.
+const (
+	// SQLite stores boards in files, see the layouts below.
+	SQLite = "sqlite3"
+	// Postgres stores boards in a shared PostgreSQL database, allowing
+	// several termchan processes to serve the same boards.
+	Postgres = "postgres"
+	// Memory keeps all data in memory, to be lost on shutdown.
+	Memory = "memory"
+)
+
+// Database layouts for SQLite.
+const (
+	// PerBoardLayout keeps a file per board in the boards directory.
+	PerBoardLayout = "per-board"
+	// SingleLayout keeps all boards in a single file, distinguished by a
+	// board column, allowing queries across boards.
+	SingleLayout = "single"
+)
+
+// Database selects and configures the storage backend.
+type Database struct {
+	Driver string `json:"driver"`
+	// Connection string, only used by Postgres; see
+	// https://pkg.go.dev/github.com/lib/pq for the supported formats
+	DSN string `json:"dsn,omitempty"`
+	// Layout of SQLite databases, PerBoardLayout unless set
+	Layout string `json:"layout,omitempty"`
+	// Connection pool limits per database, the defaults of database/sql are
+	// kept unless positive
+	MaxOpenConns int `json:"maxOpenConns,omitempty"`
+	MaxIdleConns int `json:"maxIdleConns,omitempty"`
+}
+
+// SingleFile tells whether all boards share a single SQLite database.
+func (d Database) SingleFile() bool {
+	return d.Driver == SQLite && d.Layout == SingleLayout
+}
+
 // Defaults gives a default configuration for termchan.
 func Defaults() Settings {
 	return Settings{
@@ -93,6 +217,13 @@ func Defaults() Settings {
 			Protocol: TCP,
 			Socket:   ":8088",
 		},
+		Gopher: Gopher{
+			Address:  ":7070",
+			Hostname: "localhost",
+		},
+		Database: Database{
+			Driver: SQLite,
+		},
 		wd: "./",
 		Boards: []tchan.Board{
 			{
@@ -126,7 +257,20 @@ func (s *Settings) BoardsDirectory() string {
 	return filepath.Join(s.wd, "boards")
 }
 
-// ReadJSON reads settings from a JSON-encoded source.
+// SingleDatabase returns the path of the database holding all boards when
+// using the single layout.
+func (s *Settings) SingleDatabase() string {
+	return filepath.Join(s.wd, "boards.db")
+}
+
+// ServerDatabase returns the path of the database holding data which is not
+// specific to a board.
+func (s *Settings) ServerDatabase() string {
+	return filepath.Join(s.wd, "server.db")
+}
+
+// ReadJSON reads settings from a JSON-encoded source. Settings missing from
+// the source are reset to their defaults.
 func (s *Settings) ReadJSON(in io.Reader) error {
 	buf := bytes.Buffer{}
 	if _, err := io.Copy(&buf, in); err != nil {
@@ -137,9 +281,38 @@ func (s *Settings) ReadJSON(in io.Reader) error {
 		return nil
 	}
 
+	// Decoding into the current settings would keep those omitted from the
+	// new configuration, e.g. a removed moderator.
+	next := Defaults()
+	next.wd = s.wd
 	dec := json.NewDecoder(&buf)
 	dec.DisallowUnknownFields()
-	return dec.Decode(s)
+	if err := dec.Decode(&next); err != nil {
+		return err
+	}
+
+	// Invalid settings must not replace valid ones
+	switch next.Database.Driver {
+	case SQLite, Memory:
+	case Postgres:
+		if next.Database.DSN == "" {
+			return errors.New("postgres requires a connection string (database.dsn)")
+		}
+	default:
+		return errors.Errorf("unknown database driver: %s", next.Database.Driver)
+	}
+	switch next.Database.Layout {
+	case "", PerBoardLayout, SingleLayout:
+	default:
+		return errors.Errorf("unknown database layout: %s", next.Database.Layout)
+	}
+	for _, b := range next.Boards {
+		if err := b.CompileFilters(); err != nil {
+			return err
+		}
+	}
+	*s = next
+	return nil
 }
 
 // WriteJSON writes settings as JSON to a writer.
@@ -173,6 +346,20 @@ func (s *Settings) ReadFromFile() error {
 	return nil
 }
 
+// Moderator checks moderator credentials, returning the moderator if they
+// are valid.
+func (s *Settings) Moderator(name string, token string) (Moderator, bool) {
+	for _, m := range s.Moderators {
+		if m.Name != name || m.Token == "" {
+			continue
+		}
+		if subtle.ConstantTimeCompare([]byte(m.Token), []byte(token)) == 1 {
+			return m, true
+		}
+	}
+	return Moderator{}, false
+}
+
 // BoardConfig returns the configuration for a board.
 func (s *Settings) BoardConfig(boardName string) (tchan.Board, bool) {
 	n := len(s.Boards)
@@ -186,3 +373,42 @@ func (s *Settings) BoardConfig(boardName string) (tchan.Board, bool) {
 	b := s.Boards[idx]
 	return s.Boards[idx], b.Name == boardName
 }
+
+// BoardChanges lists the names of boards which differ between two
+// configurations.
+type BoardChanges struct {
+	Added   []string
+	Removed []string
+	Changed []string
+}
+
+// DiffBoards compares the boards of two configurations.
+func DiffBoards(prev []tchan.Board, next []tchan.Board) BoardChanges {
+	c := BoardChanges{}
+	old := make(map[string]tchan.Board)
+	for _, b := range prev {
+		old[b.Name] = b
+	}
+	for _, b := range next {
+		p, ok := old[b.Name]
+		delete(old, b.Name)
+		if !ok {
+			c.Added = append(c.Added, b.Name)
+		} else if !sameBoard(p, b) {
+			c.Changed = append(c.Changed, b.Name)
+		}
+	}
+	for name := range old {
+		c.Removed = append(c.Removed, name)
+	}
+	sort.Strings(c.Removed)
+	return c
+}
+
+// sameBoard compares the configured settings of two boards, disregarding
+// compiled filters.
+func sameBoard(a tchan.Board, b tchan.Board) bool {
+	ja, erra := json.Marshal(a)
+	jb, errb := json.Marshal(b)
+	return erra == nil && errb == nil && bytes.Equal(ja, jb)
+}
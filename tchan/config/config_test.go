@@ -1,6 +1,8 @@
 package config
 
 import (
+	"fmt"
+	"strings"
 	"testing"
 
 	"github.com/fgahr/termchan/tchan"
@@ -23,3 +25,47 @@ func TestBoardExists(t *testing.T) {
 		t.Errorf("expected /c/ to not exist but it did")
 	}
 }
+
+func TestReadJSONReplaces(t *testing.T) {
+	c := Defaults()
+	err := c.ReadJSON(strings.NewReader(`{
+		"boards": [{"name": "b", "description": "Random", "style": "red", "maxThreads": 5}],
+		"moderators": [{"name": "m", "token": "t"}],
+		"tripcodeSecret": "secret"
+	}`))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, ok := c.Moderator("m", "t"); !ok {
+		t.Fatal("expected moderator to be configured")
+	}
+
+	if err := c.ReadJSON(strings.NewReader(`{"boards": [{"name": "b", "description": "Random"}]}`)); err != nil {
+		t.Fatal(err)
+	}
+	if _, ok := c.Moderator("m", "t"); ok {
+		t.Error("expected removed moderator to be revoked")
+	}
+	if c.TripcodeSecret != "" {
+		t.Errorf("expected tripcode secret to be removed, got %q", c.TripcodeSecret)
+	}
+	if b, _ := c.BoardConfig("b"); b.ThreadsMax != 0 {
+		t.Errorf("expected removed board limit to be reset, got %d", b.ThreadsMax)
+	}
+}
+
+func TestDiffBoards(t *testing.T) {
+	prev := confWithBoards("a", "b", "c", "d").Boards
+	next := confWithBoards("b", "c", "d", "e").Boards
+	next[1].ThreadsMax = 5
+	next[2].Filters = []tchan.Filter{{Pattern: "spam"}}
+	if err := next[2].CompileFilters(); err != nil {
+		t.Fatal(err)
+	}
+	prev[3].Filters = []tchan.Filter{{Pattern: "spam"}}
+
+	c := DiffBoards(prev, next)
+	if s := fmt.Sprint(c.Added, c.Removed, c.Changed); s != "[e] [a] [c]" {
+		t.Errorf("expected /e/ added, /a/ removed and /c/ changed, got %s", s)
+	}
+}
@@ -0,0 +1,87 @@
+package tchan
+
+import (
+	"regexp"
+
+	"github.com/pkg/errors"
+)
+
+// Filter alters or rejects posts whose content matches its pattern. Literal
+// patterns are matched regardless of case, regular expressions as given.
+type Filter struct {
+	Pattern string `json:"pattern"`
+	Regex   bool   `json:"regex,omitempty"`
+	// Matches are replaced unless the post is rejected altogether
+	Replacement string `json:"replacement,omitempty"`
+	Reject      bool   `json:"reject,omitempty"`
+	re          *regexp.Regexp
+}
+
+// Compile prepares the filter for use, failing on invalid patterns.
+func (f *Filter) Compile() error {
+	if f.Pattern == "" {
+		return errors.New("empty filter pattern")
+	}
+
+	expr := f.Pattern
+	if !f.Regex {
+		expr = "(?i)" + regexp.QuoteMeta(f.Pattern)
+	}
+	re, err := regexp.Compile(expr)
+	if err != nil {
+		return errors.Wrapf(err, "invalid filter pattern %s", f.Pattern)
+	}
+	f.re = re
+	return nil
+}
+
+// Matches tells whether the filter applies to the content. Filters which
+// failed to compile never match.
+func (f *Filter) Matches(content string) bool {
+	if f.re == nil && f.Compile() != nil {
+		return false
+	}
+	return f.re.MatchString(content)
+}
+
+// Apply returns the content with all matches replaced.
+func (f *Filter) Apply(content string) string {
+	if !f.Matches(content) {
+		return content
+	}
+	return f.re.ReplaceAllLiteralString(content, f.Replacement)
+}
+
+// Public returns the board without the settings which are not to be shown
+// to clients. Filters would tell spammers exactly what to avoid.
+func (b Board) Public() Board {
+	b.Filters = nil
+	return b
+}
+
+// CompileFilters prepares all filters of the board for use.
+func (b Board) CompileFilters() error {
+	for i := range b.Filters {
+		if err := b.Filters[i].Compile(); err != nil {
+			return errors.Wrapf(err, "board %s", b.Name)
+		}
+	}
+	return nil
+}
+
+// FilterContent applies the board's filters to the content in order. If a
+// rejecting filter matches, it is returned alongside the unaltered content.
+func (b Board) FilterContent(content string) (string, *Filter) {
+	filtered := content
+	for i := range b.Filters {
+		f := &b.Filters[i]
+		if !f.Matches(filtered) {
+			continue
+		}
+		if f.Reject {
+			return content, f
+		}
+		filtered = f.Apply(filtered)
+	}
+	return filtered, nil
+}
@@ -0,0 +1,33 @@
+package tchan
+
+import (
+	"testing"
+)
+
+func TestFilterContent(t *testing.T) {
+	b := Board{Filters: []Filter{
+		{Pattern: "darn", Replacement: "gosh"},
+		{Pattern: `\bbuy (now|cheap)\b`, Regex: true, Reject: true},
+		{Pattern: "a.b"},
+	}}
+	if err := b.CompileFilters(); err != nil {
+		t.Fatal(err)
+	}
+
+	if s, f := b.FilterContent("Darn it, darn it all. aXb a.b"); f != nil || s != "gosh it, gosh it all. aXb " {
+		t.Errorf("unexpected result of replacement: %q, %v", s, f)
+	}
+	if _, f := b.FilterContent("darn, buy cheap watches"); f != &b.Filters[1] {
+		t.Errorf("expected post to be rejected, got %v", f)
+	}
+	if _, f := b.FilterContent("Buy cheap watches"); f != nil {
+		t.Errorf("expected regex filter to be case-sensitive, got %v", f)
+	}
+}
+
+func TestCompileFiltersInvalid(t *testing.T) {
+	b := Board{Name: "b", Filters: []Filter{{Pattern: "(", Regex: true}}}
+	if err := b.CompileFilters(); err == nil {
+		t.Error("expected invalid pattern to be rejected")
+	}
+}
@@ -0,0 +1,37 @@
+package gopher
+
+import (
+	"log"
+
+	"github.com/pkg/errors"
+
+	"github.com/fgahr/termchan/tchan"
+	"github.com/fgahr/termchan/tchan/backend"
+)
+
+// preparePost checks a post submitted through a search item. Clients cannot
+// give a name, so posts are anonymous. Bans and flood protection apply the
+// same as for the HTTP server.
+func (req *request) preparePost(boardName string, query string, newThread bool) (tchan.Post, error) {
+	post, err := backend.PreparePost(req.s.conf, boardName, "", query)
+	if err != nil {
+		return post, err
+	}
+	post.AuthorIP = req.clientIP
+	if req.clientIP == "" {
+		// Not attributable, neither bans nor limits could be applied
+		return post, errors.New("posting not possible over this connection")
+	}
+
+	bc, _ := req.s.conf.BoardConfig(boardName)
+	if err := backend.CheckPostAllowed(req.ctx, req.s.db, bc, req.clientIP, post.Content, newThread); err != nil {
+		switch errors.Cause(err).(type) {
+		case *backend.BanRejection, *backend.LimitRejection:
+			log.Printf("rejected post by %s on /%s/: %v", req.clientIP, boardName, err)
+			return post, err
+		}
+		log.Println(err)
+		return post, errors.New("failed to check whether posting is allowed")
+	}
+	return post, nil
+}
@@ -0,0 +1,427 @@
+// Package gopher serves boards and threads over the Gopher protocol (RFC 1436).
+package gopher
+
+import (
+	"bufio"
+	"context"
+	"fmt"
+	"io"
+	"log"
+	"net"
+	"net/http"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/pkg/errors"
+
+	"github.com/fgahr/termchan/tchan"
+	"github.com/fgahr/termchan/tchan/backend"
+	"github.com/fgahr/termchan/tchan/config"
+	"github.com/fgahr/termchan/tchan/output/ansi"
+)
+
+// Longest selector, including a query, accepted from clients
+const maxRequestBytes = 8 << 10
+
+// Server answers Gopher requests from the same boards as the HTTP server.
+type Server struct {
+	conf     *config.Settings
+	db       backend.DB
+	confLock *sync.RWMutex
+	ansiSet  ansi.TemplateSet
+	// Guards the listener, which is set once serving
+	lock     sync.Mutex
+	listener net.Listener
+	// Connections in progress
+	conns sync.WaitGroup
+	// Closed once the server has shut down
+	stopped  chan struct{}
+	stopOnce sync.Once
+}
+
+// NewServer creates a server with its own backend.
+func NewServer(conf *config.Settings) (*Server, error) {
+	db, err := backend.New(conf)
+	if err != nil {
+		return nil, err
+	}
+	if err := db.Init(); err != nil {
+		return nil, errors.Wrap(err, "backend setup failed")
+	}
+
+	s := &Server{conf: conf, db: db, confLock: new(sync.RWMutex), stopped: make(chan struct{})}
+	if err := s.ReloadConfig(); err != nil {
+		return nil, err
+	}
+	return s, nil
+}
+
+// ReloadConfig forces the server to reload its configuration and templates.
+func (s *Server) ReloadConfig() error {
+	s.confLock.Lock()
+	defer s.confLock.Unlock()
+
+	log.Println("loading configuration")
+	if err := s.conf.ReadFromFile(); err != nil {
+		return err
+	}
+	log.Println("reading templates")
+	if err := s.ansiSet.Read(s.conf.TemplateDirectory()); err != nil {
+		return errors.Wrap(err, "reading ansi templates failed")
+	}
+	return s.db.Refresh()
+}
+
+// ListenAndServe listens on the configured address until stopped.
+func (s *Server) ListenAndServe() error {
+	listener, err := net.Listen("tcp", s.conf.Gopher.Address)
+	if err != nil {
+		return errors.Wrapf(err, "unable to establish listener on %s", s.conf.Gopher.Address)
+	}
+	log.Printf("serving Gopher on %s", s.conf.Gopher.Address)
+	return s.Serve(listener)
+}
+
+// Serve answers requests on the listener until stopped.
+func (s *Server) Serve(listener net.Listener) error {
+	s.lock.Lock()
+	s.listener = listener
+	s.lock.Unlock()
+
+	for {
+		conn, err := listener.Accept()
+		if err != nil {
+			select {
+			case <-s.stopped:
+				// Requests in progress are awaited by Stop
+				return nil
+			default:
+				return errors.Wrap(err, "failed to accept connection")
+			}
+		}
+		s.conns.Add(1)
+		go func() {
+			defer s.conns.Done()
+			s.handle(conn)
+		}()
+	}
+}
+
+// Stop causes the server to stop listening. Requests in progress are given
+// the configured shutdown timeout to complete.
+func (s *Server) Stop() error {
+	s.lock.Lock()
+	listener := s.listener
+	s.lock.Unlock()
+	if listener == nil {
+		return errors.New("not listening")
+	}
+
+	s.stopOnce.Do(func() { close(s.stopped) })
+	err := listener.Close()
+
+	done := make(chan struct{})
+	go func() {
+		s.conns.Wait()
+		close(done)
+	}()
+	select {
+	case <-done:
+	case <-time.After(s.conf.ShutdownTimeout()):
+		log.Println("shutdown timed out, abandoning remaining requests")
+	}
+	return err
+}
+
+// handle answers a single request, which consists of a selector and, for
+// searches, a query separated by a tab.
+func (s *Server) handle(conn net.Conn) {
+	defer conn.Close()
+
+	s.confLock.RLock()
+	timeout := s.conf.RequestTimeout()
+	s.confLock.RUnlock()
+	conn.SetDeadline(time.Now().Add(timeout))
+
+	scanner := bufio.NewScanner(conn)
+	scanner.Buffer(make([]byte, 0, 512), maxRequestBytes)
+	if !scanner.Scan() {
+		if err := scanner.Err(); err != nil {
+			log.Println(errors.Wrap(err, "failed to read gopher request"))
+		}
+		return
+	}
+	selector, query := scanner.Text(), ""
+	if i := strings.IndexByte(selector, '\t'); i >= 0 {
+		selector, query = selector[:i], selector[i+1:]
+		// Gopher+ clients may append further fields
+		if j := strings.IndexByte(query, '\t'); j >= 0 {
+			query = query[:j]
+		}
+	}
+	ip := ""
+	if addr, ok := conn.RemoteAddr().(*net.TCPAddr); ok {
+		ip = addr.IP.String()
+	}
+
+	s.confLock.RLock()
+	defer s.confLock.RUnlock()
+	ctx, cancel := context.WithTimeout(context.Background(), timeout)
+	defer cancel()
+	req := &request{s: s, ctx: ctx, out: bufio.NewWriter(conn), clientIP: ip}
+	req.route(strings.TrimSpace(selector), query)
+	if err := req.out.Flush(); err != nil {
+		log.Println(errors.Wrap(err, "failed to send gopher response"))
+	}
+}
+
+// request is a single request in progress. Responses are written as they are
+// rendered.
+type request struct {
+	s        *Server
+	ctx      context.Context
+	out      *bufio.Writer
+	clientIP string
+}
+
+// Selectors:
+//
+//	(empty) or /        menu of all boards
+//	/g                  menu of the first page of a board's threads
+//	/g/page/2           menu of further pages
+//	/g/42               text of the thread with post 42 in it
+//	/g/new              creates a thread, with the content given as query
+//	/g/42/reply         replies to a thread, with the content given as query
+func (req *request) route(selector string, query string) {
+	parts := strings.Split(strings.Trim(selector, "/"), "/")
+	switch {
+	case parts[0] == "":
+		req.welcome()
+	case len(parts) == 1:
+		req.board(parts[0], 1)
+	case len(parts) == 2 && parts[1] == "new":
+		req.createThread(parts[0], query)
+	case len(parts) == 2:
+		if id, err := strconv.ParseInt(parts[1], 10, 64); err == nil {
+			req.thread(parts[0], id)
+			return
+		}
+		req.error(errors.Errorf("no such selector: %s", selector))
+	case len(parts) == 3 && parts[1] == "page":
+		if page, err := strconv.Atoi(parts[2]); err == nil && page > 0 {
+			req.board(parts[0], page)
+			return
+		}
+		req.error(errors.Errorf("no such selector: %s", selector))
+	case len(parts) == 3 && parts[2] == "reply":
+		if id, err := strconv.ParseInt(parts[1], 10, 64); err == nil {
+			req.reply(parts[0], id, query)
+			return
+		}
+		req.error(errors.Errorf("no such selector: %s", selector))
+	default:
+		req.error(errors.Errorf("no such selector: %s", selector))
+	}
+}
+
+func (req *request) welcome() {
+	req.info("termchan")
+	req.info("")
+	for _, b := range req.s.conf.Boards {
+		req.item('1', fmt.Sprintf("/%s/ - %s", b.Name, b.Descr), "/"+b.Name)
+	}
+	req.end()
+}
+
+func (req *request) board(boardName string, page int) {
+	bc, ok := req.s.conf.BoardConfig(boardName)
+	if !ok {
+		req.error(errors.Errorf("no such board: /%s/", boardName))
+		return
+	}
+
+	overview := tchan.BoardOverview{Board: bc, Page: page}
+	if err := req.s.db.PopulateBoard(req.ctx, boardName, page, &overview, &ok); err != nil {
+		req.fail(err, "failed to fetch board")
+		return
+	} else if !ok {
+		req.error(errors.Errorf("no such page: /%s/ page %d", boardName, page))
+		return
+	}
+
+	req.info(fmt.Sprintf("/%s/ - %s", bc.Name, bc.Descr))
+	req.info("")
+	req.item('7', "Create a new thread", fmt.Sprintf("/%s/new", bc.Name))
+	req.info("")
+	for _, t := range overview.Threads {
+		replies := "replies"
+		if t.NumReplies == 1 {
+			replies = "reply"
+		}
+		title := fmt.Sprintf("/%s/%d", bc.Name, t.ID())
+		if t.Topic != "" {
+			title += " " + t.Topic
+		}
+		req.item('0', fmt.Sprintf("%s (%d %s)", title, t.NumReplies, replies), fmt.Sprintf("/%s/%d", bc.Name, t.ID()))
+		if !t.Locked {
+			req.item('7', fmt.Sprintf("    Reply to /%s/%d", bc.Name, t.ID()), fmt.Sprintf("/%s/%d/reply", bc.Name, t.ID()))
+		}
+	}
+	req.info("")
+	if page > 1 {
+		req.item('1', "Previous page", fmt.Sprintf("/%s/page/%d", bc.Name, page-1))
+	}
+	if page < overview.NumPages {
+		req.item('1', "Next page", fmt.Sprintf("/%s/page/%d", bc.Name, page+1))
+	}
+	req.item('1', "All boards", "/")
+	req.end()
+}
+
+func (req *request) thread(boardName string, postID int64) {
+	bc, ok := req.s.conf.BoardConfig(boardName)
+	if !ok {
+		req.error(errors.Errorf("no such board: /%s/", boardName))
+		return
+	}
+
+	thr := tchan.Thread{Board: bc}
+	if err := req.s.db.PopulateThread(req.ctx, boardName, postID, backend.PostRange{}, &thr, &ok); err != nil {
+		req.fail(err, "failed to fetch thread")
+		return
+	} else if !ok {
+		req.error(errors.Errorf("no such thread: /%s/%d", boardName, postID))
+		return
+	}
+
+	buf := strings.Builder{}
+	if err := req.s.textWriter(&buf).WriteThread(thr); err != nil {
+		req.fail(err, "failed to render thread")
+		return
+	}
+	req.text(ansi.Strip(buf.String()))
+}
+
+func (req *request) createThread(boardName string, content string) {
+	post, err := req.preparePost(boardName, content, true)
+	if err != nil {
+		req.error(err)
+		return
+	}
+	if err := req.s.db.CreateThread(req.ctx, boardName, "", &post); err != nil {
+		req.fail(err, "failed to create thread")
+		return
+	}
+
+	log.Printf("gopher: new thread /%s/%d by %s", boardName, post.ID, req.clientIP)
+	req.info(fmt.Sprintf("Created thread /%s/%d", boardName, post.ID))
+	req.item('0', "Read the thread", fmt.Sprintf("/%s/%d", boardName, post.ID))
+	req.item('1', fmt.Sprintf("Back to /%s/", boardName), "/"+boardName)
+	req.end()
+}
+
+func (req *request) reply(boardName string, postID int64, content string) {
+	post, err := req.preparePost(boardName, content, false)
+	if err != nil {
+		req.error(err)
+		return
+	}
+
+	ok := false
+	err = req.s.db.AddReply(req.ctx, boardName, postID, &post, &ok)
+	switch errors.Cause(err) {
+	case nil:
+	case backend.ErrThreadArchived, backend.ErrThreadLocked:
+		req.error(errors.Wrapf(err, "cannot reply to /%s/%d", boardName, postID))
+		return
+	default:
+		req.fail(err, "failed to persist reply")
+		return
+	}
+	if !ok {
+		req.error(errors.Errorf("no such thread: /%s/%d", boardName, postID))
+		return
+	}
+
+	req.info(fmt.Sprintf("Posted reply >>%d", post.ID))
+	req.item('0', "Read the thread", fmt.Sprintf("/%s/%d", boardName, postID))
+	req.item('1', fmt.Sprintf("Back to /%s/", boardName), "/"+boardName)
+	req.end()
+}
+
+// fail reports an internal error without revealing its details.
+func (req *request) fail(err error, text string) {
+	log.Println(err)
+	if errors.Cause(err) == context.DeadlineExceeded {
+		text = "request timed out"
+	}
+	req.error(errors.New(text))
+}
+
+// error responds with an error item, which clients show in place of a menu
+// or text.
+func (req *request) error(err error) {
+	req.line('3', err.Error(), "", "error.host", 1)
+	req.end()
+}
+
+func (req *request) info(text string) {
+	req.line('i', text, "", "error.host", 1)
+}
+
+func (req *request) item(kind byte, display string, selector string) {
+	g := req.s.conf.Gopher
+	req.line(kind, display, selector, g.Hostname, g.MenuPort())
+}
+
+// Tabs would end the field early, line breaks the whole item
+var fieldReplacer = strings.NewReplacer("\t", " ", "\r\n", " ", "\r", " ", "\n", " ")
+
+func (req *request) line(kind byte, display string, selector string, host string, port int) {
+	display = fieldReplacer.Replace(display)
+	fmt.Fprintf(req.out, "%c%s\t%s\t%s\t%d\r\n", kind, display, selector, host, port)
+}
+
+// text responds with a text item.
+func (req *request) text(s string) {
+	for _, line := range strings.Split(strings.TrimSuffix(s, "\n"), "\n") {
+		// A line consisting of a single dot would end the text early
+		if strings.HasPrefix(line, ".") {
+			line = "." + line
+		}
+		fmt.Fprintf(req.out, "%s\r\n", line)
+	}
+	req.end()
+}
+
+func (req *request) end() {
+	req.out.WriteString(".\r\n")
+}
+
+// textWriter renders through the ANSI templates. Only the host name is taken
+// from the request.
+func (s *Server) textWriter(out io.Writer) *ansi.Writer {
+	host := fmt.Sprintf("%s:%d", s.conf.Gopher.Hostname, s.conf.Gopher.MenuPort())
+	return ansi.NewWriter(&http.Request{Host: host}, &textResponse{out: out}, s.ansiSet)
+}
+
+// textResponse satisfies the ANSI writer's need for an HTTP response.
+type textResponse struct {
+	out    io.Writer
+	header http.Header
+}
+
+func (t *textResponse) Header() http.Header {
+	if t.header == nil {
+		t.header = make(http.Header)
+	}
+	return t.header
+}
+
+func (t *textResponse) Write(b []byte) (int, error) {
+	return t.out.Write(b)
+}
+
+func (t *textResponse) WriteHeader(status int) {}
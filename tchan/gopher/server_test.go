@@ -0,0 +1,93 @@
+package gopher
+
+import (
+	"context"
+	"io/ioutil"
+	"net"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/fgahr/termchan/tchan"
+	"github.com/fgahr/termchan/tchan/config"
+)
+
+func TestGopher(t *testing.T) {
+	conf := config.Defaults()
+	if err := conf.SetWorkingDirectory(t.TempDir()); err != nil {
+		t.Fatal(err)
+	}
+	conf.Database.Driver = config.Memory
+	conf.Boards = []tchan.Board{{Name: "b", Descr: "random"}}
+	conf.Gopher.Hostname = "example.org"
+	conf.Gopher.Port = 70
+
+	s, err := NewServer(&conf)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer s.db.Close()
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	go s.Serve(listener)
+	defer s.Stop()
+
+	get := func(request string) string {
+		conn, err := net.Dial("tcp", listener.Addr().String())
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer conn.Close()
+		if _, err := conn.Write([]byte(request + "\r\n")); err != nil {
+			t.Fatal(err)
+		}
+		res, err := ioutil.ReadAll(conn)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if !strings.HasSuffix(string(res), "\r\n.\r\n") {
+			t.Errorf("%q: response not terminated: %q", request, res)
+		}
+		return string(res)
+	}
+
+	expect := func(res string, want ...string) {
+		t.Helper()
+		for _, w := range want {
+			if !strings.Contains(res, w) {
+				t.Errorf("expected %q in response:\n%s", w, res)
+			}
+		}
+	}
+
+	expect(get(""), "1/b/ - random\t/b\texample.org\t70\r\n")
+	expect(get("/b/new\tfirst \x1b[31mpost\x1b[0m"), "iCreated thread /b/1\t")
+	expect(get("/b/1/reply\t. >>1 second"), "iPosted reply >>2\t")
+	expect(get("/b"),
+		"7Create a new thread\t/b/new\texample.org\t70\r\n",
+		"0/b/1 (1 reply)\t/b/1\texample.org\t70\r\n",
+		"7    Reply to /b/1\t/b/1/reply\t",
+	)
+
+	thread := get("/b/2")
+	expect(thread, "first post", "\r\n.. >>1 second\r\n")
+	if strings.Contains(thread, "\x1b") {
+		t.Errorf("escape sequences in text:\n%q", thread)
+	}
+
+	op := tchan.Post{Author: "Anonymous", Content: "third", Timestamp: time.Now()}
+	if err := s.db.CreateThread(context.Background(), "b", "two\r\n1evil\t/x\thost\t70\nlines", &op); err != nil {
+		t.Fatal(err)
+	}
+	board := get("/b")
+	expect(board, "0/b/3 two 1evil /x host 70 lines (0 replies)\t/b/3\texample.org\t70\r\n")
+	if n := strings.Count(board, "\t/b/3\t"); n != 1 {
+		t.Errorf("expected a single item for the thread, got %d:\n%s", n, board)
+	}
+
+	expect(get("/b/1/reply\t "), "3empty post content\t")
+	expect(get("/b/9"), "3no such thread: /b/9\t")
+	expect(get("/nope"), "3no such board: /nope/\t")
+}
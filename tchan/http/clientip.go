@@ -0,0 +1,48 @@
+package http
+
+import (
+	"net"
+	"net/http"
+	"strings"
+
+	"github.com/fgahr/termchan/tchan/config"
+)
+
+// Used for clients whose address cannot be determined, e.g. when connecting
+// via a Unix socket without a proxy in between. All such clients share their
+// flood limits.
+const unknownClient = "unknown"
+
+// clientIP determines the address of the client making the request. Behind
+// trusted proxies, the X-Forwarded-For header is consulted, skipping over
+// any further trusted proxies from the right.
+func clientIP(r *http.Request, t config.Transport) string {
+	var peer net.IP
+	if host, _, err := net.SplitHostPort(r.RemoteAddr); err == nil {
+		peer = net.ParseIP(host)
+	}
+
+	trusted := t.Protocol == config.Unix || t.TrustsProxy(peer)
+	if !trusted {
+		if peer == nil {
+			return unknownClient
+		}
+		return peer.String()
+	}
+
+	hops := strings.Split(strings.Join(r.Header.Values("X-Forwarded-For"), ","), ",")
+	for i := len(hops) - 1; i >= 0; i-- {
+		ip := net.ParseIP(strings.TrimSpace(hops[i]))
+		if ip == nil {
+			continue
+		}
+		if i == 0 || !t.TrustsProxy(ip) {
+			return ip.String()
+		}
+	}
+
+	if peer == nil {
+		return unknownClient
+	}
+	return peer.String()
+}
@@ -0,0 +1,58 @@
+package http
+
+import (
+	"net/http/httptest"
+	"testing"
+
+	"github.com/fgahr/termchan/tchan/config"
+)
+
+func TestClientIPDirect(t *testing.T) {
+	r := httptest.NewRequest("GET", "/", nil)
+	r.RemoteAddr = "192.0.2.7:4321"
+	r.Header.Set("X-Forwarded-For", "198.51.100.1")
+	tcp := config.Transport{Protocol: config.TCP}
+	if ip := clientIP(r, tcp); ip != "192.0.2.7" {
+		t.Errorf("expected untrusted header to be ignored, got %s", ip)
+	}
+}
+
+func TestClientIPTrustedProxy(t *testing.T) {
+	r := httptest.NewRequest("GET", "/", nil)
+	r.RemoteAddr = "10.0.0.1:4321"
+	r.Header.Set("X-Forwarded-For", "203.0.113.9, 198.51.100.1, 10.0.0.2")
+	tcp := config.Transport{Protocol: config.TCP, TrustedProxies: []string{"10.0.0.0/8"}}
+	if ip := clientIP(r, tcp); ip != "198.51.100.1" {
+		t.Errorf("expected rightmost untrusted hop, got %s", ip)
+	}
+}
+
+func TestClientIPUnixSocket(t *testing.T) {
+	r := httptest.NewRequest("GET", "/", nil)
+	r.RemoteAddr = "@"
+	unix := config.Transport{Protocol: config.Unix}
+	if ip := clientIP(r, unix); ip != unknownClient {
+		t.Errorf("expected unknown client without header, got %s", ip)
+	}
+	r.Header.Set("X-Forwarded-For", "not an address")
+	if ip := clientIP(r, unix); ip != unknownClient {
+		t.Errorf("expected unknown client with malformed header, got %s", ip)
+	}
+	r.Header.Set("X-Forwarded-For", "198.51.100.1")
+	if ip := clientIP(r, unix); ip != "198.51.100.1" {
+		t.Errorf("expected header to be trusted on a Unix socket, got %s", ip)
+	}
+}
+
+func TestClientIPMissingOrMalformedHeader(t *testing.T) {
+	r := httptest.NewRequest("GET", "/", nil)
+	r.RemoteAddr = "10.0.0.1:4321"
+	tcp := config.Transport{Protocol: config.TCP, TrustedProxies: []string{"10.0.0.0/8"}}
+	if ip := clientIP(r, tcp); ip != "10.0.0.1" {
+		t.Errorf("expected proxy address without header, got %s", ip)
+	}
+	r.Header.Set("X-Forwarded-For", "garbage, 10.0.0.300")
+	if ip := clientIP(r, tcp); ip != "10.0.0.1" {
+		t.Errorf("expected proxy address with malformed header, got %s", ip)
+	}
+}
@@ -0,0 +1,261 @@
+package http
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"log"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/pkg/errors"
+
+	"github.com/fgahr/termchan/tchan"
+	"github.com/fgahr/termchan/tchan/backend"
+)
+
+// Interval of comments sent to event stream clients to keep proxies from
+// closing idle connections.
+const keepaliveInterval = 30 * time.Second
+
+// follower streams the replies added to a thread to a client. ANSI clients
+// receive the rendered posts as they are, other formats are sent as
+// server-sent events.
+type follower struct {
+	s       *Server
+	r       *http.Request
+	w       http.ResponseWriter
+	flusher http.Flusher
+	sub     *subscription
+	board   string
+	thread  int64
+	format  string
+	events  bool
+	// ID of the last post sent
+	last int64
+}
+
+func (s *Server) handleFollowThread() http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		var f *follower
+		s.confReader(func(w http.ResponseWriter, r *http.Request) {
+			f = s.startFollowing(w, r)
+		})(w, r)
+		if f == nil {
+			return
+		}
+		// Not subject to the request timeout
+		f.r = r
+		defer s.hub.unsubscribe(f.sub)
+		f.run(r.Context())
+	}
+}
+
+// startFollowing sends the thread as it is, subscribing to replies added
+// after that. Gives nil if the request failed.
+func (s *Server) startFollowing(w http.ResponseWriter, r *http.Request) *follower {
+	rw := s.newRequestWorker(w, r)
+	f := &follower{s: s, r: r, w: w, board: rw.board, format: rw.params.Get("format")}
+	f.events = f.format == "html" || f.format == "json"
+
+	boardConf, ok := s.conf.BoardConfig(rw.board)
+	if !ok {
+		rw.respondNoSuchBoard()
+	}
+	if flusher, canFlush := w.(http.Flusher); canFlush {
+		f.flusher = flusher
+	} else if rw.err == nil {
+		rw.err = errors.New("streaming not supported")
+		rw.respondError(http.StatusInternalServerError)
+	}
+	pr := rw.getPostRange(boardConf)
+	if id := r.Header.Get("Last-Event-ID"); f.events && id != "" {
+		// Resuming an event stream
+		after, err := strconv.ParseInt(id, 10, 64)
+		if err != nil && rw.err == nil {
+			rw.err = errors.Errorf("invalid event ID: %s", id)
+			rw.respondError(http.StatusBadRequest)
+		}
+		pr.After = after
+	}
+
+	thr := tchan.Thread{Board: boardConf}
+	ok = false
+	rw.try(func() error { return s.db.PopulateThread(r.Context(), rw.board, rw.replyID, pr, &thr, &ok) },
+		http.StatusInternalServerError, "failed to fetch thread for following")
+	if !ok {
+		rw.respondNoSuchThread()
+	}
+	if rw.err != nil {
+		return nil
+	}
+
+	f.thread = thr.ID()
+	f.sub = s.hub.subscribe(rw.board, f.thread)
+	if f.sub == nil {
+		rw.err = errors.New("server is shutting down")
+		rw.respondError(http.StatusServiceUnavailable)
+		return nil
+	}
+	// Replies added before subscribing would be missed otherwise
+	for _, p := range thr.Posts {
+		if p.ID > f.last {
+			f.last = p.ID
+		}
+	}
+	more := tchan.Thread{}
+	rw.try(func() error {
+		return s.db.PopulateThread(r.Context(), rw.board, rw.replyID, backend.PostRange{After: f.last}, &more, &ok)
+	}, http.StatusInternalServerError, "failed to fetch thread for following")
+	if rw.err != nil {
+		s.hub.unsubscribe(f.sub)
+		return nil
+	}
+	for _, p := range more.Posts {
+		if p.ID > f.last {
+			thr.Posts = append(thr.Posts, p)
+		}
+	}
+
+	if !f.events {
+		rw.respondThread(thr)
+		if rw.err != nil {
+			s.hub.unsubscribe(f.sub)
+			return nil
+		}
+		f.last = thr.Posts[len(thr.Posts)-1].ID
+		f.flusher.Flush()
+		return f
+	}
+
+	w.Header().Set("Content-Type", "text/event-stream")
+	w.Header().Set("Cache-Control", "no-cache")
+	w.WriteHeader(http.StatusOK)
+	f.last = pr.After
+	for _, p := range thr.Posts {
+		if err := f.send(boardConf, p); err != nil {
+			log.Println(err)
+			s.hub.unsubscribe(f.sub)
+			return nil
+		}
+	}
+	f.flusher.Flush()
+	return f
+}
+
+// run passes new replies on to the client until it disconnects, falls behind
+// or the server shuts down.
+func (f *follower) run(ctx context.Context) {
+	var keepalive <-chan time.Time
+	if f.events {
+		ticker := time.NewTicker(keepaliveInterval)
+		defer ticker.Stop()
+		keepalive = ticker.C
+	}
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case e, open := <-f.sub.events:
+			if !open {
+				if f.sub.lagged {
+					log.Printf("dropped slow follower of /%s/%d", f.board, f.thread)
+				}
+				return
+			}
+			if e.kind != replyEvent {
+				continue
+			}
+			if err := f.sendLocked(e.post); err != nil {
+				log.Println(err)
+				return
+			}
+		case <-keepalive:
+			if _, err := fmt.Fprint(f.w, ": keepalive\n\n"); err != nil {
+				return
+			}
+		}
+		f.flusher.Flush()
+	}
+}
+
+// sendLocked sends a post, reading the board's current configuration.
+func (f *follower) sendLocked(post tchan.Post) error {
+	if post.ID <= f.last {
+		return nil
+	}
+
+	f.s.confLock.RLock()
+	boardConf, ok := f.s.conf.BoardConfig(f.board)
+	var data []byte
+	var err error
+	if ok {
+		data, err = f.render(boardConf, post)
+	}
+	f.s.confLock.RUnlock()
+	if !ok {
+		return errors.Errorf("board /%s/ removed, no longer following", f.board)
+	} else if err != nil {
+		return err
+	}
+	return f.write(post, data)
+}
+
+// send sends a post unless it was sent before. Rendering requires the
+// configuration lock to be held.
+func (f *follower) send(boardConf tchan.Board, post tchan.Post) error {
+	if post.ID <= f.last {
+		return nil
+	}
+
+	data, err := f.render(boardConf, post)
+	if err != nil {
+		return err
+	}
+	return f.write(post, data)
+}
+
+func (f *follower) render(boardConf tchan.Board, post tchan.Post) ([]byte, error) {
+	buf := newBufferWriter()
+	if err := f.s.formatWriter(f.format, f.r, buf).WritePost(boardConf, post); err != nil {
+		return nil, errors.Wrapf(err, "failed to render post /%s/%d", f.board, post.ID)
+	}
+	if !f.events {
+		return buf.Bytes(), nil
+	}
+
+	out := bytes.Buffer{}
+	fmt.Fprintf(&out, "id: %d\nevent: post\n", post.ID)
+	for _, line := range strings.Split(strings.TrimSuffix(buf.String(), "\n"), "\n") {
+		fmt.Fprintf(&out, "data: %s\n", line)
+	}
+	out.WriteString("\n")
+	return out.Bytes(), nil
+}
+
+func (f *follower) write(post tchan.Post, data []byte) error {
+	if _, err := f.w.Write(data); err != nil {
+		return errors.Wrap(err, "failed to send post to follower")
+	}
+	f.last = post.ID
+	return nil
+}
+
+// bufferWriter collects a response in memory.
+type bufferWriter struct {
+	bytes.Buffer
+	header http.Header
+}
+
+func newBufferWriter() *bufferWriter {
+	return &bufferWriter{header: make(http.Header)}
+}
+
+func (b *bufferWriter) Header() http.Header {
+	return b.header
+}
+
+func (b *bufferWriter) WriteHeader(status int) {}
@@ -0,0 +1,248 @@
+package http
+
+import (
+	"context"
+	"log"
+	"sync"
+
+	"github.com/fgahr/termchan/tchan"
+	"github.com/fgahr/termchan/tchan/backend"
+)
+
+// Number of events queued for a subscriber. Subscribers falling further
+// behind, e.g. on a slow connection, are dropped rather than holding up the
+// others.
+const followerQueueLength = 32
+
+type eventKind int
+
+const (
+	replyEvent eventKind = iota
+	threadEvent
+	deleteEvent
+)
+
+// event tells subscribers about a change to a thread.
+type event struct {
+	kind   eventKind
+	board  string
+	thread int64
+	// The new post or the OP of a new thread. Only the ID for deletions.
+	post tchan.Post
+	// Only set for new threads
+	topic string
+}
+
+// threadKey identifies a thread by its OP. Thread 0 stands for the board as a
+// whole.
+type threadKey struct {
+	board  string
+	thread int64
+}
+
+// subscription receives the events of its boards and threads until its
+// channel is closed, either on unsubscribing, on shutdown or because it fell
+// behind.
+type subscription struct {
+	keys   map[threadKey]bool
+	events chan event
+	// Set before the channel is closed if the subscriber fell behind
+	lagged bool
+}
+
+// hub distributes events to subscribers.
+type hub struct {
+	lock   sync.Mutex
+	all    map[*subscription]bool
+	subs   map[threadKey]map[*subscription]bool
+	closed bool
+}
+
+func newHub() *hub {
+	return &hub{
+		all:  make(map[*subscription]bool),
+		subs: make(map[threadKey]map[*subscription]bool),
+	}
+}
+
+// newSubscription registers a subscriber without any boards or threads. Gives
+// nil once the hub is closed.
+func (h *hub) newSubscription() *subscription {
+	h.lock.Lock()
+	defer h.lock.Unlock()
+	if h.closed {
+		return nil
+	}
+
+	sub := &subscription{keys: make(map[threadKey]bool), events: make(chan event, followerQueueLength)}
+	h.all[sub] = true
+	return sub
+}
+
+// subscribe registers a follower of a single thread, identified by its OP.
+// Gives nil once the hub is closed.
+func (h *hub) subscribe(board string, thread int64) *subscription {
+	sub := h.newSubscription()
+	if sub != nil {
+		h.add(sub, board, thread)
+	}
+	return sub
+}
+
+// add subscribes to the events of a thread, or the whole board for thread 0.
+func (h *hub) add(sub *subscription, board string, thread int64) {
+	h.lock.Lock()
+	defer h.lock.Unlock()
+	if !h.all[sub] {
+		return
+	}
+
+	key := threadKey{board, thread}
+	if h.subs[key] == nil {
+		h.subs[key] = make(map[*subscription]bool)
+	}
+	h.subs[key][sub] = true
+	sub.keys[key] = true
+}
+
+// drop stops receiving the events of a thread or board.
+func (h *hub) drop(sub *subscription, board string, thread int64) {
+	h.lock.Lock()
+	defer h.lock.Unlock()
+	h.dropKey(sub, threadKey{board, thread})
+}
+
+// dropKey requires the lock to be held.
+func (h *hub) dropKey(sub *subscription, key threadKey) {
+	delete(sub.keys, key)
+	delete(h.subs[key], sub)
+	if len(h.subs[key]) == 0 {
+		delete(h.subs, key)
+	}
+}
+
+// unsubscribe removes a subscriber unless it was already dropped.
+func (h *hub) unsubscribe(sub *subscription) {
+	h.lock.Lock()
+	defer h.lock.Unlock()
+	h.remove(sub)
+}
+
+// remove drops a subscriber, closing its channel. The lock has to be held.
+func (h *hub) remove(sub *subscription) {
+	if !h.all[sub] {
+		return
+	}
+	for key := range sub.keys {
+		h.dropKey(sub, key)
+	}
+	delete(h.all, sub)
+	close(sub.events)
+}
+
+// active tells whether anyone is subscribed to the board or any of its
+// threads.
+func (h *hub) active(board string) bool {
+	h.lock.Lock()
+	defer h.lock.Unlock()
+	for key := range h.subs {
+		if key.board == board {
+			return true
+		}
+	}
+	return false
+}
+
+// publish passes an event to the subscribers of its thread and board without
+// waiting for them.
+func (h *hub) publish(e event) {
+	h.lock.Lock()
+	defer h.lock.Unlock()
+
+	// Subscribers of both only receive the event once
+	recipients := make(map[*subscription]bool)
+	for sub := range h.subs[threadKey{e.board, e.thread}] {
+		recipients[sub] = true
+	}
+	for sub := range h.subs[threadKey{e.board, 0}] {
+		recipients[sub] = true
+	}
+	for sub := range recipients {
+		select {
+		case sub.events <- e:
+		default:
+			sub.lagged = true
+			h.remove(sub)
+		}
+	}
+}
+
+// close drops all subscribers and refuses new ones.
+func (h *hub) close() {
+	h.lock.Lock()
+	defer h.lock.Unlock()
+	h.closed = true
+	for sub := range h.all {
+		h.remove(sub)
+	}
+}
+
+// publishingDB publishes changes made through it to a hub.
+type publishingDB struct {
+	backend.DB
+	hub *hub
+}
+
+func (p *publishingDB) CreateThread(ctx context.Context, boardName string, topic string, op *tchan.Post) error {
+	if err := p.DB.CreateThread(ctx, boardName, topic, op); err != nil {
+		return err
+	}
+	if thr, ok := p.fetch(ctx, boardName, op.ID, op.ID-1); ok {
+		p.hub.publish(event{kind: threadEvent, board: boardName, thread: op.ID, post: thr.Posts[0], topic: thr.Topic})
+	}
+	return nil
+}
+
+func (p *publishingDB) AddReply(ctx context.Context, boardName string, postID int64, post *tchan.Post, ok *bool) error {
+	if err := p.DB.AddReply(ctx, boardName, postID, post, ok); err != nil || !*ok {
+		return err
+	}
+	// Fetching the reply as stored also gives the thread's OP
+	if thr, found := p.fetch(ctx, boardName, post.ID, post.ID-1); found {
+		for _, reply := range thr.Posts {
+			if reply.ID == post.ID {
+				p.hub.publish(event{kind: replyEvent, board: boardName, thread: thr.ID(), post: reply})
+			}
+		}
+	}
+	return nil
+}
+
+func (p *publishingDB) DeletePost(ctx context.Context, boardName string, postID int64, ok *bool) error {
+	if err := p.DB.DeletePost(ctx, boardName, postID, ok); err != nil || !*ok {
+		return err
+	}
+	if thr, found := p.fetch(ctx, boardName, postID, postID); found {
+		p.hub.publish(event{kind: deleteEvent, board: boardName, thread: thr.ID(), post: tchan.Post{ID: postID, Deleted: true}})
+	}
+	return nil
+}
+
+// fetch gives the thread with the post in it, only including replies after
+// the given one. Nothing is fetched without subscribers to the board.
+func (p *publishingDB) fetch(ctx context.Context, boardName string, postID int64, after int64) (tchan.Thread, bool) {
+	thr := tchan.Thread{}
+	if !p.hub.active(boardName) {
+		return thr, false
+	}
+
+	found := false
+	err := p.DB.PopulateThread(ctx, boardName, postID, backend.PostRange{After: after}, &thr, &found)
+	if err != nil {
+		// The change itself was made nonetheless
+		log.Printf("failed to publish change to /%s/%d: %v", boardName, postID, err)
+		return thr, false
+	}
+	// Not found, e.g. deleted in the meantime, leaves nothing to publish
+	return thr, found
+}
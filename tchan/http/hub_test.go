@@ -0,0 +1,35 @@
+package http
+
+import (
+	"testing"
+
+	"github.com/fgahr/termchan/tchan"
+)
+
+func TestHubDropsSlowFollowers(t *testing.T) {
+	h := newHub()
+	slow := h.subscribe("b", 1)
+	other := h.subscribe("b", 2)
+
+	for i := 0; i <= followerQueueLength; i++ {
+		h.publish(event{board: "b", thread: 1, post: tchan.Post{ID: int64(i + 2)}})
+	}
+	for range slow.events {
+	}
+	if !slow.lagged {
+		t.Error("expected slow follower to be marked as lagging")
+	}
+	if !h.active("b") {
+		t.Error("expected follower of other thread to remain")
+	}
+
+	// Unsubscribing after being dropped is harmless
+	h.unsubscribe(slow)
+	h.close()
+	if _, open := <-other.events; open {
+		t.Error("expected closing the hub to end subscriptions")
+	}
+	if h.subscribe("b", 1) != nil {
+		t.Error("expected closed hub to refuse subscriptions")
+	}
+}
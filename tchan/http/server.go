@@ -6,6 +6,8 @@ import (
 	"net"
 	"net/http"
 	"os"
+	"sort"
+	"strings"
 	"sync"
 
 	"github.com/gorilla/mux"
@@ -30,19 +32,31 @@ type Server struct {
 	confLock *sync.RWMutex
 	htmlSet  html.TemplateSet
 	ansiSet  ansi.TemplateSet
+	// Cancels the queries of requests still running when shutdown times out
+	abort context.CancelFunc
+	// Closed once the server has shut down
+	stopped  chan struct{}
+	stopOnce sync.Once
+	// Passes changes to followers of threads and websocket clients
+	hub *hub
 }
 
 // New creates a new server with configuration and backend.
 // Backend is assumed to be fully set up.
 func NewServer(conf *config.Settings) (*Server, error) {
-	db := backend.New(conf)
+	db, err := backend.New(conf)
+	if err != nil {
+		return nil, err
+	}
 	if err := db.Init(); err != nil {
 		return nil, errors.Wrap(err, "backend setup failed")
 	}
 
+	h := newHub()
 	s := &Server{
 		conf:     conf,
-		db:       db,
+		db:       &publishingDB{DB: db, hub: h},
+		hub:      h,
 		router:   mux.NewRouter(),
 		confLock: new(sync.RWMutex),
 	}
@@ -57,14 +71,32 @@ func NewServer(conf *config.Settings) (*Server, error) {
 
 func (s *Server) routes() {
 	s.router.HandleFunc("/", s.handleWelcome()).Methods("GET")
+	// Needs to take precedence over board routes
+	s.router.HandleFunc("/search", s.handleSearch()).Methods("GET")
+	s.router.HandleFunc("/search/", s.handleSearch()).Methods("GET")
+	s.router.HandleFunc("/ws", s.handleWebsocket()).Methods("GET")
 	s.router.HandleFunc("/{board:[a-zA-Z0-9]+}", s.handleViewBoard()).Methods("GET")
 	s.router.HandleFunc("/{board:[a-zA-Z0-9]+}/", s.handleViewBoard()).Methods("GET")
 	s.router.HandleFunc("/{board:[a-zA-Z0-9]+}", s.handleCreateThread()).Methods("POST")
 	s.router.HandleFunc("/{board:[a-zA-Z0-9]+}/", s.handleCreateThread()).Methods("POST")
+	s.router.HandleFunc("/{board:[a-zA-Z0-9]+}/catalog", s.handleViewCatalog()).Methods("GET")
+	s.router.HandleFunc("/{board:[a-zA-Z0-9]+}/catalog/", s.handleViewCatalog()).Methods("GET")
+	s.router.HandleFunc("/{board:[a-zA-Z0-9]+}/archive", s.handleViewArchive()).Methods("GET")
+	s.router.HandleFunc("/{board:[a-zA-Z0-9]+}/archive/", s.handleViewArchive()).Methods("GET")
+	// Needs to take precedence over viewing the thread
+	s.router.HandleFunc("/{board:[a-zA-Z0-9]+}/{id:[0-9]+}", s.handleFollowThread()).Methods("GET").Queries("follow", "1")
+	s.router.HandleFunc("/{board:[a-zA-Z0-9]+}/{id:[0-9]+}/", s.handleFollowThread()).Methods("GET").Queries("follow", "1")
 	s.router.HandleFunc("/{board:[a-zA-Z0-9]+}/{id:[0-9]+}", s.handleViewThread()).Methods("GET")
 	s.router.HandleFunc("/{board:[a-zA-Z0-9]+}/{id:[0-9]+}/", s.handleViewThread()).Methods("GET")
 	s.router.HandleFunc("/{board:[a-zA-Z0-9]+}/{id:[0-9]+}", s.handleReplyToThread()).Methods("POST")
 	s.router.HandleFunc("/{board:[a-zA-Z0-9]+}/{id:[0-9]+}/", s.handleReplyToThread()).Methods("POST")
+	s.router.HandleFunc("/{board:[a-zA-Z0-9]+}/{id:[0-9]+}/{action:[a-z]+}", s.handleModerate()).Methods("POST")
+}
+
+// Handler gives the handler for all requests, e.g. to serve them through
+// httptest.
+func (s *Server) Handler() http.Handler {
+	return s.router
 }
 
 // ReloadConfig forces the server to reload its configuration and templates.
@@ -74,9 +106,20 @@ func (s *Server) ReloadConfig() error {
 	defer s.confLock.Unlock()
 
 	log.Println("loading configuration")
+	prev := s.conf.Boards
 	if err := s.conf.ReadFromFile(); err != nil {
 		return err
 	}
+	changes := config.DiffBoards(prev, s.conf.Boards)
+	for _, name := range changes.Added {
+		log.Printf("adding board /%s/", name)
+	}
+	for _, name := range changes.Removed {
+		log.Printf("removing board /%s/", name)
+	}
+	for _, name := range changes.Changed {
+		log.Printf("updating settings of /%s/", name)
+	}
 
 	log.Println("reading templates")
 	if err := s.htmlSet.Read(s.conf.TemplateDirectory()); err != nil {
@@ -115,30 +158,54 @@ func (s *Server) ServeHTTP() error {
 		}
 	}
 
+	base, abort := context.WithCancel(context.Background())
+	defer abort()
+	s.abort = abort
+	s.stopped = make(chan struct{})
 	s.hs = &http.Server{
-		Addr:    t.Socket,
-		Handler: s.router,
+		Addr:        t.Socket,
+		Handler:     s.router,
+		BaseContext: func(net.Listener) context.Context { return base },
 	}
 	log.Printf("serving HTTP on %v", s.conf.Transport)
 	if err := s.hs.Serve(listener); err != nil && err != http.ErrServerClosed {
 		return err
 	}
+	// Serve returns as soon as shutdown begins
+	<-s.stopped
 	return nil
 }
 
-// Stop causes the server to stop listening.
+// Stop causes the server to stop listening. Followers of threads and
+// websocket clients are disconnected right away. Other requests in progress are given the configured
+// shutdown timeout to complete, after which their queries are cancelled.
 func (s *Server) Stop() error {
-	if s.hs != nil {
-		return s.hs.Shutdown(context.Background())
+	if s.hs == nil {
+		return errors.New("not listening")
 	}
-	return errors.New("not listening")
+	defer s.stopOnce.Do(func() { close(s.stopped) })
+	s.hub.close()
+
+	ctx, cancel := context.WithTimeout(context.Background(), s.conf.ShutdownTimeout())
+	defer cancel()
+	err := s.hs.Shutdown(ctx)
+	if err == context.DeadlineExceeded {
+		log.Println("shutdown timed out, aborting remaining requests")
+		s.abort()
+		err = s.hs.Close()
+	}
+	return err
 }
 
+// confReader prevents configuration reloads while handling a request and
+// limits the time spent on it.
 func (s *Server) confReader(f http.HandlerFunc) http.HandlerFunc {
 	return func(w http.ResponseWriter, r *http.Request) {
 		s.confLock.RLock()
 		defer s.confLock.RUnlock()
-		f(w, r)
+		ctx, cancel := context.WithTimeout(r.Context(), s.conf.RequestTimeout())
+		defer cancel()
+		f(w, r.WithContext(ctx))
 	}
 }
 
@@ -158,10 +225,11 @@ func (s *Server) handleViewBoard() http.HandlerFunc {
 			rw.respondNoSuchBoard()
 		}
 
+		page := int(rw.intParam("page"))
 		ok = false
 		board := tchan.BoardOverview{Board: boardConf}
 		rw.try(func() error {
-			return s.db.PopulateBoard(rw.board, &board, &ok)
+			return s.db.PopulateBoard(r.Context(), rw.board, page, &board, &ok)
 		}, http.StatusInternalServerError, "failed to fetch board")
 
 		if ok {
@@ -172,6 +240,96 @@ func (s *Server) handleViewBoard() http.HandlerFunc {
 	})
 }
 
+func (s *Server) handleViewCatalog() http.HandlerFunc {
+	return s.handleListing(s.db.PopulateCatalog, "failed to fetch catalog")
+}
+
+func (s *Server) handleViewArchive() http.HandlerFunc {
+	return s.handleListing(s.db.PopulateArchive, "failed to fetch archive")
+}
+
+func (s *Server) handleListing(populate func(context.Context, string, *tchan.Catalog, *bool) error, errorText string) http.HandlerFunc {
+	return s.confReader(func(w http.ResponseWriter, r *http.Request) {
+		rw := s.newRequestWorker(w, r)
+
+		boardConf, ok := s.conf.BoardConfig(rw.board)
+		if !ok {
+			rw.respondNoSuchBoard()
+		}
+
+		ok = false
+		catalog := tchan.Catalog{Board: boardConf}
+		rw.try(func() error {
+			return populate(r.Context(), rw.board, &catalog, &ok)
+		}, http.StatusInternalServerError, errorText)
+
+		if ok {
+			rw.respondCatalog(catalog)
+		} else {
+			rw.respondNoSuchBoard()
+		}
+	})
+}
+
+// Maximum number of search results shown
+const searchLimit = 50
+
+func (s *Server) handleSearch() http.HandlerFunc {
+	return s.confReader(func(w http.ResponseWriter, r *http.Request) {
+		rw := s.newRequestWorker(w, r)
+		results := tchan.SearchResults{
+			Query:   rw.params.Get("q"),
+			Board:   rw.params.Get("board"),
+			Results: make([]tchan.SearchResult, 0),
+		}
+
+		boards := s.conf.Boards
+		if results.Board != "" {
+			rw.board = results.Board
+			boardConf, ok := s.conf.BoardConfig(rw.board)
+			if !ok {
+				rw.respondNoSuchBoard()
+			}
+			boards = []tchan.Board{boardConf}
+		}
+		if rw.err == nil && strings.TrimSpace(results.Query) == "" {
+			rw.err = errors.New("search query required, e.g. /search?q=foo")
+			rw.respondError(http.StatusBadRequest)
+		}
+
+		unavailable := false
+		for _, b := range boards {
+			var found []tchan.SearchResult
+			ok := false
+			rw.try(func() error {
+				err := s.db.Search(r.Context(), b.Name, results.Query, searchLimit, &found, &ok)
+				if errors.Cause(err) == backend.ErrSearchUnavailable {
+					unavailable = true
+					return nil
+				}
+				return err
+			}, http.StatusInternalServerError, "search failed")
+			if unavailable {
+				rw.respondSearchUnavailable()
+				break
+			}
+			for _, r := range found {
+				r.Style = b.Style
+				results.Results = append(results.Results, r)
+			}
+		}
+
+		// Ranks are not strictly comparable between boards but close enough
+		sort.SliceStable(results.Results, func(i, j int) bool {
+			return results.Results[i].Rank < results.Results[j].Rank
+		})
+		if len(results.Results) > searchLimit {
+			results.Results = results.Results[:searchLimit]
+		}
+		rw.respondSearch(results)
+	})
+}
+
 func (s *Server) handleViewThread() http.HandlerFunc {
 	return s.confReader(func(w http.ResponseWriter, r *http.Request) {
 		rw := s.newRequestWorker(w, r)
@@ -181,9 +339,10 @@ func (s *Server) handleViewThread() http.HandlerFunc {
 			rw.respondNoSuchBoard()
 		}
 		thr := tchan.Thread{Board: boardConf}
+		pr := rw.getPostRange(boardConf)
 
 		ok = false
-		rw.try(func() error { return s.db.PopulateThread(rw.board, rw.replyID, &thr, &ok) },
+		rw.try(func() error { return s.db.PopulateThread(r.Context(), rw.board, rw.replyID, pr, &thr, &ok) },
 			http.StatusInternalServerError, "failed to fetch thread for viewing")
 
 		if ok {
@@ -199,9 +358,10 @@ func (s *Server) handleCreateThread() http.HandlerFunc {
 		rw := s.newRequestWorker(w, r)
 
 		rw.extractPost()
+		rw.checkPostAllowed(s.db, true)
 		topic := rw.getTopic()
 
-		rw.try(func() error { return s.db.CreateThread(rw.board, topic, &rw.post) },
+		rw.try(func() error { return s.db.CreateThread(r.Context(), rw.board, topic, &rw.post) },
 			http.StatusInternalServerError, "failed to create thread")
 
 		boardConf, ok := s.conf.BoardConfig(rw.board)
@@ -211,7 +371,9 @@ func (s *Server) handleCreateThread() http.HandlerFunc {
 		thr := tchan.Thread{Board: boardConf}
 
 		ok = false
-		rw.try(func() error { return s.db.PopulateThread(rw.board, rw.post.ID, &thr, &ok) },
+		rw.try(func() error {
+			return s.db.PopulateThread(r.Context(), rw.board, rw.post.ID, backend.PostRange{}, &thr, &ok)
+		},
 			http.StatusInternalServerError, "failed to fetch thread for viewing")
 
 		if ok {
@@ -223,7 +385,7 @@ func (s *Server) handleCreateThread() http.HandlerFunc {
 }
 
 func (s *Server) handleReplyToThread() http.HandlerFunc {
-	return func(w http.ResponseWriter, r *http.Request) {
+	return s.confReader(func(w http.ResponseWriter, r *http.Request) {
 		rw := s.newRequestWorker(w, r)
 
 		boardConf, ok := s.conf.BoardConfig(rw.board)
@@ -231,16 +393,56 @@ func (s *Server) handleReplyToThread() http.HandlerFunc {
 			rw.respondNoSuchBoard()
 		}
 
-		rw.extractPost()
-		ok = false
-		rw.try(func() error { return s.db.AddReply(rw.board, rw.replyID, &rw.post, &ok) },
-			http.StatusInternalServerError, "failed to persist reply")
+		rw.addReply(s.db)
+
+		thr := tchan.Thread{Board: boardConf}
+		rw.try(func() error {
+			return s.db.PopulateThread(r.Context(), rw.board, rw.replyID, backend.PostRange{}, &thr, &ok)
+		},
+			http.StatusInternalServerError, "failed to fetch thread for viewing")
+
+		if ok {
+			rw.respondThread(thr)
+		} else {
+			rw.respondNoSuchThread()
+		}
+	})
+}
+
+func (s *Server) handleModerate() http.HandlerFunc {
+	return s.confReader(func(w http.ResponseWriter, r *http.Request) {
+		rw := s.newRequestWorker(w, r)
+
+		mod := rw.authenticateModerator()
+		boardConf, ok := s.conf.BoardConfig(rw.board)
 		if !ok {
+			rw.respondNoSuchBoard()
+		}
+
+		action := mux.Vars(r)["action"]
+		ok = false
+		unknownAction := false
+		rw.try(func() error {
+			err := backend.Moderate(r.Context(), s.db, action, rw.board, rw.replyID, &ok)
+			if errors.Cause(err) == backend.ErrUnknownAction {
+				unknownAction = true
+				return nil
+			}
+			return err
+		}, http.StatusInternalServerError, "failed to moderate post")
+		if unknownAction {
+			rw.respondUnknownAction(action)
+		} else if !ok {
 			rw.respondNoSuchThread()
 		}
+		if rw.err == nil {
+			log.Printf("moderator %s: %s /%s/%d", mod.Name, action, rw.board, rw.replyID)
+		}
 
 		thr := tchan.Thread{Board: boardConf}
-		rw.try(func() error { return s.db.PopulateThread(rw.board, rw.replyID, &thr, &ok) },
+		rw.try(func() error {
+			return s.db.PopulateThread(r.Context(), rw.board, rw.replyID, backend.PostRange{}, &thr, &ok)
+		},
 			http.StatusInternalServerError, "failed to fetch thread for viewing")
 
 		if ok {
@@ -248,6 +450,19 @@ func (s *Server) handleReplyToThread() http.HandlerFunc {
 		} else {
 			rw.respondNoSuchThread()
 		}
+	})
+}
+
+// formatWriter gives the writer for the requested output format, ANSI by
+// default.
+func (s *Server) formatWriter(format string, r *http.Request, w http.ResponseWriter) output.Writer {
+	switch format {
+	case "html":
+		return s.htmlWriter(r, w)
+	case "json":
+		return s.jsonWriter(r, w)
+	default:
+		return s.ansiWriter(r, w)
 	}
 }
 
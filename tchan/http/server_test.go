@@ -0,0 +1,177 @@
+package http
+
+import (
+	"bufio"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/fgahr/termchan/tchan"
+	"github.com/fgahr/termchan/tchan/config"
+)
+
+// newTestServer sets up a server with an in-memory backend, which requires
+// neither cgo nor a database on disk.
+func newTestServer(t *testing.T, boards ...tchan.Board) *Server {
+	conf := config.Defaults()
+	if err := conf.SetWorkingDirectory(t.TempDir()); err != nil {
+		t.Fatal(err)
+	}
+	conf.Database.Driver = config.Memory
+	conf.Boards = boards
+
+	s, err := NewServer(&conf)
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { s.db.Close() })
+	return s
+}
+
+func request(s *Server, method string, target string, body string) *httptest.ResponseRecorder {
+	r := httptest.NewRequest(method, target, strings.NewReader(body))
+	r.RemoteAddr = "192.0.2.7:4321"
+	w := httptest.NewRecorder()
+	s.router.ServeHTTP(w, r)
+	return w
+}
+
+func TestPostAndView(t *testing.T) {
+	s := newTestServer(t, tchan.Board{Name: "b"})
+
+	if w := request(s, "POST", "/b/", "topic=hello&content=first"); w.Code != http.StatusOK {
+		t.Fatalf("failed to create thread: %d %s", w.Code, w.Body)
+	}
+	if w := request(s, "POST", "/b/1", "name=anon&content=%3E%3E1+second"); w.Code != http.StatusOK {
+		t.Fatalf("failed to reply: %d %s", w.Code, w.Body)
+	}
+
+	w := request(s, "GET", "/b/1?format=json", "")
+	if w.Code != http.StatusOK {
+		t.Fatalf("failed to view thread: %d %s", w.Code, w.Body)
+	}
+	thr := tchan.Thread{}
+	if err := json.NewDecoder(w.Body).Decode(&thr); err != nil {
+		t.Fatal(err)
+	}
+	if thr.Topic != "hello" || len(thr.Posts) != 2 || thr.Posts[1].Author != "anon" {
+		t.Errorf("unexpected thread: %+v", thr)
+	}
+	if len(thr.Posts[0].QuotedBy) != 1 || thr.Posts[0].QuotedBy[0] != 2 {
+		t.Errorf("expected OP to be quoted by the reply, got %v", thr.Posts[0].QuotedBy)
+	}
+
+	if w := request(s, "GET", "/b/3", ""); w.Code != http.StatusNotFound {
+		t.Errorf("expected missing thread to give 404, got %d", w.Code)
+	}
+	if w := request(s, "POST", "/b/3", "content=nothing"); w.Code != http.StatusNotFound {
+		t.Errorf("expected reply to missing thread to give 404, got %d", w.Code)
+	}
+}
+
+func TestModerate(t *testing.T) {
+	s := newTestServer(t, tchan.Board{Name: "b"})
+	s.conf.Moderators = []config.Moderator{{Name: "mod", Token: "secret"}}
+	request(s, "POST", "/b/", "topic=hello&content=first")
+
+	moderate := func(target string) *httptest.ResponseRecorder {
+		r := httptest.NewRequest("POST", target, nil)
+		r.SetBasicAuth("mod", "secret")
+		w := httptest.NewRecorder()
+		s.router.ServeHTTP(w, r)
+		return w
+	}
+
+	if w := moderate("/b/1/lock"); w.Code != http.StatusOK {
+		t.Errorf("failed to lock thread: %d %s", w.Code, w.Body)
+	}
+	if w := moderate("/b/1/frobnicate"); w.Code != http.StatusBadRequest || !strings.Contains(w.Body.String(), "unknown moderation action") {
+		t.Errorf("expected unknown action to give 400, got %d %s", w.Code, w.Body)
+	}
+	if w := moderate("/b/7/lock"); w.Code != http.StatusNotFound {
+		t.Errorf("expected missing thread to give 404, got %d", w.Code)
+	}
+}
+
+func TestUnknownClientsShareLimits(t *testing.T) {
+	s := newTestServer(t, tchan.Board{Name: "b", PostCooldownSecs: 60})
+	s.conf.Transport = config.Transport{Protocol: config.Unix}
+
+	post := func(content string) int {
+		r := httptest.NewRequest("POST", "/b/", strings.NewReader("content="+content))
+		r.RemoteAddr = "@"
+		w := httptest.NewRecorder()
+		s.router.ServeHTTP(w, r)
+		return w.Code
+	}
+	if code := post("first"); code != http.StatusOK {
+		t.Fatalf("failed to create thread: %d", code)
+	}
+	if code := post("second"); code != http.StatusTooManyRequests {
+		t.Errorf("expected second post without address to be limited, got %d", code)
+	}
+}
+
+func TestFollowThread(t *testing.T) {
+	s := newTestServer(t, tchan.Board{Name: "b"})
+	hs := httptest.NewServer(s.router)
+	defer hs.Close()
+
+	request(s, "POST", "/b/", "topic=hello&content=first")
+	request(s, "POST", "/b/1", "content=second")
+
+	req, err := http.NewRequest("GET", hs.URL+"/b/1?follow=1&format=json", nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	req.Header.Set("Last-Event-ID", "1")
+	res, err := http.DefaultClient.Do(req)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer res.Body.Close()
+	if ct := res.Header.Get("Content-Type"); ct != "text/event-stream" {
+		t.Fatalf("expected event stream, got %s", ct)
+	}
+
+	posts := make(chan tchan.Post)
+	go func() {
+		defer close(posts)
+		scanner := bufio.NewScanner(res.Body)
+		for scanner.Scan() {
+			if data := strings.TrimPrefix(scanner.Text(), "data: "); data != scanner.Text() {
+				post := tchan.Post{}
+				if err := json.Unmarshal([]byte(data), &post); err != nil {
+					t.Error(err)
+				}
+				posts <- post
+			}
+		}
+	}()
+	next := func() tchan.Post {
+		select {
+		case post := <-posts:
+			return post
+		case <-time.After(5 * time.Second):
+			t.Fatal("timed out waiting for post")
+		}
+		return tchan.Post{}
+	}
+
+	// Resumes after the OP
+	if post := next(); post.ID != 2 || post.Content != "second" {
+		t.Errorf("expected post 2, got %+v", post)
+	}
+	request(s, "POST", "/b/1", "content=third")
+	if post := next(); post.ID != 3 || post.Content != "third" {
+		t.Errorf("expected post 3, got %+v", post)
+	}
+
+	s.hub.close()
+	if post, open := <-posts; open {
+		t.Errorf("expected stream to end on shutdown, got %+v", post)
+	}
+}
@@ -1,47 +1,40 @@
 package http
 
 import (
+	"context"
 	"io/ioutil"
 	"log"
 	"net/http"
 	"net/url"
 	"strconv"
 	"strings"
-	"time"
 
 	"github.com/gorilla/mux"
 	"github.com/pkg/errors"
 
 	"github.com/fgahr/termchan/tchan"
+	"github.com/fgahr/termchan/tchan/backend"
 	"github.com/fgahr/termchan/tchan/config"
 	"github.com/fgahr/termchan/tchan/output"
 )
 
 type requestWorker struct {
-	conf    *config.Settings
-	w       output.Writer
-	r       *http.Request
-	params  url.Values
-	board   string
-	replyID int64
-	post    tchan.Post
-	err     error
+	conf     *config.Settings
+	w        output.Writer
+	r        *http.Request
+	params   url.Values
+	board    string
+	replyID  int64
+	clientIP string
+	post     tchan.Post
+	err      error
 }
 
 func (s *Server) newRequestWorker(w http.ResponseWriter, r *http.Request) *requestWorker {
 	// Use ANSI as default for possible error messages up to this point.
-	rw := requestWorker{conf: s.conf, w: s.ansiWriter(r, w), r: r}
+	rw := requestWorker{conf: s.conf, w: s.ansiWriter(r, w), r: r, clientIP: clientIP(r, s.conf.Transport)}
 	rw.init()
-	switch rw.params.Get("format") {
-	case "ansi":
-		rw.w = s.ansiWriter(r, w)
-	case "html":
-		rw.w = s.htmlWriter(r, w)
-	case "json":
-		rw.w = s.jsonWriter(r, w)
-	default:
-		rw.w = s.ansiWriter(r, w)
-	}
+	rw.w = s.formatWriter(rw.params.Get("format"), r, w)
 
 	return &rw
 }
@@ -105,6 +98,10 @@ func (rw *requestWorker) try(f func() error, failStatus int, errorText string, h
 	err := f()
 	if err != nil {
 		log.Println(err)
+		if errors.Cause(err) == context.DeadlineExceeded {
+			failStatus = http.StatusServiceUnavailable
+			errorText = "request timed out"
+		}
 		if errorText != "" {
 			err = errors.New(errorText)
 		}
@@ -118,37 +115,164 @@ func (rw *requestWorker) extractPost() {
 		return
 	}
 
-	// Trimming extraneous spaces avoids some kinds of abuse/trolling
-	content := strings.TrimSpace(rw.params.Get("content"))
+	if _, ok := rw.conf.BoardConfig(rw.board); !ok {
+		rw.err = errors.Errorf("no such board: %s", rw.board)
+		rw.respondError(http.StatusNotFound)
+		return
+	}
+	post, err := backend.PreparePost(rw.conf, rw.board, rw.params.Get("name"), rw.params.Get("content"))
+	if rejection, ok := errors.Cause(err).(*backend.FilterRejection); ok {
+		log.Printf("rejected post by %s on /%s/: matches filter %s", rw.clientIP, rw.board, rejection.Filter.Pattern)
+	}
+	if err != nil {
+		rw.err = err
+		rw.respondError(http.StatusBadRequest)
+		return
+	}
+
+	post.AuthorIP = rw.clientIP
+	rw.post = post
+}
+
+// checkPostAllowed rejects the extracted post if its author is banned, posting
+// too frequently or repeating themselves.
+func (rw *requestWorker) checkPostAllowed(db backend.DB, newThread bool) {
+	if rw.err != nil {
+		return
+	}
+
 	bc, ok := rw.conf.BoardConfig(rw.board)
 	if !ok {
 		rw.err = errors.Errorf("no such board: %s", rw.board)
 		rw.respondError(http.StatusNotFound)
 		return
 	}
-	if len(content) > bc.MaxPostBytes() {
-		rw.err = errors.Errorf("post too large: %d bytes (max %d bytes)", len(content), bc.MaxPostBytes())
-		rw.respondError(http.StatusBadRequest)
+
+	var refusal error
+	rw.try(func() error {
+		err := backend.CheckPostAllowed(rw.r.Context(), db, bc, rw.clientIP, rw.post.Content, newThread)
+		switch errors.Cause(err).(type) {
+		case *backend.BanRejection, *backend.LimitRejection:
+			refusal = err
+			return nil
+		}
+		return err
+	}, http.StatusInternalServerError, "failed to check whether posting is allowed")
+	if refusal == nil {
 		return
-	} else if content == "" {
-		rw.err = errors.Errorf("empty post content")
-		rw.respondError(http.StatusBadRequest)
+	}
+
+	rw.err = refusal
+	log.Printf("rejected post by %s on /%s/: %v", rw.clientIP, rw.board, rw.err)
+	if rejection, ok := errors.Cause(refusal).(*backend.BanRejection); ok {
+		if err := rw.w.WriteBan(http.StatusForbidden, rejection.Ban); err != nil {
+			log.Println(err)
+		}
 		return
 	}
+	rw.respondError(http.StatusTooManyRequests)
+}
+
+// addReply adds the extracted post to the thread, subject to bans and limits.
+func (rw *requestWorker) addReply(db backend.DB) {
+	rw.extractPost()
+	rw.checkPostAllowed(db, false)
+	ok := false
+	var refusal error
+	rw.try(func() error {
+		err := db.AddReply(rw.r.Context(), rw.board, rw.replyID, &rw.post, &ok)
+		switch errors.Cause(err) {
+		case backend.ErrThreadArchived, backend.ErrThreadLocked:
+			refusal = err
+			return nil
+		}
+		return err
+	}, http.StatusInternalServerError, "failed to persist reply")
+	if !ok {
+		rw.respondNoSuchThread()
+	} else if refusal != nil {
+		rw.respondReadOnly(refusal)
+	}
+}
 
-	author := "Anonymous"
-	if name := rw.params.Get("name"); name != "" {
-		author = name
+// authenticateModerator checks the moderator credentials given via basic
+// authentication.
+func (rw *requestWorker) authenticateModerator() config.Moderator {
+	if rw.err != nil {
+		return config.Moderator{}
 	}
 
-	rw.post = tchan.Post{Author: author, Timestamp: time.Now(), Content: content}
+	name, token, hasAuth := rw.r.BasicAuth()
+	mod, ok := rw.conf.Moderator(name, token)
+	if !hasAuth || !ok {
+		rw.err = errors.New("valid moderator credentials required")
+		rw.respondError(http.StatusUnauthorized)
+	}
+	return mod
 }
 
 func (rw *requestWorker) getTopic() string {
 	if rw.err != nil {
 		return ""
 	}
-	return rw.params.Get("topic")
+
+	bc, ok := rw.conf.BoardConfig(rw.board)
+	if !ok {
+		rw.err = errors.Errorf("no such board: %s", rw.board)
+		rw.respondError(http.StatusNotFound)
+		return ""
+	}
+	return rw.applyFilters(bc, rw.params.Get("topic"))
+}
+
+// applyFilters runs the board's filters on a field of the submitted post,
+// rejecting the post if required.
+func (rw *requestWorker) applyFilters(bc tchan.Board, field string) string {
+	if rw.err != nil {
+		return field
+	}
+
+	filtered, rejectedBy := bc.FilterContent(field)
+	if rejectedBy != nil {
+		rw.err = errors.New("post rejected by content filter")
+		log.Printf("rejected post by %s on /%s/: matches filter %s", rw.clientIP, rw.board, rejectedBy.Pattern)
+		rw.respondError(http.StatusBadRequest)
+	}
+	return filtered
+}
+
+// intParam reads an optional, non-negative integer parameter. Absent
+// parameters are read as 0.
+func (rw *requestWorker) intParam(name string) int64 {
+	if rw.err != nil {
+		return 0
+	}
+
+	s := rw.params.Get(name)
+	if s == "" {
+		return 0
+	}
+	n, err := strconv.ParseInt(s, 10, 64)
+	if err != nil || n < 0 {
+		rw.err = errors.Errorf("invalid value for %s: %s", name, s)
+		rw.respondError(http.StatusBadRequest)
+		return 0
+	}
+	return n
+}
+
+func (rw *requestWorker) getPostRange(bc tchan.Board) backend.PostRange {
+	pr := backend.PostRange{
+		After:    rw.intParam("after"),
+		Last:     int(rw.intParam("last")),
+		Page:     int(rw.intParam("page")),
+		PageSize: bc.PostsPerPage(),
+	}
+	if rw.err == nil && pr.Last > 0 && pr.Page > 0 {
+		rw.err = errors.New("cannot combine last and page")
+		rw.respondError(http.StatusBadRequest)
+	}
+	return pr
 }
 
 func (rw *requestWorker) respondWelcome() {
@@ -170,11 +294,39 @@ func (rw *requestWorker) respondNoSuchThread() {
 	rw.respondError(http.StatusNotFound)
 }
 
+func (rw *requestWorker) respondReadOnly(reason error) {
+	if rw.err != nil {
+		return
+	}
+
+	rw.err = errors.Wrapf(reason, "cannot reply to /%s/%d", rw.board, rw.replyID)
+	rw.respondError(http.StatusForbidden)
+}
+
 func (rw *requestWorker) respondBoard(b tchan.BoardOverview) {
 	rw.try(func() error { return rw.w.WriteBoard(b) },
 		http.StatusInternalServerError, "", func(err error) { log.Println(err) })
 }
 
+func (rw *requestWorker) respondCatalog(c tchan.Catalog) {
+	rw.try(func() error { return rw.w.WriteCatalog(c) },
+		http.StatusInternalServerError, "", func(err error) { log.Println(err) })
+}
+
+func (rw *requestWorker) respondSearch(results tchan.SearchResults) {
+	rw.try(func() error { return rw.w.WriteSearch(results) },
+		http.StatusInternalServerError, "", func(err error) { log.Println(err) })
+}
+
+func (rw *requestWorker) respondSearchUnavailable() {
+	if rw.err != nil {
+		return
+	}
+
+	rw.err = backend.ErrSearchUnavailable
+	rw.respondError(http.StatusNotImplemented)
+}
+
 func (rw *requestWorker) respondNoSuchBoard() {
 	if rw.err != nil {
 		return
@@ -184,6 +336,15 @@ func (rw *requestWorker) respondNoSuchBoard() {
 	rw.respondError(http.StatusNotFound)
 }
 
+func (rw *requestWorker) respondUnknownAction(action string) {
+	if rw.err != nil {
+		return
+	}
+
+	rw.err = errors.Errorf("unknown moderation action: %s (expected one of %s)", action, strings.Join(backend.ModerationActions, ", "))
+	rw.respondError(http.StatusBadRequest)
+}
+
 func (rw *requestWorker) respondError(status int) {
 	rw.w.WriteError(status, rw.err)
 }
@@ -0,0 +1,276 @@
+package http
+
+import (
+	"context"
+	"encoding/json"
+	"log"
+	"net/http"
+	"net/url"
+	"time"
+
+	"github.com/gorilla/websocket"
+	"github.com/pkg/errors"
+
+	"github.com/fgahr/termchan/tchan"
+	"github.com/fgahr/termchan/tchan/backend"
+)
+
+const (
+	// Time allowed to write a message to a websocket client
+	wsWriteWait = 10 * time.Second
+	// Time allowed between messages or pongs from a websocket client
+	wsPongWait = 60 * time.Second
+	// Pings are sent more frequently than pongs are expected
+	wsPingInterval = wsPongWait * 9 / 10
+	// Requests beyond this size end the connection
+	wsMaxRequestBytes = 64 << 10
+)
+
+// wsRequest is a message from a websocket client. Valid types are
+// "subscribe", "unsubscribe" and "reply". Subscribing without a thread
+// subscribes to the whole board.
+type wsRequest struct {
+	Type    string `json:"type"`
+	Board   string `json:"board"`
+	Thread  int64  `json:"thread,omitempty"`
+	Name    string `json:"name,omitempty"`
+	Content string `json:"content,omitempty"`
+}
+
+// wsMessage is a message to a websocket client. Events are of type "thread",
+// "reply" or "delete", requests are answered with "subscribed",
+// "unsubscribed", "replied" or "error".
+type wsMessage struct {
+	Type   string `json:"type"`
+	Board  string `json:"board,omitempty"`
+	Thread int64  `json:"thread,omitempty"`
+	// ID of a deleted post or of a reply made by the client
+	PostID int64 `json:"id,omitempty"`
+	// New replies, in the same shape as in the JSON output
+	Post *tchan.Post `json:"post,omitempty"`
+	// New threads, holding only the OP
+	View  *tchan.Thread `json:"view,omitempty"`
+	Error string        `json:"error,omitempty"`
+}
+
+// wsClient serves a single websocket connection.
+type wsClient struct {
+	s        *Server
+	conn     *websocket.Conn
+	r        *http.Request
+	clientIP string
+	sub      *subscription
+}
+
+func (s *Server) handleWebsocket() http.HandlerFunc {
+	upgrader := websocket.Upgrader{}
+	return func(w http.ResponseWriter, r *http.Request) {
+		sub := s.hub.newSubscription()
+		if sub == nil {
+			http.Error(w, "server is shutting down", http.StatusServiceUnavailable)
+			return
+		}
+		defer s.hub.unsubscribe(sub)
+
+		conn, err := upgrader.Upgrade(w, r, nil)
+		if err != nil {
+			// A response was sent already
+			log.Println(err)
+			return
+		}
+		defer conn.Close()
+
+		s.confLock.RLock()
+		ip := clientIP(r, s.conf.Transport)
+		s.confLock.RUnlock()
+		c := &wsClient{s: s, conn: conn, r: r, clientIP: ip, sub: sub}
+		c.run()
+	}
+}
+
+// run handles requests and passes on events until either side closes the
+// connection.
+func (c *wsClient) run() {
+	requests := make(chan wsRequest)
+	done := make(chan struct{})
+	defer close(done)
+	go c.read(requests, done)
+
+	ping := time.NewTicker(wsPingInterval)
+	defer ping.Stop()
+
+	for {
+		var err error
+		select {
+		case req, open := <-requests:
+			if !open {
+				return
+			}
+			err = c.send(c.handle(req))
+		case e, open := <-c.sub.events:
+			if !open {
+				if c.sub.lagged {
+					log.Printf("dropped slow websocket client %s", c.clientIP)
+					c.close(websocket.CloseTryAgainLater, "too slow")
+				} else {
+					c.close(websocket.CloseGoingAway, "server shutting down")
+				}
+				return
+			}
+			if msg, ok := c.eventMessage(e); ok {
+				err = c.send(msg)
+			}
+		case <-ping.C:
+			err = c.conn.WriteControl(websocket.PingMessage, nil, time.Now().Add(wsWriteWait))
+		}
+		if err != nil {
+			log.Println(errors.Wrap(err, "websocket write failed"))
+			return
+		}
+	}
+}
+
+// read passes on the client's requests until the connection fails.
+func (c *wsClient) read(requests chan<- wsRequest, done <-chan struct{}) {
+	defer close(requests)
+	c.conn.SetReadLimit(wsMaxRequestBytes)
+	c.conn.SetReadDeadline(time.Now().Add(wsPongWait))
+	c.conn.SetPongHandler(func(string) error {
+		return c.conn.SetReadDeadline(time.Now().Add(wsPongWait))
+	})
+
+	for {
+		_, data, err := c.conn.ReadMessage()
+		if err != nil {
+			if websocket.IsUnexpectedCloseError(err, websocket.CloseNormalClosure, websocket.CloseGoingAway) {
+				log.Println(errors.Wrap(err, "websocket read failed"))
+			}
+			return
+		}
+		c.conn.SetReadDeadline(time.Now().Add(wsPongWait))
+
+		req := wsRequest{}
+		if err := json.Unmarshal(data, &req); err != nil {
+			// Answered as an unknown request
+			req = wsRequest{Type: "malformed"}
+		}
+		select {
+		case requests <- req:
+		case <-done:
+			return
+		}
+	}
+}
+
+func (c *wsClient) send(msg wsMessage) error {
+	if err := c.conn.SetWriteDeadline(time.Now().Add(wsWriteWait)); err != nil {
+		return err
+	}
+	return c.conn.WriteJSON(msg)
+}
+
+func (c *wsClient) close(code int, text string) {
+	msg := websocket.FormatCloseMessage(code, text)
+	if err := c.conn.WriteControl(websocket.CloseMessage, msg, time.Now().Add(wsWriteWait)); err != nil {
+		log.Println(errors.Wrap(err, "failed to close websocket"))
+	}
+}
+
+// handle answers a request, like any other request holding the configuration
+// lock and subject to the request timeout.
+func (c *wsClient) handle(req wsRequest) wsMessage {
+	c.s.confLock.RLock()
+	defer c.s.confLock.RUnlock()
+	ctx, cancel := context.WithTimeout(c.r.Context(), c.s.conf.RequestTimeout())
+	defer cancel()
+
+	var msg wsMessage
+	var err error
+	switch req.Type {
+	case "subscribe":
+		msg, err = c.subscribe(ctx, req)
+	case "unsubscribe":
+		c.s.hub.drop(c.sub, req.Board, req.Thread)
+		msg = wsMessage{Type: "unsubscribed", Board: req.Board, Thread: req.Thread}
+	case "reply":
+		msg, err = c.reply(ctx, req)
+	default:
+		err = errors.Errorf("unknown request type: %s", req.Type)
+	}
+
+	if err != nil {
+		if errors.Cause(err) == context.DeadlineExceeded {
+			err = errors.New("request timed out")
+		}
+		return wsMessage{Type: "error", Board: req.Board, Thread: req.Thread, Error: err.Error()}
+	}
+	return msg
+}
+
+// subscribe subscribes to a board or, if given, one of its threads.
+func (c *wsClient) subscribe(ctx context.Context, req wsRequest) (wsMessage, error) {
+	if _, ok := c.s.conf.BoardConfig(req.Board); !ok {
+		return wsMessage{}, errors.Errorf("no such board: /%s/", req.Board)
+	}
+
+	thread := int64(0)
+	if req.Thread != 0 {
+		thr := tchan.Thread{}
+		ok := false
+		if err := c.s.db.PopulateThread(ctx, req.Board, req.Thread, backend.PostRange{Last: 1}, &thr, &ok); err != nil {
+			log.Println(err)
+			return wsMessage{}, errors.New("failed to fetch thread for subscribing")
+		} else if !ok {
+			return wsMessage{}, errors.Errorf("no such thread: /%s/%d", req.Board, req.Thread)
+		}
+		// Any post identifies its thread
+		thread = thr.ID()
+	}
+
+	c.s.hub.add(c.sub, req.Board, thread)
+	return wsMessage{Type: "subscribed", Board: req.Board, Thread: thread}, nil
+}
+
+// reply adds a reply the same way as posting through HTTP does.
+func (c *wsClient) reply(ctx context.Context, req wsRequest) (wsMessage, error) {
+	rw := &requestWorker{
+		conf:     c.s.conf,
+		w:        c.s.jsonWriter(c.r, newBufferWriter()),
+		r:        c.r.WithContext(ctx),
+		params:   url.Values{"name": {req.Name}, "content": {req.Content}},
+		board:    req.Board,
+		replyID:  req.Thread,
+		clientIP: c.clientIP,
+	}
+	if _, ok := c.s.conf.BoardConfig(req.Board); !ok {
+		rw.respondNoSuchBoard()
+	}
+	rw.addReply(c.s.db)
+	if rw.err != nil {
+		return wsMessage{}, rw.err
+	}
+	return wsMessage{Type: "replied", Board: req.Board, Thread: req.Thread, PostID: rw.post.ID}, nil
+}
+
+// eventMessage gives the message for an event unless its board is gone.
+func (c *wsClient) eventMessage(e event) (wsMessage, bool) {
+	msg := wsMessage{Board: e.board, Thread: e.thread}
+	switch e.kind {
+	case threadEvent:
+		c.s.confLock.RLock()
+		boardConf, ok := c.s.conf.BoardConfig(e.board)
+		c.s.confLock.RUnlock()
+		if !ok {
+			return msg, false
+		}
+		msg.Type = "thread"
+		msg.View = &tchan.Thread{Board: boardConf.Public(), Topic: e.topic, Posts: []tchan.Post{e.post}}
+	case replyEvent:
+		msg.Type = "reply"
+		msg.Post = &e.post
+	case deleteEvent:
+		msg.Type = "delete"
+		msg.PostID = e.post.ID
+	}
+	return msg, true
+}
@@ -0,0 +1,79 @@
+package http
+
+import (
+	"context"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/gorilla/websocket"
+
+	"github.com/fgahr/termchan/tchan"
+)
+
+func TestWebsocket(t *testing.T) {
+	s := newTestServer(t, tchan.Board{Name: "b"})
+	hs := httptest.NewServer(s.router)
+	defer hs.Close()
+
+	conn, _, err := websocket.DefaultDialer.Dial("ws"+strings.TrimPrefix(hs.URL, "http")+"/ws", nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Close()
+	conn.SetReadDeadline(time.Now().Add(5 * time.Second))
+
+	exchange := func(req wsRequest) {
+		if err := conn.WriteJSON(req); err != nil {
+			t.Fatal(err)
+		}
+	}
+	expect := func(typ string) wsMessage {
+		msg := wsMessage{}
+		if err := conn.ReadJSON(&msg); err != nil {
+			t.Fatalf("expected %s message: %v", typ, err)
+		}
+		if msg.Type != typ {
+			t.Fatalf("expected %s message, got %+v", typ, msg)
+		}
+		return msg
+	}
+
+	exchange(wsRequest{Type: "subscribe", Board: "x"})
+	expect("error")
+	exchange(wsRequest{Type: "subscribe", Board: "b"})
+	expect("subscribed")
+
+	request(s, "POST", "/b/", "topic=hello&content=first")
+	if msg := expect("thread"); msg.Thread != 1 || msg.View.Topic != "hello" || msg.View.Posts[0].Content != "first" {
+		t.Errorf("unexpected new thread: %+v", msg.View)
+	}
+
+	// Subscribing to a thread as well gives no duplicate events
+	exchange(wsRequest{Type: "subscribe", Board: "b", Thread: 1})
+	expect("subscribed")
+	exchange(wsRequest{Type: "reply", Board: "b", Thread: 1, Content: ">>1 second"})
+	if msg := expect("replied"); msg.PostID != 2 {
+		t.Errorf("expected reply to be post 2, got %d", msg.PostID)
+	}
+	if msg := expect("reply"); msg.Thread != 1 || msg.Post.ID != 2 || len(msg.Post.Quotes) != 1 {
+		t.Errorf("unexpected reply: %+v", msg.Post)
+	}
+	exchange(wsRequest{Type: "reply", Board: "b", Thread: 1, Content: " "})
+	expect("error")
+
+	ok := false
+	if err := s.db.DeletePost(context.Background(), "b", 2, &ok); err != nil || !ok {
+		t.Fatal(err)
+	}
+	if msg := expect("delete"); msg.Thread != 1 || msg.PostID != 2 {
+		t.Errorf("unexpected deletion: %+v", msg)
+	}
+
+	s.hub.close()
+	_, _, err = conn.ReadMessage()
+	if !websocket.IsCloseError(err, websocket.CloseGoingAway) {
+		t.Errorf("expected connection to be closed on shutdown, got %v", err)
+	}
+}
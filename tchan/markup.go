@@ -0,0 +1,133 @@
+package tchan
+
+import (
+	"regexp"
+	"strings"
+)
+
+// Anchored variant of quotePattern for use during inline parsing.
+var leadingQuotePattern = regexp.MustCompile(`^(?:` + quotePattern.String() + `)`)
+
+// NodeKind determines how a markup node is to be rendered.
+type NodeKind string
+
+const (
+	// Block nodes, each representing one or more lines of content
+	LineNode      NodeKind = "line"
+	GreentextNode NodeKind = "greentext"
+	CodeBlockNode NodeKind = "codeblock"
+
+	// Inline nodes, found as children of line and greentext nodes
+	TextNode   NodeKind = "text"
+	BoldNode   NodeKind = "bold"
+	ItalicNode NodeKind = "italic"
+	CodeNode   NodeKind = "code"
+	QuoteNode  NodeKind = "quote"
+)
+
+// Node is an element of the markup tree of a post's content. Text and code
+// nodes carry their text, quote nodes their reference and all other nodes
+// their children.
+type Node struct {
+	Kind     NodeKind `json:"kind"`
+	Text     string   `json:"text,omitempty"`
+	Quote    *Quote   `json:"quote,omitempty"`
+	Children []Node   `json:"children,omitempty"`
+}
+
+// ParseMarkup parses a post's content into a sequence of block nodes, one per
+// line except for fenced code blocks which span several lines.
+func ParseMarkup(content string) []Node {
+	blocks := make([]Node, 0)
+	lines := strings.Split(content, "\n")
+	for i := 0; i < len(lines); i++ {
+		line := lines[i]
+		if strings.HasPrefix(strings.TrimSpace(line), "```") {
+			// Fenced code block, possibly unterminated
+			j := i + 1
+			for j < len(lines) && !strings.HasPrefix(strings.TrimSpace(lines[j]), "```") {
+				j++
+			}
+			blocks = append(blocks, Node{
+				Kind: CodeBlockNode,
+				Text: strings.Join(lines[i+1:j], "\n"),
+			})
+			i = j
+			continue
+		}
+
+		kind := LineNode
+		if strings.HasPrefix(line, ">") && !leadingQuotePattern.MatchString(line) {
+			kind = GreentextNode
+		}
+		blocks = append(blocks, Node{Kind: kind, Children: parseInline(line)})
+	}
+	return blocks
+}
+
+func parseInline(s string) []Node {
+	nodes := make([]Node, 0)
+	text := strings.Builder{}
+	flush := func() {
+		if text.Len() > 0 {
+			nodes = append(nodes, Node{Kind: TextNode, Text: text.String()})
+			text.Reset()
+		}
+	}
+
+	for i := 0; i < len(s); {
+		rest := s[i:]
+		switch {
+		case rest[0] == '`':
+			if end := strings.IndexByte(rest[1:], '`'); end > 0 {
+				flush()
+				nodes = append(nodes, Node{Kind: CodeNode, Text: rest[1 : end+1]})
+				i += end + 2
+				continue
+			}
+		case strings.HasPrefix(rest, "**"):
+			if end := strings.Index(rest[2:], "**"); end > 0 {
+				flush()
+				nodes = append(nodes, Node{Kind: BoldNode, Children: parseInline(rest[2 : end+2])})
+				i += end + 4
+				continue
+			}
+		case rest[0] == '*':
+			if end := strings.IndexByte(rest[1:], '*'); end > 0 && rest[1] != ' ' {
+				flush()
+				nodes = append(nodes, Node{Kind: ItalicNode, Children: parseInline(rest[1 : end+1])})
+				i += end + 2
+				continue
+			}
+		case rest[0] == '>':
+			if loc := leadingQuotePattern.FindStringSubmatchIndex(rest); loc != nil {
+				if q, ok := quoteFromMatch(rest, loc); ok {
+					flush()
+					nodes = append(nodes, Node{Kind: QuoteNode, Quote: &q})
+					i += loc[1]
+					continue
+				}
+			}
+		}
+		text.WriteByte(s[i])
+		i++
+	}
+	flush()
+
+	return nodes
+}
+
+// ParseContent derives the structured representations of the post's content,
+// i.e. its markup and the posts it references.
+func (p *Post) ParseContent() {
+	p.Markup = ParseMarkup(p.Content)
+	p.Quotes = ParseQuotes(p.Content)
+}
+
+// MarkupOrParse returns the post's markup, parsing it first if necessary.
+func (p Post) MarkupOrParse() []Node {
+	if p.Markup != nil {
+		return p.Markup
+	}
+	return ParseMarkup(p.Content)
+}
@@ -0,0 +1,44 @@
+package tchan
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestParseMarkupBlocks(t *testing.T) {
+	blocks := ParseMarkup(">be me\n>>1 indeed\n```\nfoo *bar*\n```\nplain")
+	kinds := []NodeKind{}
+	for _, b := range blocks {
+		kinds = append(kinds, b.Kind)
+	}
+	expected := []NodeKind{GreentextNode, LineNode, CodeBlockNode, LineNode}
+	if !reflect.DeepEqual(kinds, expected) {
+		t.Fatalf("expected blocks %v, got %v", expected, kinds)
+	}
+	if blocks[2].Text != "foo *bar*" {
+		t.Errorf("expected code block to be kept verbatim, got %q", blocks[2].Text)
+	}
+}
+
+func TestParseMarkupInline(t *testing.T) {
+	blocks := ParseMarkup("a **b *c* d** `*d*` >>5 * e")
+	if len(blocks) != 1 {
+		t.Fatalf("expected a single line, got %d blocks", len(blocks))
+	}
+	expected := []Node{
+		{Kind: TextNode, Text: "a "},
+		{Kind: BoldNode, Children: []Node{
+			{Kind: TextNode, Text: "b "},
+			{Kind: ItalicNode, Children: []Node{{Kind: TextNode, Text: "c"}}},
+			{Kind: TextNode, Text: " d"},
+		}},
+		{Kind: TextNode, Text: " "},
+		{Kind: CodeNode, Text: "*d*"},
+		{Kind: TextNode, Text: " "},
+		{Kind: QuoteNode, Quote: &Quote{PostID: 5}},
+		{Kind: TextNode, Text: " * e"},
+	}
+	if !reflect.DeepEqual(blocks[0].Children, expected) {
+		t.Errorf("expected %+v, got %+v", expected, blocks[0].Children)
+	}
+}
@@ -8,6 +8,8 @@ import (
 	"net/http"
 	"os"
 	"path/filepath"
+	"regexp"
+	"strings"
 	"text/template"
 	"time"
 
@@ -23,16 +25,22 @@ type TemplateSet struct {
 	post    *template.Template
 	board   *template.Template
 	thread  *template.Template
+	catalog *template.Template
+	search  *template.Template
+	ban     *template.Template
 	error   *template.Template
 }
 
 func placeholders() template.FuncMap {
 	nothing := func(v interface{}) string { return "" }
 	return template.FuncMap{
-		"formatBoard": nothing,
-		"formatPost":  nothing,
-		"highlight":   nothing,
-		"timeANSIC":   nothing,
+		"formatBoard":   nothing,
+		"formatPost":    nothing,
+		"formatSnippet": nothing,
+		"highlight":     nothing,
+		"quoteLink":     nothing,
+		"resultLink":    nothing,
+		"timeANSIC":     nothing,
 	}
 }
 
@@ -55,6 +63,18 @@ func (t *TemplateSet) UseDefaults() {
 		template.New("thread.template").
 			Funcs(placeholders()).
 			Parse(output.DefaultThread))
+	t.catalog = template.Must(
+		template.New("catalog.template").
+			Funcs(placeholders()).
+			Parse(output.DefaultCatalog))
+	t.search = template.Must(
+		template.New("search.template").
+			Funcs(placeholders()).
+			Parse(output.DefaultSearch))
+	t.ban = template.Must(
+		template.New("ban.template").
+			Funcs(placeholders()).
+			Parse(output.DefaultBan))
 	t.error = template.Must(
 		template.New("error.template").
 			Funcs(placeholders()).
@@ -125,6 +145,24 @@ func (t *TemplateSet) Read(dir string) error {
 		t.board = tmpl
 	}
 
+	if tmpl, err := parseTemplateFile("catalog.template", dir); err != nil {
+		return err
+	} else if tmpl != nil {
+		t.catalog = tmpl
+	}
+
+	if tmpl, err := parseTemplateFile("search.template", dir); err != nil {
+		return err
+	} else if tmpl != nil {
+		t.search = tmpl
+	}
+
+	if tmpl, err := parseTemplateFile("ban.template", dir); err != nil {
+		return err
+	} else if tmpl != nil {
+		t.ban = tmpl
+	}
+
 	if tmpl, err := parseTemplateFile("error.template", dir); err != nil {
 		return err
 	} else if tmpl != nil {
@@ -172,7 +210,7 @@ func (w *Writer) WriteThread(thread tchan.Thread) error {
 	}
 	return w.temp.thread.
 		Funcs(template.FuncMap{
-			"formatPost":  w.postFormatter(thread.Board.Style),
+			"formatPost":  w.postFormatter(thread.Board),
 			"formatBoard": w.boardFormatter(),
 			"highlight":   w.highlighter(thread.Board.Style),
 			"timeANSIC":   w.timeFormatter(time.ANSIC),
@@ -180,6 +218,11 @@ func (w *Writer) WriteThread(thread tchan.Thread) error {
 		Execute(w.out, payload)
 }
 
+func (w *Writer) WritePost(board tchan.Board, post tchan.Post) error {
+	_, err := fmt.Fprintf(w.out, "%s\n%s\n", w.postFormatter(board)(post), defaults.Separator.Single)
+	return err
+}
+
 func (w *Writer) WriteBoard(board tchan.BoardOverview) error {
 	payload := struct {
 		Defaults            // embedded
@@ -191,7 +234,7 @@ func (w *Writer) WriteBoard(board tchan.BoardOverview) error {
 
 	return w.temp.board.
 		Funcs(template.FuncMap{
-			"formatPost":  w.postFormatter(board.Style),
+			"formatPost":  w.postFormatter(board.Board),
 			"formatBoard": w.boardFormatter(),
 			"highlight":   w.highlighter(board.Style),
 			"timeANSIC":   w.timeFormatter(time.ANSIC),
@@ -199,6 +242,62 @@ func (w *Writer) WriteBoard(board tchan.BoardOverview) error {
 		Execute(w.out, payload)
 }
 
+func (w *Writer) WriteCatalog(catalog tchan.Catalog) error {
+	payload := struct {
+		Defaults      // embedded
+		tchan.Catalog // embedded
+	}{
+		Defaults: defaults,
+		Catalog:  catalog,
+	}
+
+	return w.temp.catalog.
+		Funcs(template.FuncMap{
+			"formatBoard": w.boardFormatter(),
+			"highlight":   w.highlighter(catalog.Style),
+			"timeANSIC":   w.timeFormatter(time.ANSIC),
+		}).
+		Execute(w.out, payload)
+}
+
+func (w *Writer) WriteSearch(results tchan.SearchResults) error {
+	payload := struct {
+		Defaults            // embedded
+		tchan.SearchResults // embedded
+	}{
+		Defaults:      defaults,
+		SearchResults: results,
+	}
+
+	return w.temp.search.
+		Funcs(template.FuncMap{
+			"formatSnippet": w.formatSnippet,
+			"resultLink":    w.resultLink,
+			"timeANSIC":     w.timeFormatter(time.ANSIC),
+		}).
+		Execute(w.out, payload)
+}
+
+func (w *Writer) WriteBan(status int, ban tchan.Ban) error {
+	w.out.WriteHeader(status)
+	payload := struct {
+		Defaults  // embedded
+		tchan.Ban // embedded
+		Status    int
+	}{
+		Defaults: defaults,
+		Ban:      ban,
+		Status:   status,
+	}
+
+	return w.temp.ban.
+		Funcs(template.FuncMap{
+			"timeANSIC": w.timeFormatter(time.ANSIC),
+			"highlight": w.highlighter("red"),
+		}).
+		Execute(w.out, payload)
+}
+
 func (w *Writer) WriteError(status int, err error) error {
 	w.out.WriteHeader(status)
 	payload := struct {
@@ -219,18 +318,21 @@ func (w *Writer) WriteError(status int, err error) error {
 		Execute(w.out, payload)
 }
 
-func (w *Writer) postFormatter(styleName string) func(tchan.Post) string {
+func (w *Writer) postFormatter(board tchan.Board) func(tchan.Post) string {
 	return func(p tchan.Post) string {
 		payload := struct {
 			Defaults   // embedded
 			tchan.Post // embedded
+			Content    string
 		}{
 			Defaults: defaults,
 			Post:     p,
+			Content:  w.formatContent(board, p),
 		}
 		buf := bytes.Buffer{}
 		err := w.temp.post.Funcs(template.FuncMap{
-			"highlight": w.highlighter(styleName),
+			"highlight": w.highlighter(board.Style),
+			"quoteLink": w.quoteLinker(board),
 			"timeANSIC": w.timeFormatter(time.ANSIC),
 		}).Execute(&buf, payload)
 		if err != nil {
@@ -241,6 +343,81 @@ func (w *Writer) postFormatter(styleName string) func(tchan.Post) string {
 	}
 }
 
+// formatContent renders a post's markup through ANSI escape sequences.
+func (w *Writer) formatContent(board tchan.Board, p tchan.Post) string {
+	sb := strings.Builder{}
+	for i, block := range p.MarkupOrParse() {
+		if i > 0 {
+			sb.WriteString("\n")
+		}
+		switch block.Kind {
+		case tchan.GreentextNode:
+			w.writeStyled(&sb, board, block.Children, defaults.FgGreen, "")
+		case tchan.CodeBlockNode:
+			sb.WriteString(defaults.FgYellow + block.Text + defaults.End)
+		default:
+			w.writeInline(&sb, board, block.Children, "")
+		}
+	}
+	return sb.String()
+}
+
+// writeInline renders inline markup nodes. As the reset sequence clears all
+// attributes, the styles of enclosing nodes need to be restored afterwards.
+func (w *Writer) writeInline(sb *strings.Builder, board tchan.Board, nodes []tchan.Node, outer string) {
+	for _, n := range nodes {
+		switch n.Kind {
+		case tchan.BoldNode:
+			w.writeStyled(sb, board, n.Children, defaults.Bold, outer)
+		case tchan.ItalicNode:
+			w.writeStyled(sb, board, n.Children, defaults.Italic, outer)
+		case tchan.CodeNode:
+			sb.WriteString(defaults.FgYellow + n.Text + defaults.End + outer)
+		case tchan.QuoteNode:
+			sb.WriteString(w.formatQuote(board, *n.Quote) + outer)
+		default:
+			sb.WriteString(n.Text)
+		}
+	}
+}
+
+func (w *Writer) writeStyled(sb *strings.Builder, board tchan.Board, nodes []tchan.Node, style string, outer string) {
+	sb.WriteString(style)
+	w.writeInline(sb, board, nodes, outer+style)
+	sb.WriteString(defaults.End + outer)
+}
+
+func (w *Writer) quoteLinker(board tchan.Board) func(int64) string {
+	return func(id int64) string {
+		return w.formatQuote(board, tchan.Quote{PostID: id})
+	}
+}
+
+func (w *Writer) formatQuote(board tchan.Board, q tchan.Quote) string {
+	// Cross-board references are set apart by a different colour
+	if q.CrossBoard() && q.Board != board.Name {
+		return defaults.FgCyan + q.String() + defaults.End
+	}
+	return w.highlighter(board.Style)(q)
+}
+
+func (w *Writer) formatSnippet(r tchan.SearchResult) string {
+	highlight := w.highlighter(r.Style)
+	sb := strings.Builder{}
+	for _, part := range r.Snippet {
+		if part.Match {
+			sb.WriteString(highlight(part.Text))
+		} else {
+			sb.WriteString(part.Text)
+		}
+	}
+	return sb.String()
+}
+
+func (w *Writer) resultLink(r tchan.SearchResult) string {
+	return fmt.Sprintf("/%s/%d", w.highlighter(r.Style)(r.Board), r.ThreadID)
+}
+
 func (w *Writer) boardFormatter() func(tchan.Board) string {
 	return func(b tchan.Board) string {
 		return w.formatBoard(b)
@@ -294,6 +471,14 @@ func (w *Writer) style(name string) (string, bool) {
 	}
 }
 
+// Matches the SGR sequences used for colors and emphasis.
+var escapePattern = regexp.MustCompile("\u001b\\[[0-9;]*m")
+
+// Strip removes color and emphasis from rendered output, leaving plain text.
+func Strip(s string) string {
+	return escapePattern.ReplaceAllString(s, "")
+}
+
 type Defaults struct {
 	FgBlack   string
 	FgRed     string
@@ -303,6 +488,8 @@ type Defaults struct {
 	FgMagenta string
 	FgCyan    string
 	FgWhite   string
+	Bold      string
+	Italic    string
 	End       string
 	Separator struct {
 		Single string
@@ -319,6 +506,8 @@ var defaults Defaults = Defaults{
 	FgMagenta: "\u001b[35m",
 	FgCyan:    "\u001b[36m",
 	FgWhite:   "\u001b[37m",
+	Bold:      "\u001b[1m",
+	Italic:    "\u001b[3m",
 	End:       "\u001b[0m",
 	Separator: struct {
 		Single string
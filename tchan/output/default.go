@@ -53,26 +53,52 @@ const DefaultWelcome string = "{{ .FgGreen }}::::::::::::.,:::::: :::::::..   .
 	"{{ .Separator.Double }}\n" +
 	"{{ .FgGreen }}HAVE{{ .End }} {{ .FgBlue }}FUN{{ .End }}!\n"
 
-const DefaultPost = `[{{ .ID | highlight }}] {{ .Author }} wrote at {{ .Timestamp | timeANSIC }}
-
+const DefaultPost = `[{{ .ID | highlight }}] {{ if .Deleted }}{{ .FgBlack }}[deleted]{{ .End }}{{ else }}{{ .Author }}{{ with .Tripcode }} {{ $.FgGreen }}{{ . }}{{ $.End }}{{ end }} wrote at {{ .Timestamp | timeANSIC }}{{ end }}{{ with .QuotedBy }}
+Replies:{{ range . }} {{ . | quoteLink }}{{ end }}{{ end }}
+{{ if not .Deleted }}
 {{ .Content }}
-`
+{{ end }}`
 
-const DefaultThread = `/{{ .Board.Name | highlight }}/{{ .ID }} {{ .Topic }}
-{{ $ssep := .Separator.Single }}{{ $n := len .Posts }}{{ .Separator.Double }}
-{{ range .Posts }}{{ . | formatPost }}
+const DefaultThread = `/{{ .Board.Name | highlight }}/{{ .ID }} {{ .Topic }}{{ if .Sticky }} [sticky]{{ end }}{{ if .Locked }} [locked]{{ end }}{{ if .Archived }} [archived]{{ end }}
+{{ $ssep := .Separator.Single }}{{ $before := .OmittedBefore }}{{ .Separator.Double }}
+{{ range $i, $p := .Posts }}{{ $p | formatPost }}
+{{ $ssep }}
+{{ if and (eq $i 0) $before }}{{ $before }} {{ if eq $before 1 }}post{{ else }}posts{{ end }} omitted
 {{ $ssep }}
-{{ end }}{{ .NumReplies }} {{ if eq $n 2}}reply{{ else }}replies{{ end }}
+{{ end }}{{ end }}{{ with .OmittedAfter }}{{ . }} {{ if eq . 1 }}post{{ else }}posts{{ end }} omitted
+{{ $ssep }}
+{{ end }}{{ .NumReplies }} {{ if eq .NumReplies 1 }}reply{{ else }}replies{{ end }}
 `
 
 const DefaultBoard = `/{{ .Name | highlight }}/ - {{ .Descr | highlight }}
 {{ $dsep := .Separator.Double }}{{ $ssep := .Separator.Single }}{{ $board := .Name }}{{ $dsep }}
 {{ range .Threads }}
-/{{ $board | highlight }}/{{ .ID }} {{ .Topic }} ({{ .NumReplies }} {{ if eq 1 .NumReplies }}reply{{ else }}replies{{ end }}) updated {{ .Active | timeANSIC }}
+/{{ $board | highlight }}/{{ .ID }} {{ .Topic }}{{ if .Sticky }} [sticky]{{ end }}{{ if .Locked }} [locked]{{ end }} ({{ .NumReplies }} {{ if eq 1 .NumReplies }}reply{{ else }}replies{{ end }}) updated {{ .Active | timeANSIC }}
 {{ $ssep }}
 {{ .OP | formatPost }}
 {{ $dsep }}
-{{ end }}{{ $n := len .Threads }}{{ $n }} {{ if eq $n 1 }}thread{{ else }}threads{{ end }}
+{{ end }}{{ $n := len .Threads }}{{ $n }} {{ if eq $n 1 }}thread{{ else }}threads{{ end }}{{ if gt .NumPages 1 }}, page {{ .Page }} of {{ .NumPages }}{{ end }}
+`
+
+const DefaultCatalog = `/{{ .Name | highlight }}/ - {{ .Descr | highlight }} ({{ if .Archived }}archive{{ else }}catalog{{ end }})
+{{ .Separator.Double }}
+{{ range .Threads }}{{ printf "%8d" .ID | highlight }} {{ .Topic }}{{ if .Sticky }} [sticky]{{ end }}{{ if .Locked }} [locked]{{ end }} ({{ .NumReplies }} {{ if eq 1 .NumReplies }}reply{{ else }}replies{{ end }}) updated {{ .Active | timeANSIC }}
+{{ end }}{{ .Separator.Double }}
+{{ $n := len .Threads }}{{ $n }} {{ if eq $n 1 }}thread{{ else }}threads{{ end }}
+`
+
+const DefaultSearch = `Search{{ with .Board }} on /{{ . }}/{{ end }}: {{ .Query }}
+{{ $ssep := .Separator.Single }}{{ .Separator.Double }}
+{{ range .Results }}{{ . | resultLink }} {{ .Topic }}
+[{{ .PostID }}] {{ .Author }}{{ with .Tripcode }} {{ $.FgGreen }}{{ . }}{{ $.End }}{{ end }} wrote at {{ .Timestamp | timeANSIC }}
+{{ . | formatSnippet }}
+{{ $ssep }}
+{{ end }}{{ $n := len .Results }}{{ $n }} {{ if eq $n 1 }}result{{ else }}results{{ end }}
+`
+
+const DefaultBan = `{{ .Status }} {{ .FgRed }}BANNED{{ .End }}: you are banned from posting on {{ with .Board }}/{{ . | highlight }}/{{ else }}all boards{{ end }}
+Reason: {{ with .Reason }}{{ . }}{{ else }}none given{{ end }}
+{{ with .Expires }}Expires: {{ . | timeANSIC }}{{ else }}This ban does not expire.{{ end }}
 `
 
 const DefaultError = `{{ .Status }} {{ .FgRed }}ERROR{{ .End }}: {{ .Error }}
@@ -10,6 +10,7 @@ import (
 	"net/http"
 	"os"
 	"path/filepath"
+	"strings"
 	"time"
 
 	"github.com/pkg/errors"
@@ -24,16 +25,22 @@ type TemplateSet struct {
 	post    *template.Template
 	board   *template.Template
 	thread  *template.Template
+	catalog *template.Template
+	search  *template.Template
+	ban     *template.Template
 	error   *template.Template
 }
 
 func placeholders() template.FuncMap {
 	nothing := func(v interface{}) string { return "" }
 	return template.FuncMap{
-		"formatBoard": nothing,
-		"formatPost":  nothing,
-		"highlight":   nothing,
-		"timeANSIC":   nothing,
+		"formatBoard":   nothing,
+		"formatPost":    nothing,
+		"formatSnippet": nothing,
+		"highlight":     nothing,
+		"quoteLink":     nothing,
+		"resultLink":    nothing,
+		"timeANSIC":     nothing,
 	}
 }
 
@@ -56,6 +63,18 @@ func (t *TemplateSet) UseDefaults() {
 		template.New("thread.template").
 			Funcs(placeholders()).
 			Parse(output.DefaultThread))
+	t.catalog = template.Must(
+		template.New("catalog.template").
+			Funcs(placeholders()).
+			Parse(output.DefaultCatalog))
+	t.search = template.Must(
+		template.New("search.template").
+			Funcs(placeholders()).
+			Parse(output.DefaultSearch))
+	t.ban = template.Must(
+		template.New("ban.template").
+			Funcs(placeholders()).
+			Parse(output.DefaultBan))
 	t.error = template.Must(
 		template.New("error.template").
 			Funcs(placeholders()).
@@ -126,6 +145,24 @@ func (t *TemplateSet) Read(dir string) error {
 		t.board = tmpl
 	}
 
+	if tmpl, err := parseTemplateFile("catalog.template", dir); err != nil {
+		return err
+	} else if tmpl != nil {
+		t.catalog = tmpl
+	}
+
+	if tmpl, err := parseTemplateFile("search.template", dir); err != nil {
+		return err
+	} else if tmpl != nil {
+		t.search = tmpl
+	}
+
+	if tmpl, err := parseTemplateFile("ban.template", dir); err != nil {
+		return err
+	} else if tmpl != nil {
+		t.ban = tmpl
+	}
+
 	if tmpl, err := parseTemplateFile("error.template", dir); err != nil {
 		return err
 	} else if tmpl != nil {
@@ -196,7 +233,7 @@ func (w *Writer) WriteThread(thread tchan.Thread) error {
 		}
 		return w.temp.thread.
 			Funcs(template.FuncMap{
-				"formatPost":  w.postFormatter(thread.Board.Style),
+				"formatPost":  w.postFormatter(thread.Board),
 				"formatBoard": w.boardFormatter(),
 				"highlight":   w.highlighter(thread.Board.Style),
 				"timeANSIC":   w.timeFormatter(time.ANSIC),
@@ -205,6 +242,12 @@ func (w *Writer) WriteThread(thread tchan.Thread) error {
 	})
 }
 
+// WritePost writes a single post without the HTML header and footer.
+func (w *Writer) WritePost(board tchan.Board, post tchan.Post) error {
+	_, err := fmt.Fprintf(w.out, "%s\n%s\n", w.postFormatter(board)(post), defaults.Separator.Single)
+	return err
+}
+
 func (w *Writer) WriteBoard(board tchan.BoardOverview) error {
 	return w.withHeaderAndFooter(func() error {
 		payload := struct {
@@ -217,7 +260,7 @@ func (w *Writer) WriteBoard(board tchan.BoardOverview) error {
 
 		return w.temp.board.
 			Funcs(template.FuncMap{
-				"formatPost":  w.postFormatter(board.Style),
+				"formatPost":  w.postFormatter(board.Board),
 				"formatBoard": w.boardFormatter(),
 				"highlight":   w.highlighter(board.Style),
 				"timeANSIC":   w.timeFormatter(time.ANSIC),
@@ -226,9 +269,72 @@ func (w *Writer) WriteBoard(board tchan.BoardOverview) error {
 	})
 }
 
+func (w *Writer) WriteCatalog(catalog tchan.Catalog) error {
+	return w.withHeaderAndFooter(func() error {
+		payload := struct {
+			Defaults      // embedded
+			tchan.Catalog // embedded
+		}{
+			Defaults: defaults,
+			Catalog:  catalog,
+		}
+
+		return w.temp.catalog.
+			Funcs(template.FuncMap{
+				"formatBoard": w.boardFormatter(),
+				"highlight":   w.highlighter(catalog.Style),
+				"timeANSIC":   w.timeFormatter(time.ANSIC),
+			}).
+			Execute(w.out, payload)
+	})
+}
+
+func (w *Writer) WriteSearch(results tchan.SearchResults) error {
+	return w.withHeaderAndFooter(func() error {
+		payload := struct {
+			Defaults            // embedded
+			tchan.SearchResults // embedded
+		}{
+			Defaults:      defaults,
+			SearchResults: results,
+		}
+
+		return w.temp.search.
+			Funcs(template.FuncMap{
+				"formatSnippet": w.formatSnippet,
+				"resultLink":    w.resultLink,
+				"timeANSIC":     w.timeFormatter(time.ANSIC),
+			}).
+			Execute(w.out, payload)
+	})
+}
+
+func (w *Writer) WriteBan(status int, ban tchan.Ban) error {
+	w.out.WriteHeader(status)
+	return w.withHeaderAndFooter(func() error {
+		payload := struct {
+			Defaults  // embedded
+			tchan.Ban // embedded
+			Status    int
+		}{
+			Defaults: defaults,
+			Ban:      ban,
+			Status:   status,
+		}
+
+		return w.temp.ban.
+			Funcs(template.FuncMap{
+				"timeANSIC": w.timeFormatter(time.ANSIC),
+				"highlight": w.highlighter("red"),
+			}).
+			Execute(w.out, payload)
+	})
+}
+
 func (w *Writer) WriteError(status int, err error) error {
+	// Has to precede the HTML header, which would imply 200 OK
+	w.out.WriteHeader(status)
 	return w.withHeaderAndFooter(func() error {
-		w.out.WriteHeader(status)
 		payload := struct {
 			Defaults // embedded
 			Status   int
@@ -248,19 +354,23 @@ func (w *Writer) WriteError(status int, err error) error {
 	})
 }
 
-func (w *Writer) postFormatter(styleName string) func(tchan.Post) template.HTML {
+func (w *Writer) postFormatter(board tchan.Board) func(tchan.Post) template.HTML {
 	return func(p tchan.Post) template.HTML {
-		p.Content = html.EscapeString(p.Content)
 		payload := struct {
 			Defaults   // embedded
 			tchan.Post // embedded
+			Content    template.HTML
 		}{
 			Defaults: defaults,
 			Post:     p,
+			Content:  w.formatContent(board, p),
 		}
 		buf := bytes.Buffer{}
+		// Anchor for post references
+		fmt.Fprintf(&buf, "<span id=\"p%d\"></span>", p.ID)
 		err := w.temp.post.Funcs(template.FuncMap{
-			"highlight": w.highlighter(styleName),
+			"highlight": w.highlighter(board.Style),
+			"quoteLink": w.quoteLinker(board),
 			"timeANSIC": w.timeFormatter(time.ANSIC),
 		}).Execute(&buf, payload)
 		if err != nil {
@@ -271,6 +381,84 @@ func (w *Writer) postFormatter(styleName string) func(tchan.Post) template.HTML
 	}
 }
 
+// formatContent renders a post's markup as escaped HTML, using the classes
+// defined in the header.
+func (w *Writer) formatContent(board tchan.Board, p tchan.Post) template.HTML {
+	sb := strings.Builder{}
+	for i, block := range p.MarkupOrParse() {
+		if i > 0 {
+			sb.WriteString("\n")
+		}
+		switch block.Kind {
+		case tchan.GreentextNode:
+			w.writeStyled(&sb, board, block.Children, "greentext")
+		case tchan.CodeBlockNode:
+			fmt.Fprintf(&sb, "<span class=\"codeblock\">%s</span>", html.EscapeString(block.Text))
+		default:
+			w.writeInline(&sb, board, block.Children)
+		}
+	}
+	return template.HTML(sb.String())
+}
+
+func (w *Writer) writeInline(sb *strings.Builder, board tchan.Board, nodes []tchan.Node) {
+	for _, n := range nodes {
+		switch n.Kind {
+		case tchan.BoldNode:
+			w.writeStyled(sb, board, n.Children, "bold")
+		case tchan.ItalicNode:
+			w.writeStyled(sb, board, n.Children, "italic")
+		case tchan.CodeNode:
+			fmt.Fprintf(sb, "<code>%s</code>", html.EscapeString(n.Text))
+		case tchan.QuoteNode:
+			sb.WriteString(string(w.formatQuote(board, *n.Quote)))
+		default:
+			sb.WriteString(html.EscapeString(n.Text))
+		}
+	}
+}
+
+func (w *Writer) writeStyled(sb *strings.Builder, board tchan.Board, nodes []tchan.Node, class string) {
+	fmt.Fprintf(sb, "<span class=%q>", class)
+	w.writeInline(sb, board, nodes)
+	sb.WriteString("</span>")
+}
+
+func (w *Writer) quoteLinker(board tchan.Board) func(int64) template.HTML {
+	return func(id int64) template.HTML {
+		return w.formatQuote(board, tchan.Quote{PostID: id})
+	}
+}
+
+func (w *Writer) formatQuote(board tchan.Board, q tchan.Quote) template.HTML {
+	target, style := board.Name, board.Style
+	if q.CrossBoard() && q.Board != board.Name {
+		// Style of the other board is not known here
+		target, style = q.Board, "cyan"
+	}
+	return template.HTML(fmt.Sprintf(
+		"<a class=%q href=\"/%s/%d?format=html#p%d\">%s</a>",
+		style, target, q.PostID, q.PostID, html.EscapeString(q.String())))
+}
+
+func (w *Writer) formatSnippet(r tchan.SearchResult) template.HTML {
+	sb := strings.Builder{}
+	for _, part := range r.Snippet {
+		if part.Match {
+			fmt.Fprintf(&sb, "<span class=%q>%s</span>", r.Style, html.EscapeString(part.Text))
+		} else {
+			sb.WriteString(html.EscapeString(part.Text))
+		}
+	}
+	return template.HTML(sb.String())
+}
+
+func (w *Writer) resultLink(r tchan.SearchResult) template.HTML {
+	return template.HTML(fmt.Sprintf(
+		"<a href=\"/%s/%d?format=html#p%d\">/<span class=%q>%s</span>/%d</a>",
+		r.Board, r.ThreadID, r.PostID, r.Style, html.EscapeString(r.Board), r.ThreadID))
+}
+
 func (w *Writer) boardFormatter() func(tchan.Board) template.HTML {
 	return func(b tchan.Board) template.HTML {
 		return w.formatBoard(b)
@@ -304,6 +492,8 @@ type Defaults struct {
 	FgMagenta template.HTML
 	FgCyan    template.HTML
 	FgWhite   template.HTML
+	Bold      template.HTML
+	Italic    template.HTML
 	End       template.HTML
 	Separator struct {
 		Single template.HTML
@@ -320,6 +510,8 @@ var defaults Defaults = Defaults{
 	FgMagenta: "<span class=\"magenta\">",
 	FgCyan:    "<span class=\"cyan\">",
 	FgWhite:   "<span class=\"white\">",
+	Bold:      "<span class=\"bold\">",
+	Italic:    "<span class=\"italic\">",
 	End:       "</span>",
 	Separator: struct {
 		Single template.HTML
@@ -346,6 +538,10 @@ body { color: #ffffff; background-color: #202020; }
 .magenta { color: #ff00ff; }
 .cyan { color: #00ffff; }
 .white { color: #ffffff; }
+.bold { font-weight: bold; }
+.italic { font-style: italic; }
+.greentext { color: #789922; }
+code, .codeblock { color: #ffff00; background-color: #303030; }
 -->
 </style>
 </head>
@@ -21,17 +21,46 @@ func (w *Writer) write(obj interface{}) error {
 }
 
 func (w *Writer) WriteWelcome(boards []tchan.Board) error {
-	return w.write(boards)
+	public := make([]tchan.Board, 0, len(boards))
+	for _, b := range boards {
+		public = append(public, b.Public())
+	}
+	return w.write(public)
 }
 
 func (w *Writer) WriteThread(thread tchan.Thread) error {
+	thread.Board = thread.Board.Public()
 	return w.write(thread)
 }
 
+func (w *Writer) WritePost(board tchan.Board, post tchan.Post) error {
+	return w.write(post)
+}
+
 func (w *Writer) WriteBoard(board tchan.BoardOverview) error {
+	board.Board = board.Board.Public()
 	return w.write(board)
 }
 
+func (w *Writer) WriteCatalog(catalog tchan.Catalog) error {
+	catalog.Board = catalog.Board.Public()
+	return w.write(catalog)
+}
+
+func (w *Writer) WriteSearch(results tchan.SearchResults) error {
+	return w.write(results)
+}
+
+func (w *Writer) WriteBan(status int, ban tchan.Ban) error {
+	wrapper := struct {
+		Status int       `json:"status"`
+		Error  string    `json:"error"`
+		Ban    tchan.Ban `json:"ban"`
+	}{Status: status, Error: "banned", Ban: ban}
+	w.res.WriteHeader(status)
+	return w.write(wrapper)
+}
+
 func (w *Writer) WriteError(status int, err error) error {
 	wrapper := struct {
 		Status int    `json:"status"`
@@ -15,7 +15,12 @@ import (
 type Writer interface {
 	WriteWelcome(boards []tchan.Board) error
 	WriteThread(thread tchan.Thread) error
+	// WritePost writes a single post, e.g. one added to a followed thread.
+	WritePost(board tchan.Board, post tchan.Post) error
 	WriteBoard(board tchan.BoardOverview) error
+	WriteCatalog(catalog tchan.Catalog) error
+	WriteSearch(results tchan.SearchResults) error
+	WriteBan(status int, ban tchan.Ban) error
 	WriteError(status int, err error) error
 }
 
@@ -59,6 +64,18 @@ func WriteTemplates(dir string) error {
 		return err
 	}
 
+	if err := writeTemplate(dir, "catalog.template", []byte(DefaultCatalog)); err != nil {
+		return err
+	}
+
+	if err := writeTemplate(dir, "search.template", []byte(DefaultSearch)); err != nil {
+		return err
+	}
+
+	if err := writeTemplate(dir, "ban.template", []byte(DefaultBan)); err != nil {
+		return err
+	}
+
 	if err := writeTemplate(dir, "error.template", []byte(DefaultError)); err != nil {
 		return err
 	}
@@ -0,0 +1,87 @@
+package tchan
+
+import (
+	"fmt"
+	"regexp"
+	"strconv"
+)
+
+// Matches both cross-board references (>>>/g/123) and same-board references
+// (>>123). The cross-board alternative has to come first.
+var quotePattern = regexp.MustCompile(`>>>/([a-zA-Z0-9]+)/([0-9]+)|>>([0-9]+)`)
+
+// Quote is a reference to another post. An empty board name refers to the
+// board the quoting post was made on.
+type Quote struct {
+	Board  string `json:"board,omitempty"`
+	PostID int64  `json:"postId"`
+}
+
+// CrossBoard tells whether the quote references a post on another board.
+func (q Quote) CrossBoard() bool {
+	return q.Board != ""
+}
+
+func (q Quote) String() string {
+	if q.CrossBoard() {
+		return fmt.Sprintf(">>>/%s/%d", q.Board, q.PostID)
+	}
+	return fmt.Sprintf(">>%d", q.PostID)
+}
+
+func quoteFromMatch(content string, loc []int) (Quote, bool) {
+	var q Quote
+	var idStr string
+	if loc[2] >= 0 {
+		q.Board = content[loc[2]:loc[3]]
+		idStr = content[loc[4]:loc[5]]
+	} else {
+		idStr = content[loc[6]:loc[7]]
+	}
+
+	id, err := strconv.ParseInt(idStr, 10, 64)
+	if err != nil {
+		// Too large to be a post ID, treat as regular text
+		return q, false
+	}
+	q.PostID = id
+	return q, true
+}
+
+// ParseQuotes extracts all post references from a post's content, in order
+// of appearance.
+func ParseQuotes(content string) []Quote {
+	quotes := make([]Quote, 0)
+	for _, loc := range quotePattern.FindAllStringSubmatchIndex(content, -1) {
+		if q, ok := quoteFromMatch(content, loc); ok {
+			quotes = append(quotes, q)
+		}
+	}
+	return quotes
+}
+
+// LinkQuotes parses the references in all posts of the thread and records
+// for each post which other posts of the thread have replied to it.
+func (t *Thread) LinkQuotes() {
+	byID := make(map[int64]int, len(t.Posts))
+	for i := range t.Posts {
+		byID[t.Posts[i].ID] = i
+		t.Posts[i].ParseContent()
+		t.Posts[i].QuotedBy = make([]int64, 0)
+	}
+
+	for _, p := range t.Posts {
+		seen := make(map[int64]bool)
+		for _, q := range p.Quotes {
+			if q.CrossBoard() && q.Board != t.Board.Name || q.PostID == p.ID {
+				continue
+			}
+			target, ok := byID[q.PostID]
+			if !ok || seen[q.PostID] {
+				continue
+			}
+			seen[q.PostID] = true
+			t.Posts[target].QuotedBy = append(t.Posts[target].QuotedBy, p.ID)
+		}
+	}
+}
@@ -0,0 +1,40 @@
+package tchan
+
+import (
+	"testing"
+)
+
+func TestParseQuotes(t *testing.T) {
+	quotes := ParseQuotes(">>12 see also >>>/g/34 and >>>/x/ or >>99999999999999999999")
+	if len(quotes) != 2 {
+		t.Fatalf("expected 2 quotes, got %d: %v", len(quotes), quotes)
+	}
+	if quotes[0] != (Quote{PostID: 12}) {
+		t.Errorf("expected >>12, got %v", quotes[0])
+	}
+	if quotes[1] != (Quote{Board: "g", PostID: 34}) {
+		t.Errorf("expected >>>/g/34, got %v", quotes[1])
+	}
+}
+
+func TestLinkQuotes(t *testing.T) {
+	thr := Thread{
+		Board: Board{Name: "g"},
+		Posts: []Post{
+			{ID: 1, Content: "op"},
+			{ID: 2, Content: ">>1 >>1"},
+			{ID: 3, Content: ">>>/g/1 >>2 >>>/b/2 >>7 >>3"},
+		},
+	}
+	thr.LinkQuotes()
+
+	if by := thr.Posts[0].QuotedBy; len(by) != 2 || by[0] != 2 || by[1] != 3 {
+		t.Errorf("expected post 1 to be quoted by [2 3], got %v", by)
+	}
+	if by := thr.Posts[1].QuotedBy; len(by) != 1 || by[0] != 3 {
+		t.Errorf("expected post 2 to be quoted by [3], got %v", by)
+	}
+	if by := thr.Posts[2].QuotedBy; by == nil || len(by) != 0 {
+		t.Errorf("expected post 3 to have no replies, got %v", by)
+	}
+}
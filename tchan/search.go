@@ -0,0 +1,67 @@
+package tchan
+
+import (
+	"strings"
+	"time"
+)
+
+// SearchResults holds the posts matching a search query.
+type SearchResults struct {
+	Query string `json:"query"`
+	// Empty when searching all boards
+	Board   string         `json:"board,omitempty"`
+	Results []SearchResult `json:"results"`
+}
+
+// SearchResult is a single post matching a search query.
+type SearchResult struct {
+	Board     string        `json:"board"`
+	ThreadID  int64         `json:"thread"`
+	PostID    int64         `json:"id"`
+	Topic     string        `json:"topic"`
+	Author    string        `json:"author"`
+	Tripcode  string        `json:"tripcode,omitempty"`
+	Timestamp time.Time     `json:"timestamp"`
+	Snippet   []SnippetPart `json:"snippet"`
+	// Board style, used for highlighting matches
+	Style string `json:"-"`
+	// Relevance, lower is better
+	Rank float64 `json:"-"`
+}
+
+// SnippetPart is a piece of a search result's excerpt, either matching the
+// query or not.
+type SnippetPart struct {
+	Text  string `json:"text"`
+	Match bool   `json:"match,omitempty"`
+}
+
+// Markers delimiting matches in raw snippets.
+const (
+	SnippetMatchStart = "\x01"
+	SnippetMatchEnd   = "\x02"
+)
+
+// ParseSnippet splits a raw snippet along its match markers.
+func ParseSnippet(raw string) []SnippetPart {
+	parts := make([]SnippetPart, 0)
+	for raw != "" {
+		start := strings.Index(raw, SnippetMatchStart)
+		if start < 0 {
+			parts = append(parts, SnippetPart{Text: raw})
+			break
+		}
+		if start > 0 {
+			parts = append(parts, SnippetPart{Text: raw[:start]})
+		}
+		raw = raw[start+len(SnippetMatchStart):]
+
+		end := strings.Index(raw, SnippetMatchEnd)
+		if end < 0 {
+			end = len(raw)
+		}
+		parts = append(parts, SnippetPart{Text: raw[:end], Match: true})
+		raw = strings.TrimPrefix(raw[end:], SnippetMatchEnd)
+	}
+	return parts
+}
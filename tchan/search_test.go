@@ -0,0 +1,19 @@
+package tchan
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestParseSnippet(t *testing.T) {
+	raw := "..." + SnippetMatchStart + "cats" + SnippetMatchEnd + " and " + SnippetMatchStart + "dogs"
+	expected := []SnippetPart{
+		{Text: "..."},
+		{Text: "cats", Match: true},
+		{Text: " and "},
+		{Text: "dogs", Match: true},
+	}
+	if parts := ParseSnippet(raw); !reflect.DeepEqual(parts, expected) {
+		t.Errorf("expected %+v, got %+v", expected, parts)
+	}
+}
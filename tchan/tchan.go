@@ -7,7 +7,9 @@ import (
 const (
 	maxThreadsDefault      = 50
 	maxThreadLengthDefault = 100
+	maxPagesDefault        = 10
 	maxPostBytesDefault    = 4096
+	postsPerPageDefault    = 50
 )
 
 // Board contains the configured settings for a board.
@@ -17,7 +19,17 @@ type Board struct {
 	Style           string `json:"style"`
 	ThreadsMax      int    `json:"maxThreads,omitempty"`
 	ThreadLengthMax int    `json:"maxThreadLength,omitempty"`
+	PagesMax        int    `json:"maxPages,omitempty"`
 	PostBytesMax    int    `json:"maxPostBytes,omitempty"`
+	PageLength      int    `json:"postsPerPage,omitempty"`
+	// Flood protection, disabled unless positive; all values in seconds
+	PostCooldownSecs   int `json:"postCooldown,omitempty"`
+	ThreadCooldownSecs int `json:"threadCooldown,omitempty"`
+	DuplicateSecs      int `json:"duplicateWindow,omitempty"`
+	// Applied to post content in order
+	Filters []Filter `json:"filters,omitempty"`
+	// Ephemeral boards are kept in memory only, losing all threads on shutdown
+	Ephemeral bool `json:"ephemeral,omitempty"`
 }
 
 // MaxThreads returns the maximum number of active threads to be displayed on
@@ -35,7 +47,16 @@ func (b Board) MaxThreadLength() int {
 	if b.ThreadLengthMax > 0 {
 		return b.ThreadLengthMax
 	}
-	return maxThreadsDefault
+	return maxThreadLengthDefault
+}
+
+// MaxPages returns the number of pages of active threads on this board.
+// Threads falling off the last page are archived.
+func (b Board) MaxPages() int {
+	if b.PagesMax > 0 {
+		return b.PagesMax
+	}
+	return maxPagesDefault
 }
 
 // MaxPostBytes returns the maximum length (in bytes) for post content on this
@@ -47,19 +68,67 @@ func (b Board) MaxPostBytes() int {
 	return maxPostBytesDefault
 }
 
+// PostsPerPage returns the number of replies on each page when viewing a
+// thread page by page.
+func (b Board) PostsPerPage() int {
+	if b.PageLength > 0 {
+		return b.PageLength
+	}
+	return postsPerPageDefault
+}
+
+// PostCooldown returns the time a client has to wait between two posts on
+// this board. Zero if disabled.
+func (b Board) PostCooldown() time.Duration {
+	return seconds(b.PostCooldownSecs)
+}
+
+// ThreadCooldown returns the time a client has to wait between creating two
+// threads on this board. Zero if disabled.
+func (b Board) ThreadCooldown() time.Duration {
+	return seconds(b.ThreadCooldownSecs)
+}
+
+// DuplicateWindow returns the time during which a client cannot post the
+// same content twice on this board. Zero if disabled.
+func (b Board) DuplicateWindow() time.Duration {
+	return seconds(b.DuplicateSecs)
+}
+
+func seconds(n int) time.Duration {
+	if n > 0 {
+		return time.Duration(n) * time.Second
+	}
+	return 0
+}
+
 // Post contains all data of a single post.
 type Post struct {
 	ID        int64     `json:"id"`
 	Author    string    `json:"author"`
+	Tripcode  string    `json:"tripcode,omitempty"`
 	Timestamp time.Time `json:"timestamp"`
 	Content   string    `json:"content"`
+	Markup    []Node    `json:"markup"`
+	Quotes    []Quote   `json:"quotes"`
+	QuotedBy  []int64   `json:"quotedBy"`
+	Deleted   bool      `json:"deleted,omitempty"`
+	// Never exposed
+	AuthorIP string `json:"-"`
 }
 
-// Thread contains all data of a single thread.
+// Thread contains all data of a single thread. If only part of the thread
+// was fetched, Posts holds the OP and the selected replies while the number
+// of replies left out is recorded before and after the selection.
 type Thread struct {
-	Board Board  `json:"board"`
-	Topic string `json:"topic"`
-	Posts []Post `json:"posts"`
+	Board         Board  `json:"board"`
+	Topic         string `json:"topic"`
+	Posts         []Post `json:"posts"`
+	OmittedBefore int    `json:"omittedBefore,omitempty"`
+	OmittedAfter  int    `json:"omittedAfter,omitempty"`
+	Archived      bool   `json:"archived,omitempty"`
+	Locked        bool   `json:"locked,omitempty"`
+	Sticky        bool   `json:"sticky,omitempty"`
 }
 
 // ID returns the thread's associated ID, i.e. the OP's post ID.
@@ -69,7 +138,7 @@ func (t Thread) ID() int64 {
 
 // NumReplies returns the number of replies this thread has received.
 func (t Thread) NumReplies() int {
-	return len(t.Posts) - 1
+	return len(t.Posts) - 1 + t.OmittedBefore + t.OmittedAfter
 }
 
 // ThreadSummary contains superficial thread data.
@@ -78,6 +147,8 @@ type ThreadSummary struct {
 	OP         Post      `json:"op"`
 	NumReplies int       `json:"numReplies"`
 	Active     time.Time `json:"active"`
+	Locked     bool      `json:"locked,omitempty"`
+	Sticky     bool      `json:"sticky,omitempty"`
 }
 
 // ID returns the thread's associated ID, i.e. the OP's post ID.
@@ -85,8 +156,28 @@ func (t ThreadSummary) ID() int64 {
 	return t.OP.ID
 }
 
-// BoardOverview contains superficial board data.
+// BoardOverview contains superficial board data for one page of threads.
 type BoardOverview struct {
+	Board                    // embedded
+	Threads  []ThreadSummary `json:"threads"`
+	Page     int             `json:"page"`
+	NumPages int             `json:"numPages"`
+}
+
+// CatalogEntry contains minimal thread data.
+type CatalogEntry struct {
+	ID         int64     `json:"id"`
+	Topic      string    `json:"topic"`
+	NumReplies int       `json:"numReplies"`
+	Active     time.Time `json:"active"`
+	Locked     bool      `json:"locked,omitempty"`
+	Sticky     bool      `json:"sticky,omitempty"`
+}
+
+// Catalog contains a compact listing of either all active or all archived
+// threads of a board.
+type Catalog struct {
 	Board                   // embedded
-	Threads []ThreadSummary `json:"threads"`
+	Threads  []CatalogEntry `json:"threads"`
+	Archived bool           `json:"archived"`
 }
@@ -0,0 +1,82 @@
+// Package tripcode implements tripcodes, allowing posters to prove their
+// identity without registration.
+package tripcode
+
+import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/base64"
+	"strings"
+	"unicode"
+
+	"github.com/pkg/errors"
+)
+
+const (
+	// Length of the encoded hash, excluding the prefix
+	codeLength = 10
+	// Name used when only a password is given
+	anonymous = "Anonymous"
+)
+
+// ErrNoSecret signals that a secure tripcode was requested but no secret is
+// configured.
+var ErrNoSecret = errors.New("secure tripcodes are not enabled")
+
+// ErrReservedName signals a name which could be mistaken for a tripcode.
+var ErrReservedName = errors.New("names must not contain '!'")
+
+func encode(sum []byte) string {
+	return base64.RawURLEncoding.EncodeToString(sum)[:codeLength]
+}
+
+// Insecure computes a tripcode from a password alone. It is the same across
+// all servers.
+func Insecure(password string) string {
+	sum := sha256.Sum256([]byte(password))
+	return "!" + encode(sum[:])
+}
+
+// Secure computes a tripcode from a password and a server-side secret. It
+// can not be computed without knowing the secret.
+func Secure(password string, secret string) string {
+	mac := hmac.New(sha256.New, []byte(secret))
+	mac.Write([]byte(password))
+	return "!!" + encode(mac.Sum(nil))
+}
+
+// Split separates a name field of the form name#password or name##password
+// into the name and the corresponding tripcode. Without a password, only the
+// name is returned. Control characters are removed from the name and names
+// resembling a tripcode are rejected.
+func Split(field string, secret string) (string, string, error) {
+	name, password := field, ""
+	if idx := strings.Index(field, "#"); idx >= 0 {
+		name, password = field[:idx], field[idx+1:]
+	}
+
+	name = strings.TrimSpace(strings.Map(func(r rune) rune {
+		if unicode.IsControl(r) {
+			return -1
+		}
+		return r
+	}, name))
+	if strings.Contains(name, "!") {
+		return name, "", ErrReservedName
+	}
+	if name == "" {
+		name = anonymous
+	}
+
+	switch {
+	case password == "" || password == "#":
+		return name, "", nil
+	case strings.HasPrefix(password, "#"):
+		if secret == "" {
+			return name, "", ErrNoSecret
+		}
+		return name, Secure(password[1:], secret), nil
+	default:
+		return name, Insecure(password), nil
+	}
+}
@@ -0,0 +1,65 @@
+package tripcode
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestSplitWithoutPassword(t *testing.T) {
+	name, code, err := Split("anon", "")
+	if err != nil || name != "anon" || code != "" {
+		t.Errorf("expected name to be unchanged, got %q %q %v", name, code, err)
+	}
+}
+
+func TestSplitInsecure(t *testing.T) {
+	name, code, err := Split("me#hunter2", "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if name != "me" {
+		t.Errorf("expected name me, got %q", name)
+	}
+	if code != Insecure("hunter2") || !strings.HasPrefix(code, "!") || len(code) != codeLength+1 {
+		t.Errorf("unexpected tripcode %q", code)
+	}
+	if Insecure("hunter3") == code {
+		t.Errorf("expected different passwords to yield different tripcodes")
+	}
+}
+
+func TestSplitSecure(t *testing.T) {
+	if _, _, err := Split("me##hunter2", ""); err != ErrNoSecret {
+		t.Errorf("expected secure tripcode to require a secret, got %v", err)
+	}
+
+	name, code, err := Split("##hunter2", "salt")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if name != anonymous {
+		t.Errorf("expected empty name to become %q, got %q", anonymous, name)
+	}
+	if !strings.HasPrefix(code, "!!") || code == Secure("hunter2", "pepper") {
+		t.Errorf("expected tripcode to depend on the secret, got %q", code)
+	}
+}
+
+func TestSplitEmptyPassword(t *testing.T) {
+	for _, field := range []string{"me#", "me##"} {
+		name, code, err := Split(field, "")
+		if err != nil || name != "me" || code != "" {
+			t.Errorf("%s: expected no tripcode, got %q %q %v", field, name, code, err)
+		}
+	}
+}
+
+func TestSplitImpersonation(t *testing.T) {
+	if _, _, err := Split("me !MMlS-rEiw_", ""); err != ErrReservedName {
+		t.Errorf("expected fake tripcode to be rejected, got %v", err)
+	}
+	name, _, err := Split("me \x1b[32mreal\x1b[0m#pw", "")
+	if err != nil || name != "me [32mreal[0m" {
+		t.Errorf("expected control characters to be removed, got %q %v", name, err)
+	}
+}